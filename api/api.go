@@ -9,17 +9,22 @@ type FindUserReq struct {
 }
 
 type UserInfo struct {
-	Id           int
-	Username     string
-	RoleName     string
-	RoleNo       string
-	UserNo       string
-	ReviewStatus string
-	IsDisabled   int
-	CreateTime   util.ETime
-	CreateBy     string
-	UpdateTime   util.ETime
-	UpdateBy     string
+	Id            int
+	Username      string
+	RoleName      string
+	RoleNo        string
+	UserNo        string
+	ReviewStatus  string
+	IsDisabled    int
+	Email         string
+	DisplayName   string
+	Phone         string
+	Attributes    UserAttributes
+	EmailVerified bool
+	CreateTime    util.ETime
+	CreateBy      string
+	UpdateTime    util.ETime
+	UpdateBy      string
 }
 
 type FetchNameByUserNoReq struct {
@@ -52,3 +57,12 @@ type CreateNotificationReq struct {
 	Message         string `valid:"maxLen:1000"`
 	ReceiverUserNos []string
 }
+
+type CheckTokenRevokedReq struct {
+	UserNo   string `json:"userNo" valid:"notEmpty"`
+	IssuedAt int64  `json:"issuedAt" desc:"claim 'iatms' of the token (unix milliseconds), or claim 'iat' * 1000 if 'iatms' is absent"`
+}
+
+type CheckTokenRevokedRes struct {
+	Revoked bool `json:"revoked"`
+}
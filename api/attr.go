@@ -0,0 +1,43 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Custom attributes of user, attributes are defined per deployment.
+//
+// UserAttributes implements sql.Scanner and driver.Valuer, it's stored as json string in database.
+type UserAttributes map[string]string
+
+func (a UserAttributes) Value() (driver.Value, error) {
+	if len(a) < 1 {
+		return "", nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (a *UserAttributes) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*a = UserAttributes{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unable to scan %T into UserAttributes", value)
+	}
+	if len(b) < 1 {
+		*a = UserAttributes{}
+		return nil
+	}
+	return json.Unmarshal(b, a)
+}
@@ -89,3 +89,15 @@ func CreateNotification(rail miso.Rail, req CreateNotificationReq) error {
 	}
 	return resp.Err()
 }
+
+func CheckTokenRevoked(rail miso.Rail, req CheckTokenRevokedReq) (bool, error) {
+	var r miso.GnResp[CheckTokenRevokedRes]
+	err := miso.NewDynTClient(rail, "/remote/token/revoked", ServiceName).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return false, fmt.Errorf("failed to CheckTokenRevoked, %w", err)
+	}
+	res, err := r.Res()
+	return res.Revoked, err
+}
@@ -12,6 +12,11 @@ var (
 					LogPayload().
 					MaxRetry(3).
 					Document("CreateNotifiByAccessPipeline", "Pipeline that creates notifications to users who have access to the specified resource", "user-vault")
+
+	CreateNotifiByGroupPipeline = rabbit.NewEventPipeline[CreateNotifiByGroupEvent]("pieline.user-vault.create-notifi.by-group").
+					LogPayload().
+					MaxRetry(3).
+					Document("CreateNotifiByGroupPipeline", "Pipeline that creates notifications to members of the specified user group", "user-vault")
 )
 
 type CreateNotifiEvent struct {
@@ -25,3 +30,9 @@ type CreateNotifiByAccessEvent struct {
 	Message string `valid:"maxLen:1000" desc:"notification content"`
 	ResCode string `valid:"notEmpty" desc:"resource code"`
 }
+
+type CreateNotifiByGroupEvent struct {
+	Title   string `valid:"maxLen:255" desc:"notification title"`
+	Message string `valid:"maxLen:1000" desc:"notification content"`
+	GroupNo string `valid:"notEmpty" desc:"user group no"`
+}
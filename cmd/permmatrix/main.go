@@ -0,0 +1,61 @@
+// Export effective permission matrix of user-vault as JSON or CSV.
+//
+//	go run ./cmd/permmatrix -conf conf.yml -format csv -view roles -group vfm -out matrix.csv
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/user-vault/internal/vault"
+)
+
+func main() {
+	conf := flag.String("conf", "conf.yml", "config file, only the mysql properties are used")
+	format := flag.String("format", "json", "json or csv")
+	view := flag.String("view", vault.PermMatrixViewRoles, "view of csv: roles, users or unreachable")
+	group := flag.String("group", "", "only include paths of the group")
+	resCode := flag.String("res", "", "only include the resource, or the resources matched by the wildcard pattern")
+	out := flag.String("out", "", "output file, stdout by default")
+	flag.Parse()
+
+	rail := miso.EmptyRail()
+	if err := run(rail, *conf, *format, *view, vault.PermMatrixReq{Group: *group, ResCode: *resCode}, *out); err != nil {
+		rail.Errorf("Failed to export permission matrix, %v", err)
+		os.Exit(1)
+	}
+}
+
+func run(rail miso.Rail, conf string, format string, view string, req vault.PermMatrixReq, out string) error {
+	if err := miso.LoadConfigFromFile(conf, rail); err != nil {
+		return err
+	}
+	if err := mysql.InitMySQLFromProp(rail); err != nil {
+		return err
+	}
+	m, err := vault.BuildPermMatrix(rail, mysql.GetMySQL(), req)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "csv" {
+		return vault.WritePermMatrixCsv(w, m, view)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
@@ -0,0 +1,616 @@
+// Package cond implements a small expression language for attribute-based conditions.
+//
+// A condition is a boolean expression over attributes, e.g.,
+//
+//	cidr(ip, "10.0.0.0/8", "192.168.0.0/16") && weekday in ["MON", "TUE", "WED", "THU", "FRI"] && time >= "09:00"
+//
+// Supported syntax:
+//
+//   - literals: strings ('..' or ".."), numbers, true, false and lists ([a, b, c])
+//   - attributes: identifiers that may contain dots, e.g., ip, user.department
+//   - comparison: ==, !=, <, <=, >, >=, in
+//   - logical: &&, ||, !, and parentheses
+//   - functions: cidr(ip, range...), startsWith(s, prefix), endsWith(s, suffix)
+//
+// Expressions are evaluated without side effects, there are no loops or assignments.
+package cond
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Max length of condition expression.
+	MaxLen = 2000
+)
+
+// Resolve value of attribute, ok is false if the attribute is unknown.
+//
+// Values can be string, bool, int, int64 or float64.
+type Resolver func(name string) (v any, ok bool)
+
+// Parsed condition.
+type Cond struct {
+	src   string
+	root  node
+	attrs []string
+}
+
+// Parse condition expression.
+func Parse(src string) (*Cond, error) {
+	src = strings.TrimSpace(src)
+	if src == "" {
+		return nil, errors.New("condition is empty")
+	}
+	if len(src) > MaxLen {
+		return nil, fmt.Errorf("condition must have at most %d characters", MaxLen)
+	}
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, attrs: map[string]struct{}{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("unexpected token '%v' at %d", p.peek().val, p.peek().pos)
+	}
+	c := &Cond{src: src, root: root}
+	for a := range p.attrs {
+		c.attrs = append(c.attrs, a)
+	}
+	return c, nil
+}
+
+// Parse and evaluate condition expression.
+func Eval(src string, r Resolver) (bool, error) {
+	c, err := Parse(src)
+	if err != nil {
+		return false, err
+	}
+	return c.Eval(r)
+}
+
+// Evaluate the condition, the result must be a boolean.
+func (c *Cond) Eval(r Resolver) (bool, error) {
+	v, err := c.root.eval(r)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is not evaluated to a boolean, got %v", v)
+	}
+	return b, nil
+}
+
+// Whether the condition is a boolean expression, i.e., a comparison, a logical expression or a function call.
+//
+// Constants and attributes alone are not boolean expressions, even though they may be evaluated to booleans.
+func (c *Cond) IsBoolean() bool {
+	switch c.root.(type) {
+	case cmpNode, logicalNode, notNode, callNode:
+		return true
+	}
+	return false
+}
+
+// Attributes referenced in the condition.
+func (c *Cond) Attrs() []string {
+	return c.attrs
+}
+
+func (c *Cond) String() string {
+	return c.src
+}
+
+type tokenType int
+
+const (
+	tokIdent tokenType = iota
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, sb.String(), i})
+			i = j + 1
+		case isDigit(c) || (c == '-' && i+1 < len(src) && isDigit(src[i+1])):
+			j := i + 1
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, src[i:j], i})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(src) && (isIdentStart(src[j]) || isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	attrs  map[string]struct{}
+}
+
+func (p *parser) eof() bool {
+	return p.i >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.eof() {
+		return token{typ: tokOp, val: "", pos: -1}
+	}
+	return p.tokens[p.i]
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return !p.eof() && t.typ == tokOp && t.val == op
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		if p.eof() {
+			return fmt.Errorf("expecting '%v' but reached the end", op)
+		}
+		return fmt.Errorf("expecting '%v' at %d", op, p.peek().pos)
+	}
+	p.i++
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.i++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.i++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!") {
+		p.i++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *parser) parseCmp() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if p.eof() {
+		return left, nil
+	}
+	switch {
+	case t.typ == tokOp && (t.val == "==" || t.val == "!=" || t.val == "<" || t.val == "<=" || t.val == ">" || t.val == ">="),
+		t.typ == tokIdent && t.val == "in":
+		p.i++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return cmpNode{op: t.val, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.eof() {
+		return nil, errors.New("unexpected end of condition")
+	}
+	t := p.peek()
+	p.i++
+	switch t.typ {
+	case tokString:
+		return literalNode{t.val}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%v' at %d", t.val, t.pos)
+		}
+		return literalNode{f}, nil
+	case tokIdent:
+		switch t.val {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "in":
+			return nil, fmt.Errorf("unexpected 'in' at %d", t.pos)
+		}
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+		p.attrs[t.val] = struct{}{}
+		return attrNode{t.val}, nil
+	}
+
+	switch t.val {
+	case "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case "[":
+		var items []node
+		for !p.isOp("]") {
+			if len(items) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			n, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, n)
+		}
+		p.i++
+		return listNode{items}, nil
+	}
+	return nil, fmt.Errorf("unexpected token '%v' at %d", t.val, t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := funcs[name.val]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%v' at %d", name.val, name.pos)
+	}
+	p.i++ // (
+	var args []node
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, n)
+	}
+	p.i++
+	if len(args) < fn.minArgs || (fn.maxArgs > 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("invalid number of arguments for function '%v' at %d", name.val, name.pos)
+	}
+	return callNode{name: name.val, fn: fn, args: args}, nil
+}
+
+type node interface {
+	eval(r Resolver) (any, error)
+}
+
+type literalNode struct {
+	v any
+}
+
+func (n literalNode) eval(r Resolver) (any, error) {
+	return n.v, nil
+}
+
+type attrNode struct {
+	name string
+}
+
+func (n attrNode) eval(r Resolver) (any, error) {
+	v, ok := r(n.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown attribute '%v'", n.name)
+	}
+	switch t := v.(type) {
+	case int:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case string, bool, float64:
+		return t, nil
+	}
+	return nil, fmt.Errorf("unsupported value of attribute '%v': %v", n.name, v)
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n listNode) eval(r Resolver) (any, error) {
+	l := make([]any, 0, len(n.items))
+	for _, it := range n.items {
+		v, err := it.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+type notNode struct {
+	n node
+}
+
+func (n notNode) eval(r Resolver) (any, error) {
+	v, err := evalBool(n.n, r)
+	if err != nil {
+		return nil, err
+	}
+	return !v, nil
+}
+
+type logicalNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n logicalNode) eval(r Resolver) (any, error) {
+	l, err := evalBool(n.left, r)
+	if err != nil {
+		return nil, err
+	}
+	if (n.op == "&&" && !l) || (n.op == "||" && l) {
+		return l, nil
+	}
+	return evalBool(n.right, r)
+}
+
+func evalBool(n node, r Resolver) (bool, error) {
+	v, err := n.eval(r)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expecting boolean, got %v", v)
+	}
+	return b, nil
+}
+
+type cmpNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n cmpNode) eval(r Resolver) (any, error) {
+	l, err := n.left.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := n.right.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equals(l, rv), nil
+	case "!=":
+		return !equals(l, rv), nil
+	case "in":
+		list, ok := rv.([]any)
+		if !ok {
+			return nil, fmt.Errorf("expecting list after 'in', got %v", rv)
+		}
+		for _, v := range list {
+			if equals(v, l) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	c, err := compare(l, rv)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+// Compare scalar values, lists are never equal.
+func equals(l any, r any) bool {
+	if _, ok := l.([]any); ok {
+		return false
+	}
+	if _, ok := r.([]any); ok {
+		return false
+	}
+	return l == r
+}
+
+func compare(l any, r any) (int, error) {
+	switch lv := l.(type) {
+	case float64:
+		if rv, ok := r.(float64); ok {
+			switch {
+			case lv < rv:
+				return -1, nil
+			case lv > rv:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if rv, ok := r.(string); ok {
+			return strings.Compare(lv, rv), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v with %v", l, r)
+}
+
+type function struct {
+	minArgs int
+	maxArgs int // 0 for unlimited
+	call    func(args []any) (any, error)
+}
+
+var funcs = map[string]function{
+	"cidr": {minArgs: 2, call: func(args []any) (any, error) {
+		s, err := stringArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(strings.TrimSpace(s[0]))
+		if ip == nil {
+			return false, nil
+		}
+		for _, c := range s[1:] {
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr '%v'", c)
+			}
+			if n.Contains(ip) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}},
+	"startsWith": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		s, err := stringArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(s[0], s[1]), nil
+	}},
+	"endsWith": {minArgs: 2, maxArgs: 2, call: func(args []any) (any, error) {
+		s, err := stringArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(s[0], s[1]), nil
+	}},
+}
+
+func stringArgs(args []any) ([]string, error) {
+	s := make([]string, 0, len(args))
+	for _, a := range args {
+		v, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("expecting string argument, got %v", a)
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n callNode) eval(r Resolver) (any, error) {
+	args := make([]any, 0, len(n.args))
+	for _, a := range n.args {
+		v, err := a.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	v, err := n.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call '%v', %w", n.name, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,130 @@
+package cond
+
+import (
+	"slices"
+	"testing"
+)
+
+func testResolver(attrs map[string]any) Resolver {
+	return func(name string) (any, bool) {
+		v, ok := attrs[name]
+		return v, ok
+	}
+}
+
+func TestEval(t *testing.T) {
+	r := testResolver(map[string]any{
+		"ip":              "192.168.1.10",
+		"weekday":         "MON",
+		"time":            "10:30",
+		"hour":            10,
+		"loginMethod":     "password",
+		"user.department": "finance",
+	})
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`true`, true},
+		{`!false`, true},
+		{`cidr(ip, "10.0.0.0/8", "192.168.0.0/16")`, true},
+		{`cidr(ip, "10.0.0.0/8")`, false},
+		{`weekday in ["MON", "TUE"]`, true},
+		{`!(weekday in ["SAT", "SUN"])`, true},
+		{`time >= "09:00" && time < "18:00"`, true},
+		{`hour >= 18 || hour < 9`, false},
+		{`loginMethod == 'password' && user.department != "hr"`, true},
+		{`startsWith(user.department, "fin") && endsWith(ip, ".10")`, true},
+		{`hour == 10.0`, true},
+		{`(hour > 12 || weekday == "MON") && -1 < 0`, true},
+		{`weekday == ["MON"]`, false},
+	}
+	for _, c := range cases {
+		got, err := Eval(c.expr, r)
+		if err != nil {
+			t.Fatalf("%v: %v", c.expr, err)
+		}
+		if got != c.want {
+			t.Fatalf("%v: expected %v, got %v", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestEvalShortCircuit(t *testing.T) {
+	r := testResolver(map[string]any{"hour": 10})
+	ok, err := Eval(`hour > 12 && unknown == "x"`, r)
+	if err != nil || ok {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	}
+	if _, err := Eval(`hour < 12 && unknown == "x"`, r); err == nil {
+		t.Fatal("unknown attribute should be rejected")
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	r := testResolver(map[string]any{"hour": 10, "ip": "10.0.0.1"})
+	for _, expr := range []string{
+		`hour`,
+		`hour < "12"`,
+		`hour in 12`,
+		`cidr(ip, "not a cidr")`,
+		`!hour`,
+	} {
+		if _, err := Eval(expr, r); err == nil {
+			t.Fatalf("%v: expecting error", expr)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`hour >`,
+		`(hour > 1`,
+		`hour > 1)`,
+		`"unterminated`,
+		`exec("rm")`,
+		`hour ; 1`,
+		`[1, 2`,
+		`startsWith("a")`,
+		`1.2.3 == 1`,
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("%v: expecting error", expr)
+		}
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	c, err := Parse(`cidr(ip, "10.0.0.0/8") && user.department == "finance" || ip == "127.0.0.1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := c.Attrs()
+	slices.Sort(attrs)
+	if !slices.Equal(attrs, []string{"ip", "user.department"}) {
+		t.Fatalf("unexpected attrs: %v", attrs)
+	}
+}
+
+func TestIsBoolean(t *testing.T) {
+	for _, src := range []string{`ip == "127.0.0.1"`, `!(hour > 9)`, `a && b`, `cidr(ip, "10.0.0.0/8")`, `1 == 1`} {
+		c, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.IsBoolean() {
+			t.Fatalf("%v should be boolean expression", src)
+		}
+	}
+	for _, src := range []string{`1`, `true`, `"abc"`, `ip`, `[1, 2]`} {
+		c, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.IsBoolean() {
+			t.Fatalf("%v should not be boolean expression", src)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	// whether mails are delivered, mails are only logged when disabled
+	PropMailEnabled = "user-vault.mail.enabled"
+
+	// smtp server host
+	PropMailSmtpHost = "user-vault.mail.smtp.host"
+
+	// smtp server port
+	PropMailSmtpPort = "user-vault.mail.smtp.port"
+
+	// smtp username, auth is skipped if empty
+	PropMailSmtpUsername = "user-vault.mail.smtp.username"
+
+	// smtp password
+	PropMailSmtpPassword = "user-vault.mail.smtp.password"
+
+	// sender address
+	PropMailFrom = "user-vault.mail.from"
+)
+
+var (
+	ErrNoRecipient     = errors.New("mail has no recipient")
+	ErrIllegalHeader   = errors.New("mail header contains illegal characters")
+	mailerMu           sync.RWMutex
+	mailer             Mailer
+	headerValueReplace = strings.NewReplacer("\r", "", "\n", "")
+)
+
+func init() {
+	miso.SetDefProp(PropMailEnabled, false)
+	miso.SetDefProp(PropMailSmtpPort, 25)
+}
+
+// Plain text mail.
+type Mail struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mail sender.
+type Mailer interface {
+	Send(rail miso.Rail, m Mail) error
+}
+
+// Get Mailer configured, Mailer is created on first call.
+//
+// If mail is disabled, a Mailer that only logs the mails is returned.
+func GetMailer() Mailer {
+	mailerMu.RLock()
+	if mailer != nil {
+		defer mailerMu.RUnlock()
+		return mailer
+	}
+	mailerMu.RUnlock()
+
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	if mailer != nil {
+		return mailer
+	}
+
+	if miso.GetPropBool(PropMailEnabled) {
+		mailer = NewSmtpMailer(SmtpConfig{
+			Host:     miso.GetPropStr(PropMailSmtpHost),
+			Port:     miso.GetPropInt(PropMailSmtpPort),
+			Username: miso.GetPropStr(PropMailSmtpUsername),
+			Password: miso.GetPropStr(PropMailSmtpPassword),
+			From:     miso.GetPropStr(PropMailFrom),
+		})
+	} else {
+		mailer = LogMailer{}
+	}
+	return mailer
+}
+
+// Replace the Mailer used.
+func SetMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+}
+
+// Check whether mail delivery is enabled.
+func Enabled() bool {
+	return miso.GetPropBool(PropMailEnabled)
+}
+
+// Mailer that only logs the mails.
+type LogMailer struct{}
+
+func (l LogMailer) Send(rail miso.Rail, m Mail) error {
+	rail.Infof("Mail delivery disabled, to: %v, subject: %v", m.To, m.Subject)
+	return nil
+}
+
+type SmtpConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Mailer backed by SMTP.
+type SmtpMailer struct {
+	conf SmtpConfig
+}
+
+func NewSmtpMailer(conf SmtpConfig) *SmtpMailer {
+	return &SmtpMailer{conf: conf}
+}
+
+func (s *SmtpMailer) Send(rail miso.Rail, m Mail) error {
+	if len(m.To) < 1 {
+		return ErrNoRecipient
+	}
+	for _, t := range m.To {
+		if strings.ContainsAny(t, "\r\n") {
+			return ErrIllegalHeader
+		}
+	}
+	if strings.ContainsAny(s.conf.From, "\r\n") {
+		return ErrIllegalHeader
+	}
+
+	var auth smtp.Auth
+	if s.conf.Username != "" {
+		auth = smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host)
+	}
+
+	addr := net.JoinHostPort(s.conf.Host, fmt.Sprintf("%d", s.conf.Port))
+	if err := smtp.SendMail(addr, auth, s.conf.From, m.To, buildMsg(s.conf.From, m)); err != nil {
+		return fmt.Errorf("failed to send mail to %v, %w", m.To, err)
+	}
+	rail.Infof("Sent mail to %v, subject: %v", m.To, m.Subject)
+	return nil
+}
+
+func buildMsg(from string, m Mail) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + strings.Join(m.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + headerValueReplace.Replace(m.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
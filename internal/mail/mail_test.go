@@ -0,0 +1,123 @@
+package mail
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+type sinkMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// minimal smtp server that accepts a single mail
+func startSmtpSink(t *testing.T) (int, <-chan sinkMail) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan sinkMail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		w := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		w("220 localhost sink")
+
+		var m sinkMail
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				w("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				m.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				w("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				m.To = append(m.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				w("250 OK")
+			case cmd == "DATA":
+				w("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					dl, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dl == ".\r\n" {
+						break
+					}
+					data.WriteString(dl)
+				}
+				m.Data = data.String()
+				w("250 OK")
+			case cmd == "QUIT":
+				w("221 Bye")
+				ch <- m
+				return
+			default:
+				w("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, ch
+}
+
+func TestSmtpMailerSend(t *testing.T) {
+	port, ch := startSmtpSink(t)
+	m := NewSmtpMailer(SmtpConfig{
+		Host: "127.0.0.1",
+		Port: port,
+		From: "noreply@example.com",
+	})
+
+	err := m.Send(miso.EmptyRail(), Mail{
+		To:      []string{"someone@example.com"},
+		Subject: "Hello\r\nBcc: evil@example.com",
+		Body:    "line 1\nline 2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := <-ch
+	if sent.From != "noreply@example.com" {
+		t.Fatal(sent.From)
+	}
+	if len(sent.To) != 1 || sent.To[0] != "someone@example.com" {
+		t.Fatal(sent.To)
+	}
+	if !strings.Contains(sent.Data, "Subject: HelloBcc: evil@example.com\r\n") {
+		t.Fatal(sent.Data)
+	}
+	if !strings.Contains(sent.Data, "line 1\r\nline 2") {
+		t.Fatal(strconv.Quote(sent.Data))
+	}
+}
+
+func TestSmtpMailerIllegalRecipient(t *testing.T) {
+	m := NewSmtpMailer(SmtpConfig{Host: "127.0.0.1", Port: 25})
+	if err := m.Send(miso.EmptyRail(), Mail{}); err != ErrNoRecipient {
+		t.Fatal(err)
+	}
+	if err := m.Send(miso.EmptyRail(), Mail{To: []string{"a@b.c\r\nRCPT TO:<x@y.z>"}}); err != ErrIllegalHeader {
+		t.Fatal(err)
+	}
+}
@@ -60,5 +60,27 @@ func InitPipeline(rail miso.Rail) error {
 			ReceiverUserNos: un,
 		}, common.NilUser())
 	})
+
+	api.CreateNotifiByGroupPipeline.Listen(2, func(rail miso.Rail, evt api.CreateNotifiByGroupEvent) error {
+		if err := miso.Validate(evt); err != nil {
+			rail.Errorf("Invalid event, %#v, %v", evt, err)
+			return nil
+		}
+
+		un, err := vault.ListGroupMemberUserNos(rail, mysql.GetMySQL(), evt.GroupNo)
+		if err != nil {
+			rail.Errorf("failed to ListGroupMemberUserNos, %v", err)
+			return err
+		}
+		if len(un) < 1 {
+			return nil
+		}
+
+		return CreateNotification(rail, mysql.GetMySQL(), api.CreateNotificationReq{
+			Title:           evt.Title,
+			Message:         evt.Message,
+			ReceiverUserNos: un,
+		}, common.NilUser())
+	})
 	return nil
 }
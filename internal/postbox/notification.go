@@ -8,6 +8,8 @@ import (
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
 	"github.com/curtisnewbie/user-vault/api"
+	"github.com/curtisnewbie/user-vault/internal/mail"
+	"github.com/curtisnewbie/user-vault/internal/vault"
 	"gorm.io/gorm"
 )
 
@@ -16,6 +18,10 @@ const (
 	StatusOpened = "OPENED"
 )
 
+var (
+	mailPool = util.NewAsyncPool(500, 10)
+)
+
 func CreateNotification(rail miso.Rail, db *gorm.DB, req api.CreateNotificationReq, user common.User) error {
 	if len(req.ReceiverUserNos) < 1 {
 		return nil
@@ -35,9 +41,40 @@ func CreateNotification(rail miso.Rail, db *gorm.DB, req api.CreateNotificationR
 		}
 	}
 
+	mirrorNotificationToEmail(rail, db, req)
 	return nil
 }
 
+// Deliver notification to the verified email of users who opt in, failures are only logged.
+//
+// Receivers are looked up in the caller's goroutine, the mails are sent asynchronously, so a slow mail server never
+// blocks the creation of notifications.
+func mirrorNotificationToEmail(rail miso.Rail, db *gorm.DB, req api.CreateNotificationReq) {
+	if !miso.GetPropBool(vault.PropNotificationEmailEnabled) {
+		return
+	}
+
+	receivers, err := vault.FindEmailNotificationReceivers(rail, db, req.ReceiverUserNos)
+	if err != nil {
+		rail.Errorf("Failed to find email notification receivers, %v", err)
+		return
+	}
+
+	for _, r := range receivers {
+		m := mail.Mail{
+			To:      []string{r.Email},
+			Subject: req.Title,
+			Body:    req.Message,
+		}
+		userNo := r.UserNo
+		mailPool.Go(func() {
+			if err := mail.GetMailer().Send(rail, m); err != nil {
+				rail.Errorf("Failed to send notification by email, userNo: %v, %v", userNo, err)
+			}
+		})
+	}
+}
+
 type SaveNotifiReq struct {
 	UserNo  string
 	Title   string
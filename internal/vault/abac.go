@@ -0,0 +1,167 @@
+package vault
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/user-vault/api"
+	"github.com/curtisnewbie/user-vault/internal/cond"
+)
+
+const (
+	// cached value of role resource binding without condition, conditions are always boolean expressions that
+	// reference attributes (see validateGrantCond), so they never collide with it
+	unconditionalRoleRes = "1"
+
+	userAttrPrefix = "user."
+)
+
+var (
+	// attributes that can be used in grant conditions, besides the user attributes (user.*)
+	grantCondAttrs = []string{"ip", "loginMethod", "username", "time", "hour", "weekday", "date"}
+
+	// condition expression -> *cond.Cond
+	parsedGrantConds sync.Map
+)
+
+// Attributes of the request that are used to evaluate grant conditions.
+type accessAttrs struct {
+	ClientIp    string
+	LoginMethod string
+	Username    string
+	Now         time.Time
+
+	userAttrs  api.UserAttributes
+	userLoaded bool
+}
+
+func (a *accessAttrs) resolver(rail miso.Rail) cond.Resolver {
+	return func(name string) (any, bool) {
+		switch name {
+		case "ip":
+			return a.ClientIp, true
+		case "loginMethod":
+			return a.LoginMethod, true
+		case "username":
+			return a.Username, true
+		case "time":
+			return a.Now.Format("15:04"), true
+		case "hour":
+			return a.Now.Hour(), true
+		case "weekday":
+			return strings.ToUpper(a.Now.Weekday().String()[:3]), true
+		case "date":
+			return a.Now.Format("2006-01-02"), true
+		}
+		if attr, ok := strings.CutPrefix(name, userAttrPrefix); ok {
+			if err := checkGrantCondUserAttr(LoadProfileAttributeDefs(), attr); err != nil {
+				rail.Warnf("User attribute '%v' cannot be used in grant conditions, %v", attr, err)
+				return nil, false
+			}
+			if !a.userLoaded {
+				a.userLoaded = true
+				if a.Username != "" {
+					u, err := LoadUserBriefThrCache(rail, mysql.GetMySQL(), a.Username)
+					if err != nil {
+						rail.Warnf("Failed to load user attributes of %v, %v", a.Username, err)
+					}
+					a.userAttrs = u.Attributes
+				}
+			}
+			return a.userAttrs[attr], true // missing attribute is treated as empty string
+		}
+		return nil, false
+	}
+}
+
+// Validate grant condition, it must be a boolean expression that references at least one attribute, and only
+// known attributes are allowed.
+func validateGrantCond(src string) error {
+	c, err := parseGrantCond(src)
+	if err != nil {
+		return miso.NewErrf("Invalid condition, %v", err)
+	}
+	if !c.IsBoolean() {
+		return miso.NewErrf("Invalid condition, condition must be a boolean expression")
+	}
+	if len(c.Attrs()) < 1 {
+		return miso.NewErrf("Invalid condition, condition must reference at least one attribute")
+	}
+	var defs []ProfileAttributeDef
+	for _, a := range c.Attrs() {
+		if attr, ok := strings.CutPrefix(a, userAttrPrefix); ok {
+			if defs == nil {
+				defs = LoadProfileAttributeDefs()
+			}
+			if err := checkGrantCondUserAttr(defs, attr); err != nil {
+				return err
+			}
+			continue
+		}
+		if !slices.Contains(grantCondAttrs, a) {
+			return miso.NewErrf("Invalid condition, unknown attribute '%v'", a)
+		}
+	}
+	return nil
+}
+
+// Only profile attributes that are defined and cannot be edited by the users themselves can be used in grant
+// conditions, otherwise users may satisfy the conditions by updating their own profiles.
+func checkGrantCondUserAttr(defs []ProfileAttributeDef, attr string) error {
+	d, ok := findProfileAttributeDef(defs, attr)
+	if !ok {
+		return miso.NewErrf("Invalid condition, unknown attribute '%v%v'", userAttrPrefix, attr)
+	}
+	if d.SelfEditable {
+		return miso.NewErrf("Invalid condition, attribute '%v%v' is editable by users", userAttrPrefix, attr)
+	}
+	return nil
+}
+
+func parseGrantCond(src string) (*cond.Cond, error) {
+	if c, ok := parsedGrantConds.Load(src); ok {
+		return c.(*cond.Cond), nil
+	}
+	c, err := cond.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	parsedGrantConds.Store(src, c)
+	return c, nil
+}
+
+// Evaluate the cached role resource binding, conditions that cannot be evaluated are treated as satisfied
+// for deny rules and unsatisfied for allows.
+func evalRoleResCond(rail miso.Rail, cached string, attrs *accessAttrs, deny bool) bool {
+	if cached == unconditionalRoleRes {
+		return true
+	}
+	if attrs == nil {
+		return deny
+	}
+	c, err := parseGrantCond(cached)
+	if err == nil {
+		var ok bool
+		if ok, err = c.Eval(attrs.resolver(rail)); err == nil {
+			return ok
+		}
+	}
+	rail.Warnf("Failed to evaluate grant condition '%v', %v", cached, err)
+	return deny
+}
+
+// Value of roleResCache, conditions of the same resource are merged.
+func mergeRoleResCond(prev string, exists bool, condition string) string {
+	if condition == "" || (exists && prev == unconditionalRoleRes) {
+		return unconditionalRoleRes
+	}
+	if !exists || prev == condition {
+		return condition
+	}
+	return fmt.Sprintf("(%v) || (%v)", prev, condition)
+}
@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestValidateGrantCond(t *testing.T) {
+	miso.SetProp(PropProfileAttributes, []map[string]any{
+		{"name": "department", "selfEditable": false},
+		{"name": "nickname", "selfEditable": true},
+	})
+
+	for _, c := range []string{
+		`cidr(ip, "10.0.0.0/8")`,
+		`weekday in ["MON", "FRI"] && hour >= 9 && loginMethod == "password"`,
+		`user.department == "finance"`,
+	} {
+		if err := validateGrantCond(c); err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+	}
+	for _, c := range []string{`roleNo == "role_1"`, `hour >`, `user.nickname == "boss"`, `user.unknown == "1"`,
+		unconditionalRoleRes, `true`, `1 == 1`, `hour`} {
+		if err := validateGrantCond(c); err == nil {
+			t.Fatalf("%v: expecting error", c)
+		}
+	}
+}
+
+func TestMergeRoleResCond(t *testing.T) {
+	if v := mergeRoleResCond("", false, ""); v != unconditionalRoleRes {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if v := mergeRoleResCond("", false, "hour > 9"); v != "hour > 9" {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if v := mergeRoleResCond("hour > 9", true, ""); v != unconditionalRoleRes {
+		t.Fatalf("unconditional grant should win: %v", v)
+	}
+	if v := mergeRoleResCond(unconditionalRoleRes, true, "hour > 9"); v != unconditionalRoleRes {
+		t.Fatalf("unconditional grant should win: %v", v)
+	}
+	if v := mergeRoleResCond("hour > 9", true, `ip == "127.0.0.1"`); v != `(hour > 9) || (ip == "127.0.0.1")` {
+		t.Fatalf("unexpected value: %v", v)
+	}
+}
+
+func TestEvalRoleResCond(t *testing.T) {
+	rail := miso.EmptyRail()
+	now := time.Date(2024, 6, 3, 10, 30, 0, 0, time.Local) // monday
+	attrs := &accessAttrs{ClientIp: "10.1.2.3", LoginMethod: "password", Now: now}
+
+	if !evalRoleResCond(rail, unconditionalRoleRes, nil, false) {
+		t.Fatal("unconditional grant should apply")
+	}
+	if evalRoleResCond(rail, "hour >= 9", nil, false) || !evalRoleResCond(rail, "hour >= 9", nil, true) {
+		t.Fatal("conditional grant without attributes should fail closed")
+	}
+	if !evalRoleResCond(rail, `cidr(ip, "10.0.0.0/8") && weekday == "MON" && time < "11:00" && date == "2024-06-03"`, attrs, false) {
+		t.Fatal("condition should be satisfied")
+	}
+	if evalRoleResCond(rail, `loginMethod != "password"`, attrs, false) {
+		t.Fatal("condition should not be satisfied")
+	}
+	if evalRoleResCond(rail, `hour > "9"`, attrs, false) || !evalRoleResCond(rail, `hour > "9"`, attrs, true) {
+		t.Fatal("invalid condition should fail closed")
+	}
+
+	// self-editable attributes are not resolved, even if the grant was created before the definition was changed
+	miso.SetProp(PropProfileAttributes, []map[string]any{{"name": "nickname", "selfEditable": true}})
+	if evalRoleResCond(rail, `user.nickname == ""`, attrs, false) || !evalRoleResCond(rail, `user.nickname == ""`, attrs, true) {
+		t.Fatal("self-editable attribute should fail closed")
+	}
+}
@@ -0,0 +1,258 @@
+package vault
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	goredis "github.com/go-redis/redis"
+)
+
+type BatchTestResAccessReq struct {
+	Items       []BatchTestResAccessItem `json:"items" validation:"notEmpty" desc:"paths to check, at most 'user-vault.access-check.batch-limit' items"`
+	ClientIp    string                   `json:"clientIp" desc:"client ip address, used to evaluate grant conditions"`
+	LoginMethod string                   `json:"loginMethod" desc:"login method of the token (the 'loginmethod' claim), used to evaluate grant conditions"`
+	Username    string                   `json:"username" desc:"username of the user, used to evaluate grant conditions on user attributes"`
+}
+
+type BatchTestResAccessItem struct {
+	RoleNo  string   `json:"roleNo"`
+	RoleNos []string `json:"roleNos" desc:"all roles of the user, access is granted if any of them has the resource"`
+	Url     string   `json:"url"`
+	Method  string   `json:"method"`
+}
+
+type BatchTestResAccessResp struct {
+	Results []BatchTestResAccessResult `json:"results" desc:"decisions in the same order as the items"`
+}
+
+type BatchTestResAccessResult struct {
+	Url    string `json:"url"`
+	Method string `json:"method"`
+	Valid  bool   `json:"valid"`
+}
+
+// Test access to multiple paths, caches of paths and role resources are read in redis pipelines.
+func BatchTestResourceAccess(rail miso.Rail, req BatchTestResAccessReq) (BatchTestResAccessResp, error) {
+	if limit := miso.GetPropInt(PropAccessCheckBatchLimit); len(req.Items) > limit {
+		return BatchTestResAccessResp{}, miso.NewErrf("At most %d items can be checked at a time", limit)
+	}
+
+	items := make([]batchAccessItem, 0, len(req.Items))
+	for _, it := range req.Items {
+		items = append(items, batchAccessItem{
+			url:     preprocessUrl(it.Url),
+			method:  strings.ToUpper(strings.TrimSpace(it.Method)),
+			roleNos: distinctRoleNos(append([]string{it.RoleNo}, it.RoleNos...)),
+		})
+	}
+
+	// resources required by the paths
+	urlKeys := make([]string, 0, len(items))
+	for _, it := range items {
+		urlKeys = append(urlKeys, it.urlKey())
+	}
+	curs, err := batchLookupUrlRes(rail, urlKeys)
+	if err != nil {
+		return BatchTestResAccessResp{}, err
+	}
+
+	// roles and resources that may be checked, including the wildcard patterns and deny rules
+	roleResKeys := make([]string, 0, len(items))
+	for _, it := range items {
+		cur, ok := curs[it.urlKey()]
+		if !ok || cur.Ptype == PathTypePublic {
+			continue
+		}
+		roleResKeys = append(roleResKeys, roleResCacheKeys(it.roleNos, cur.ResCodes)...)
+	}
+	get, err := prefetchRoleResCache(roleResKeys)
+	if err != nil {
+		return BatchTestResAccessResp{}, err
+	}
+
+	attrs := &accessAttrs{ClientIp: req.ClientIp, LoginMethod: req.LoginMethod, Username: req.Username, Now: time.Now()}
+	results, err := checkBatchAccess(rail, items, curs, get, attrs)
+	if err != nil {
+		return BatchTestResAccessResp{}, err
+	}
+	return BatchTestResAccessResp{Results: results}, nil
+}
+
+type batchAccessItem struct {
+	url     string
+	method  string
+	roleNos []string
+}
+
+// Key of the item in urlResCache.
+func (it batchAccessItem) urlKey() string {
+	return it.method + ":" + it.url
+}
+
+// Check access of the items, curs are the resources of the paths found by url key.
+func checkBatchAccess(rail miso.Rail, items []batchAccessItem, curs map[string]CachedUrlRes, get roleResGetter,
+	attrs *accessAttrs) ([]BatchTestResAccessResult, error) {
+	results := make([]BatchTestResAccessResult, 0, len(items))
+	for _, it := range items {
+		res := BatchTestResAccessResult{Url: it.url, Method: it.method}
+		if cur, ok := curs[it.urlKey()]; ok {
+			r, err := checkUrlAccess(rail, get, cur, it.url, it.roleNos, attrs)
+			if err != nil {
+				return nil, err
+			}
+			res.Valid = r.Valid
+		} else {
+			rail.Infof("Rejected '%s' (%s), path not found", it.url, it.method)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Keys of roleResCache that may be looked up when checking access of the roles to the resources, including the
+// wildcard patterns and deny rules.
+func roleResCacheKeys(roleNos []string, resCodes []string) []string {
+	if slices.Contains(roleNos, DefaultAdminRoleNo) {
+		return nil
+	}
+	keys := make([]string, 0, len(roleNos)*len(resCodes)*2)
+	for _, r := range roleNos {
+		for _, code := range resCodes {
+			for _, c := range append([]string{code}, resCodePatterns(code)...) {
+				keys = append(keys,
+					roleResCacheKey(r, c),
+					roleResCacheKey(r, roleResCacheCode(c, ResEffectDeny)))
+			}
+		}
+	}
+	return keys
+}
+
+// Read the keys of roleResCache in a redis pipeline, the returned getter serves the prefetched keys, and reads
+// the others from the cache one by one.
+func prefetchRoleResCache(keys []string) (roleResGetter, error) {
+	vals, err := pipelinedGet(roleResCacheName, keys)
+	if err != nil {
+		return nil, err
+	}
+	prefetched := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		prefetched[k] = struct{}{}
+	}
+	return func(rail miso.Rail, key string) (string, bool, error) {
+		if _, ok := prefetched[key]; !ok {
+			return getRoleResCache(rail, key)
+		}
+		v, ok := vals[key]
+		if !ok {
+			return "", false, nil
+		}
+		var cached string
+		if err := roleResCache.ValueSerializer.Deserialize(&cached, v); err != nil {
+			return "", false, fmt.Errorf("failed to deserialize cached role resource, %w", err)
+		}
+		return cached, true, nil
+	}, nil
+}
+
+// Lookup resources of the urls (method + ":" + url), exact matches win over path templates.
+//
+// Like lookupUrlRes, ambiguous urls are never matched.
+func batchLookupUrlRes(rail miso.Rail, urlKeys []string) (map[string]CachedUrlRes, error) {
+	exact, err := pipelinedGet(urlResCacheName, urlKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// urls not found are matched against path templates
+	var m *pathMatcher
+	templates := map[string]string{} // url key -> template key
+	templateKeys := []string{}
+	for _, k := range urlKeys {
+		if _, ok := exact[k]; ok {
+			continue
+		}
+		if _, ok := templates[k]; ok {
+			continue
+		}
+		method, url, _ := strings.Cut(k, ":")
+		if isAmbiguousUrl(url) {
+			continue
+		}
+		if m == nil {
+			if m, err = pathTemplates.current(rail); err != nil {
+				return nil, err
+			}
+		}
+		if t, ok := m.match(method, url); ok {
+			templates[k] = method + ":" + t
+			templateKeys = append(templateKeys, method+":"+t)
+		}
+	}
+	matched, err := pipelinedGet(urlResCacheName, templateKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	curs := make(map[string]CachedUrlRes, len(urlKeys))
+	for _, k := range urlKeys {
+		if _, url, _ := strings.Cut(k, ":"); isAmbiguousUrl(url) {
+			continue
+		}
+		v, ok := exact[k]
+		if !ok {
+			if v, ok = matched[templates[k]]; !ok {
+				continue
+			}
+		}
+		var cur CachedUrlRes
+		if err := urlResCache.ValueSerializer.Deserialize(&cur, v); err != nil {
+			return nil, fmt.Errorf("failed to deserialize cached url resource, %w", err)
+		}
+		curs[k] = cur
+	}
+	return curs, nil
+}
+
+// Key of the RCache entry in redis.
+//
+// RCache stores each key as 'rcache:' + name + ':' + key, but the format is not exposed by miso, it's pinned by
+// TestPipelinedGet against a real RCache.
+func rcacheKey(cacheName string, key string) string {
+	return "rcache:" + cacheName + ":" + key
+}
+
+// Read keys of the RCache in a redis pipeline, keys that are absent are not included in the result.
+func pipelinedGet(cacheName string, keys []string) (map[string]string, error) {
+	vals := make(map[string]string, len(keys))
+	if len(keys) < 1 {
+		return vals, nil
+	}
+
+	pipe := redis.GetRedis().Pipeline()
+	defer pipe.Close()
+	cmds := make(map[string]*goredis.StringCmd, len(keys))
+	for _, k := range keys {
+		if _, ok := cmds[k]; !ok {
+			cmds[k] = pipe.Get(rcacheKey(cacheName, k))
+		}
+	}
+	if _, err := pipe.Exec(); err != nil && !redis.IsNil(err) {
+		return nil, fmt.Errorf("failed to read %v in pipeline, %w", cacheName, err)
+	}
+	for k, cmd := range cmds {
+		v, err := cmd.Result()
+		if err != nil {
+			if redis.IsNil(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %v in pipeline, %w", cacheName, err)
+		}
+		vals[k] = v
+	}
+	return vals, nil
+}
@@ -0,0 +1,113 @@
+package vault
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestRoleResCacheKeys(t *testing.T) {
+	keys := roleResCacheKeys([]string{"role_a", "role_b"}, []string{"postbox:query"})
+	for _, k := range []string{
+		"role:role_a:res:postbox:query", "role:role_a:res:!postbox:query",
+		"role:role_a:res:postbox:*", "role:role_a:res:!postbox:*",
+		"role:role_b:res:postbox:query", "role:role_b:res:!postbox:*",
+	} {
+		if !slices.Contains(keys, k) {
+			t.Fatalf("missing key %v, %v", k, keys)
+		}
+	}
+	if len(keys) != 8 {
+		t.Fatal(keys)
+	}
+	if keys := roleResCacheKeys([]string{"role_a", DefaultAdminRoleNo}, []string{"postbox:query"}); len(keys) != 0 {
+		t.Fatal("administrator role is never checked against the cache")
+	}
+}
+
+func testRoleResGetter(cached map[string]string) roleResGetter {
+	return func(rail miso.Rail, key string) (string, bool, error) {
+		v, ok := cached[key]
+		return v, ok, nil
+	}
+}
+
+func TestCheckBatchAccess(t *testing.T) {
+	rail := miso.EmptyRail()
+	get := testRoleResGetter(map[string]string{
+		roleResCacheKey("role_a", "postbox:query"):  unconditionalRoleRes,
+		roleResCacheKey("role_a", "vfm:*"):          unconditionalRoleRes,
+		roleResCacheKey("role_b", "postbox:query"):  unconditionalRoleRes,
+		roleResCacheKey("role_b", "!postbox:*"):     unconditionalRoleRes,
+		roleResCacheKey("role_c", "postbox:delete"): unconditionalRoleRes,
+	})
+	curs := map[string]CachedUrlRes{
+		"GET:/any":    {Ptype: PathTypeProtected, ResCodes: []string{"postbox:delete", "postbox:query"}, ResMode: PathResModeAny},
+		"GET:/all":    {Ptype: PathTypeProtected, ResCodes: []string{"postbox:query", "vfm:file"}, ResMode: PathResModeAll},
+		"GET:/public": {Ptype: PathTypePublic},
+	}
+	cases := []struct {
+		item  batchAccessItem
+		valid bool
+	}{
+		{batchAccessItem{url: "/any", method: "GET", roleNos: []string{"role_a"}}, true},
+		{batchAccessItem{url: "/any", method: "GET", roleNos: []string{"role_c"}}, true},
+		{batchAccessItem{url: "/all", method: "GET", roleNos: []string{"role_a"}}, true},                     // vfm:file by pattern
+		{batchAccessItem{url: "/all", method: "GET", roleNos: []string{"role_c"}}, false},                    // only part of them
+		{batchAccessItem{url: "/all", method: "GET", roleNos: []string{"role_b"}}, false},                    // denied by pattern
+		{batchAccessItem{url: "/any", method: "GET", roleNos: []string{"role_a", "role_b"}}, false},          // deny overrides allow of other role
+		{batchAccessItem{url: "/any", method: "GET", roleNos: []string{"role_b", DefaultAdminRoleNo}}, true}, // admin is not denied
+		{batchAccessItem{url: "/public", method: "GET"}, true},
+		{batchAccessItem{url: "/any", method: "GET"}, false},
+		{batchAccessItem{url: "/unknown", method: "GET", roleNos: []string{"role_a"}}, false},
+	}
+	items := make([]batchAccessItem, 0, len(cases))
+	for _, c := range cases {
+		items = append(items, c.item)
+	}
+	results, err := checkBatchAccess(rail, items, curs, get, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(cases) {
+		t.Fatal(results)
+	}
+	for i, c := range cases {
+		if results[i].Valid != c.valid || results[i].Url != c.item.url {
+			t.Fatalf("case %d, %+v, expected %v, got %+v", i, c.item, c.valid, results[i])
+		}
+	}
+}
+
+func TestPipelinedGet(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+
+	// pins the key format of RCache that is used by pipelinedGet
+	key := roleResCacheKey("test_role", "test:pipelined:get")
+	if err := roleResCache.Put(rail, key, unconditionalRoleRes); err != nil {
+		t.Fatal(err)
+	}
+	defer roleResCache.Del(rail, key)
+	cur := CachedUrlRes{PathNo: "test_path", Url: "/test/pipelined/get", ResCodes: []string{"test:pipelined:get"}}
+	if err := urlResCache.Put(rail, "GET:"+cur.Url, cur); err != nil {
+		t.Fatal(err)
+	}
+	defer urlResCache.Del(rail, "GET:"+cur.Url)
+
+	vals, err := pipelinedGet(roleResCacheName, []string{key, roleResCacheKey("test_role", "absent")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[key] != unconditionalRoleRes {
+		t.Fatal(vals)
+	}
+	curs, err := batchLookupUrlRes(rail, []string{"GET:" + cur.Url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curs["GET:"+cur.Url].PathNo != cur.PathNo {
+		t.Fatal(curs)
+	}
+}
@@ -8,43 +8,55 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	LoginMethodPassword  = "PASSWORD"
+	LoginMethodEmailCode = "EMAIL_CODE"
+	LoginMethodEmailLink = "EMAIL_LINK"
+)
+
 type AccessLog struct {
-	Id         int
-	UserAgent  string
-	IpAddress  string
-	UserId     int
-	Username   string
-	Url        string
-	AccessTime util.ETime
-	CreateTime util.ETime
-	CreateBy   string
-	UpdateTime util.ETime
-	UpdateBy   string
-	IsDel      bool
+	Id          int
+	UserAgent   string
+	IpAddress   string
+	UserId      int
+	Username    string
+	Url         string
+	LoginMethod string
+	AccessTime  util.ETime
+	CreateTime  util.ETime
+	CreateBy    string
+	UpdateTime  util.ETime
+	UpdateBy    string
+	IsDel       bool
 }
 
 type SaveAccessLogParam struct {
-	UserAgent  string
-	IpAddress  string
-	UserId     int
-	Username   string
-	Url        string
-	Success    bool
-	AccessTime util.ETime
+	UserAgent   string
+	IpAddress   string
+	UserId      int
+	Username    string
+	Url         string
+	Success     bool
+	LoginMethod string
+	AccessTime  util.ETime
 }
 
 func SaveAccessLogEvent(rail miso.Rail, tx *gorm.DB, p SaveAccessLogParam) error {
+	if p.LoginMethod == "" {
+		p.LoginMethod = LoginMethodPassword
+	}
 	return tx.Table("access_log").Create(&p).Error
 }
 
 type ListedAccessLog struct {
-	Id         int        `json:"id"`
-	UserAgent  string     `json:"userAgent"`
-	IpAddress  string     `json:"ipAddress"`
-	Username   string     `json:"username"`
-	Url        string     `json:"url"`
-	AccessTime util.ETime `json:"accessTime"`
-	Success    bool
+	Id          int        `json:"id"`
+	UserAgent   string     `json:"userAgent"`
+	IpAddress   string     `json:"ipAddress"`
+	Username    string     `json:"username"`
+	Url         string     `json:"url"`
+	LoginMethod string     `json:"loginMethod"`
+	AccessTime  util.ETime `json:"accessTime"`
+	Success     bool
 }
 
 type ListAccessLogReq struct {
@@ -55,7 +67,7 @@ func ListAccessLogs(rail miso.Rail, tx *gorm.DB, user common.User, req ListAcces
 	return mysql.NewPageQuery[ListedAccessLog]().
 		WithPage(req.Paging).
 		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
-			return tx.Select("id", "access_time", "ip_address", "username", "url", "user_agent", "success").
+			return tx.Select("id", "access_time", "ip_address", "username", "url", "user_agent", "success", "login_method").
 				Order("id desc")
 		}).
 		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
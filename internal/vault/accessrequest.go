@@ -0,0 +1,427 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+const (
+	AccessRequestPending   = "PENDING"
+	AccessRequestApproved  = "APPROVED"
+	AccessRequestDenied    = "DENIED"
+	AccessRequestCancelled = "CANCELLED"
+
+	personalRoleNamePrefix = "Personal: "
+)
+
+type CreateAccessRequestReq struct {
+	ResCode       string `json:"resCode" valid:"notEmpty"`
+	Justification string `json:"justification" valid:"notEmpty,maxLen:255"`
+	DurationHours int    `json:"durationHours" desc:"optional, how long the access is needed in hours, 0 for permanent access"`
+}
+
+type CreateAccessRequestRes struct {
+	RequestNo string `json:"requestNo"`
+}
+
+type CancelAccessRequestReq struct {
+	RequestNo string `json:"requestNo" valid:"notEmpty"`
+}
+
+type ReviewAccessRequestReq struct {
+	RequestNo string `json:"requestNo" valid:"notEmpty"`
+	Remark    string `json:"remark" valid:"maxLen:255"`
+}
+
+type ListMyAccessRequestsReq struct {
+	Status string      `json:"status" desc:"optional, PENDING, APPROVED, DENIED or CANCELLED"`
+	Paging miso.Paging `json:"paging"`
+}
+
+type ListAccessRequestsReq struct {
+	Status   string      `json:"status" desc:"optional, PENDING, APPROVED, DENIED or CANCELLED"`
+	Username string      `json:"username"`
+	ResCode  string      `json:"resCode"`
+	Paging   miso.Paging `json:"paging"`
+}
+
+type ListedAccessRequest struct {
+	RequestNo     string      `json:"requestNo"`
+	UserNo        string      `json:"userNo"`
+	Username      string      `json:"username"`
+	ResCode       string      `json:"resCode"`
+	ResName       string      `json:"resName"`
+	Justification string      `json:"justification"`
+	DurationHours int         `json:"durationHours"`
+	Status        string      `json:"status"`
+	Reviewer      string      `json:"reviewer"`
+	ReviewRemark  string      `json:"reviewRemark"`
+	ReviewTime    *util.ETime `json:"reviewTime"`
+	ValidUntil    *util.ETime `json:"validUntil" desc:"when the granted access expires"`
+	CreateTime    util.ETime  `json:"createTime"`
+}
+
+type accessRequest struct {
+	RequestNo     string
+	UserNo        string
+	Username      string
+	ResCode       string
+	DurationHours int
+	Status        string
+}
+
+// Request access to resource, approvers are notified via postbox.
+func CreateAccessRequest(rail miso.Rail, db *gorm.DB, req CreateAccessRequestReq, user common.User) (CreateAccessRequestRes, error) {
+	if req.DurationHours < 0 {
+		return CreateAccessRequestRes{}, miso.NewErrf("Invalid duration")
+	}
+
+	var resName string
+	if err := db.Raw(`SELECT name FROM resource WHERE code = ?`, req.ResCode).Scan(&resName).Error; err != nil {
+		return CreateAccessRequestRes{}, err
+	}
+	if resName == "" {
+		return CreateAccessRequestRes{}, miso.NewErrf("Resource not found")
+	}
+
+	requestNo := util.GenIdP("acr_")
+	err := redis.RLockExec(rail, "user-vault:access-request:user:"+user.UserNo, func() error {
+		ok, err := userHasRes(rail, db, user.UserNo, req.ResCode)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return miso.NewErrf("You already have access to the resource")
+		}
+
+		var id int
+		err = db.Raw(`SELECT id FROM access_request WHERE user_no = ? AND res_code = ? AND status = ?`,
+			user.UserNo, req.ResCode, AccessRequestPending).Scan(&id).Error
+		if err != nil {
+			return err
+		}
+		if id > 0 {
+			return miso.NewErrf("You have requested access to the resource already, please wait for approval")
+		}
+
+		return db.Exec(`INSERT INTO access_request (request_no, user_no, username, res_code, justification, duration_hours, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			requestNo, user.UserNo, user.Username, req.ResCode, req.Justification, req.DurationHours, AccessRequestPending).Error
+	})
+	if err != nil {
+		return CreateAccessRequestRes{}, err
+	}
+	rail.Infof("User %v requested access to resource %v, requestNo: %v", user.Username, req.ResCode, requestNo)
+
+	duration := "permanently"
+	if req.DurationHours > 0 {
+		duration = fmt.Sprintf("for %d hours", req.DurationHours)
+	}
+	err = api.CreateNotifiByAccessPipeline.Send(rail, api.CreateNotifiByAccessEvent{
+		Title:   fmt.Sprintf("%v requests access to '%v'", user.Username, resName),
+		Message: fmt.Sprintf("%v requests access to resource '%v' %v, justification: %v", user.Username, resName, duration, req.Justification),
+		ResCode: miso.GetPropStr(PropAccessRequestApproverResource),
+	})
+	if err != nil {
+		rail.Errorf("Failed to notify approvers of access request %v, %v", requestNo, err)
+	}
+	return CreateAccessRequestRes{RequestNo: requestNo}, nil
+}
+
+func CancelAccessRequest(rail miso.Rail, db *gorm.DB, req CancelAccessRequestReq, user common.User) error {
+	return lockAccessRequest(rail, req.RequestNo, func() error {
+		t := db.Exec(`UPDATE access_request SET status = ? WHERE request_no = ? AND user_no = ? AND status = ?`,
+			AccessRequestCancelled, req.RequestNo, user.UserNo, AccessRequestPending)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Access request not found or reviewed already")
+		}
+		return nil
+	})
+}
+
+// Approve access request, the resource is bound to the requester's personal role.
+//
+// The personal role is carried by tokens issued or exchanged afterwards, the requester must exchange the token
+// before the approved access takes effect.
+func ApproveAccessRequest(rail miso.Rail, db *gorm.DB, req ReviewAccessRequestReq, reviewer common.User) error {
+	return reviewAccessRequest(rail, db, req, reviewer, &accessRequestGrant{
+		check: func(ar accessRequest) error {
+			u, err := loadUserByUserNo(rail, db, ar.UserNo)
+			if err != nil {
+				return err
+			}
+			if u.Deleted() {
+				return miso.NewErrf("User not found")
+			}
+
+			// delegated admins can only approve resources within their scopes
+			scopes, err := loadAdminScopes(rail, db, reviewer.UserNo)
+			if err != nil {
+				return err
+			}
+			if !scopes.canManageRes(ar.ResCode) {
+				return miso.NewErrf("You are not allowed to manage the resource").WithCode(ErrCodeOutOfAdminScope)
+			}
+			return nil
+		},
+		grant: func(ar accessRequest, validUntil *util.ETime) error {
+			u, err := loadUserByUserNo(rail, db, ar.UserNo)
+			if err != nil {
+				return err
+			}
+			roleNo, err := ensurePersonalRole(rail, db, u, reviewer)
+			if err != nil {
+				return err
+			}
+			err = addResToRole(rail, AddRoleResReq{RoleNo: roleNo, ResCode: ar.ResCode, ValidUntil: validUntil}, reviewer)
+			if err != nil {
+				return err
+			}
+			rail.Infof("Resource %v granted to %v's personal role %v, valid until %v", ar.ResCode, ar.Username, roleNo, validUntil)
+			return nil
+		},
+	})
+}
+
+// Grant made on approval, check is called before the request is marked as approved.
+type accessRequestGrant struct {
+	check func(ar accessRequest) error
+	grant func(ar accessRequest, validUntil *util.ETime) error
+}
+
+func DenyAccessRequest(rail miso.Rail, db *gorm.DB, req ReviewAccessRequestReq, reviewer common.User) error {
+	return reviewAccessRequest(rail, db, req, reviewer, nil)
+}
+
+func reviewAccessRequest(rail miso.Rail, db *gorm.DB, req ReviewAccessRequestReq, reviewer common.User,
+	approval *accessRequestGrant) error {
+
+	if err := checkAccessApprover(rail, db, reviewer); err != nil {
+		return err
+	}
+
+	return lockAccessRequest(rail, req.RequestNo, func() error {
+		var ar accessRequest
+		t := db.Raw(`SELECT request_no, user_no, username, res_code, duration_hours, status FROM access_request WHERE request_no = ?`,
+			req.RequestNo).Scan(&ar)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Access request not found")
+		}
+		if err := checkAccessRequestReviewable(ar, reviewer); err != nil {
+			return err
+		}
+
+		status := AccessRequestDenied
+		var validUntil *util.ETime
+		if approval != nil {
+			if err := approval.check(ar); err != nil {
+				return err
+			}
+			status = AccessRequestApproved
+			validUntil = accessRequestValidUntil(util.Now(), ar.DurationHours)
+		}
+
+		// the request leaves PENDING before the resource is granted, so it can never be reviewed twice
+		t = db.Exec(`UPDATE access_request SET status = ?, reviewer = ?, review_remark = ?, review_time = ?, valid_until = ?
+			WHERE request_no = ? AND status = ?`, status, reviewer.Username, req.Remark, util.Now(), validUntil, req.RequestNo,
+			AccessRequestPending)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Access request is reviewed already")
+		}
+
+		if approval != nil {
+			if err := approval.grant(ar, validUntil); err != nil {
+				// the resource is not granted, the request is pending again
+				err2 := db.Exec(`UPDATE access_request SET status = ?, reviewer = '', review_remark = '', review_time = NULL, valid_until = NULL
+					WHERE request_no = ? AND status = ?`, AccessRequestPending, req.RequestNo, AccessRequestApproved).Error
+				if err2 != nil {
+					rail.Errorf("Failed to restore status of access request %v, %v", req.RequestNo, err2)
+				}
+				return err
+			}
+		}
+		rail.Infof("Access request %v %v by %v", req.RequestNo, status, reviewer.Username)
+
+		msg := fmt.Sprintf("Your request for resource '%v' is %v by %v.", ar.ResCode, status, reviewer.Username)
+		if validUntil != nil {
+			msg += fmt.Sprintf(" The access expires at %v.", validUntil.FormatClassic())
+		}
+		if status == AccessRequestApproved {
+			msg += " Please login again or exchange your token for the access to take effect."
+		}
+		if req.Remark != "" {
+			msg += " Remark: " + req.Remark
+		}
+		err := api.CreateNotifiPipeline.Send(rail, api.CreateNotifiEvent{
+			Title:           "Your access request is reviewed",
+			Message:         msg,
+			ReceiverUserNos: []string{ar.UserNo},
+		})
+		if err != nil {
+			rail.Errorf("Failed to notify requester of access request %v, %v", req.RequestNo, err)
+		}
+		return nil
+	})
+}
+
+// Check whether the request can be reviewed by the reviewer, only pending requests of other users can be reviewed.
+func checkAccessRequestReviewable(ar accessRequest, reviewer common.User) error {
+	if ar.Status != AccessRequestPending {
+		return miso.NewErrf("Access request is %v already", ar.Status)
+	}
+	if ar.UserNo == reviewer.UserNo {
+		return miso.NewErrf("You cannot review your own access request")
+	}
+	return nil
+}
+
+func ListMyAccessRequests(rail miso.Rail, db *gorm.DB, req ListMyAccessRequestsReq, user common.User) (miso.PageRes[ListedAccessRequest], error) {
+	return listAccessRequests(rail, db, req.Paging, func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("a.user_no = ?", user.UserNo)
+		if req.Status != "" {
+			tx = tx.Where("a.status = ?", req.Status)
+		}
+		return tx
+	})
+}
+
+// List access requests of all users, only approvers can access the history.
+func ListAccessRequests(rail miso.Rail, db *gorm.DB, req ListAccessRequestsReq, user common.User) (miso.PageRes[ListedAccessRequest], error) {
+	if err := checkAccessApprover(rail, db, user); err != nil {
+		return miso.PageRes[ListedAccessRequest]{}, err
+	}
+	return listAccessRequests(rail, db, req.Paging, func(tx *gorm.DB) *gorm.DB {
+		if req.Status != "" {
+			tx = tx.Where("a.status = ?", req.Status)
+		}
+		if req.Username != "" {
+			tx = tx.Where("a.username = ?", req.Username)
+		}
+		if req.ResCode != "" {
+			tx = tx.Where("a.res_code = ?", req.ResCode)
+		}
+		return tx
+	})
+}
+
+func listAccessRequests(rail miso.Rail, db *gorm.DB, paging miso.Paging, cond func(tx *gorm.DB) *gorm.DB) (miso.PageRes[ListedAccessRequest], error) {
+	return mysql.NewPageQuery[ListedAccessRequest]().
+		WithPage(paging).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select(`a.request_no, a.user_no, a.username, a.res_code, r.name res_name, a.justification, a.duration_hours,
+				a.status, a.reviewer, a.review_remark, a.review_time, a.valid_until, a.create_time`).
+				Order("a.id DESC")
+		}).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return cond(tx.Table("access_request a").Joins("LEFT JOIN resource r ON a.res_code = r.code"))
+		}).
+		Exec(rail, db)
+}
+
+// Check whether the user holds the resource configured for access request approvers.
+func checkAccessApprover(rail miso.Rail, db *gorm.DB, user common.User) error {
+	roleNos, err := listUserRoleNos(rail, db, user.UserNo)
+	if err != nil {
+		return err
+	}
+	get, err := prefetchRoleResCache(roleResCacheKeys(roleNos, []string{miso.GetPropStr(PropAccessRequestApproverResource)}))
+	if err != nil {
+		return err
+	}
+	return checkAccessApproverWith(rail, get, roleNos)
+}
+
+func checkAccessApproverWith(rail miso.Rail, get roleResGetter, roleNos []string) error {
+	ok, err := checkRolesResWith(rail, get, roleNos, miso.GetPropStr(PropAccessRequestApproverResource), nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return miso.NewErrf("You are not allowed to review access requests")
+	}
+	return nil
+}
+
+// Check whether any of the user's roles has access to the resource, deny rules are respected.
+func userHasRes(rail miso.Rail, db *gorm.DB, userNo string, resCode string) (bool, error) {
+	roleNos, err := listUserRoleNos(rail, db, userNo)
+	if err != nil {
+		return false, err
+	}
+	return checkRolesRes(rail, roleNos, resCode, nil)
+}
+
+// Find or create the personal role of the user, resources of approved access requests are bound to it.
+//
+// The role is granted as an additional role, it's carried by tokens issued or exchanged afterwards.
+func ensurePersonalRole(rail miso.Rail, db *gorm.DB, u User, operator common.User) (string, error) {
+	var roleNo string
+	err := lockUserRole(rail, u.UserNo, func() error {
+		if err := db.Raw(`SELECT role_no FROM role WHERE owner_user_no = ?`, u.UserNo).Scan(&roleNo).Error; err != nil {
+			return err
+		}
+		if roleNo != "" {
+			return nil
+		}
+
+		r := ERole{
+			RoleNo:      util.GenIdP("role_"),
+			Name:        personalRoleName(u.Username),
+			OwnerUserNo: u.UserNo,
+			CreateBy:    operator.Username,
+			UpdateBy:    operator.Username,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Table("role").Omit("Id", "CreateTime", "UpdateTime").Create(&r).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT IGNORE INTO user_role (user_no, role_no, create_by) VALUES (?, ?, ?)`,
+				u.UserNo, r.RoleNo, operator.Username).Error
+		})
+		if err != nil {
+			return err
+		}
+		roleNo = r.RoleNo
+		rail.Infof("Created personal role %v for user %v", roleNo, u.Username)
+		return nil
+	})
+	return roleNo, err
+}
+
+func personalRoleName(username string) string {
+	name := []rune(personalRoleNamePrefix + username)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return string(name)
+}
+
+// Calculate when the access granted for the request expires, nil if the access is permanent.
+func accessRequestValidUntil(now util.ETime, durationHours int) *util.ETime {
+	if durationHours < 1 {
+		return nil
+	}
+	t := now.Add(time.Duration(durationHours) * time.Hour)
+	return &t
+}
+
+func lockAccessRequest(rail miso.Rail, requestNo string, runnable redis.Runnable) error {
+	return redis.RLockExec(rail, "user-vault:access-request:"+requestNo, runnable)
+}
@@ -0,0 +1,165 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+func TestPersonalRoleName(t *testing.T) {
+	if n := personalRoleName("alice"); n != "Personal: alice" {
+		t.Fatalf("unexpected name: %v", n)
+	}
+	n := personalRoleName("a_very_long_username_that_exceeds_the_limit")
+	if len([]rune(n)) != 32 {
+		t.Fatalf("name should be truncated, %v", n)
+	}
+}
+
+func TestAccessRequestValidUntil(t *testing.T) {
+	now := util.Now()
+	if v := accessRequestValidUntil(now, 0); v != nil {
+		t.Fatalf("access should be permanent, %v", v)
+	}
+	v := accessRequestValidUntil(now, 3)
+	if v == nil {
+		t.Fatal("access should be time-bound")
+	}
+	if !v.Equal(now.Add(3 * time.Hour).Time) {
+		t.Fatalf("unexpected valid until: %v", v)
+	}
+}
+
+func TestCheckAccessRequestReviewable(t *testing.T) {
+	reviewer := common.User{UserNo: "reviewer"}
+	pending := accessRequest{UserNo: "requester", Status: AccessRequestPending}
+	if err := checkAccessRequestReviewable(pending, reviewer); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{AccessRequestApproved, AccessRequestDenied, AccessRequestCancelled} {
+		if err := checkAccessRequestReviewable(accessRequest{UserNo: "requester", Status: status}, reviewer); err == nil {
+			t.Fatalf("%v request should not be reviewable", status)
+		}
+	}
+	if err := checkAccessRequestReviewable(pending, common.User{UserNo: "requester"}); err == nil {
+		t.Fatal("requester should not review own request")
+	}
+}
+
+func TestCheckAccessApproverWith(t *testing.T) {
+	rail := miso.EmptyRail()
+	miso.SetProp(PropAccessRequestApproverResource, "access:approve")
+	defer miso.SetProp(PropAccessRequestApproverResource, ResourceManageResources)
+
+	get := testRoleResGetter(map[string]string{
+		roleResCacheKey("approver", "access:approve"): unconditionalRoleRes,
+		roleResCacheKey("wildcard", "access:*"):       unconditionalRoleRes,
+		roleResCacheKey("denied", "!access:approve"):  unconditionalRoleRes,
+	})
+	cases := []struct {
+		roleNos []string
+		ok      bool
+	}{
+		{[]string{"approver"}, true},
+		{[]string{"wildcard"}, true},
+		{[]string{DefaultAdminRoleNo}, true},
+		{[]string{"approver", "denied"}, false},
+		{[]string{"other"}, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if err := checkAccessApproverWith(rail, get, c.roleNos); (err == nil) != c.ok {
+			t.Fatalf("roles: %v, expected approver: %v, err: %v", c.roleNos, c.ok, err)
+		}
+	}
+}
+
+func TestAccessRequestTransitions(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+
+	requester := common.User{UserNo: util.GenIdP("test_"), Username: "test_acr_requester"}
+	reviewer := common.User{UserNo: util.GenIdP("test_"), Username: "test_acr_reviewer"}
+	resCode := "test:access-request"
+	insertUser := `INSERT INTO user (username, password, salt, review_status, user_no, role_no) VALUES (?, '', '', 'APPROVED', ?, ?)`
+	if err := db.Exec(insertUser, requester.Username, requester.UserNo, "").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(insertUser, reviewer.Username, reviewer.UserNo, DefaultAdminRoleNo).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO resource (code, name) VALUES (?, 'Test Access Request')`, resCode).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Exec(`DELETE FROM role_resource WHERE role_no IN (SELECT role_no FROM role WHERE owner_user_no = ?)`, requester.UserNo)
+		db.Exec(`DELETE FROM user_role WHERE user_no = ?`, requester.UserNo)
+		db.Exec(`DELETE FROM role WHERE owner_user_no = ?`, requester.UserNo)
+		db.Exec(`DELETE FROM access_request WHERE user_no = ?`, requester.UserNo)
+		db.Exec(`DELETE FROM resource WHERE code = ?`, resCode)
+		db.Exec(`DELETE FROM user WHERE user_no IN ?`, []string{requester.UserNo, reviewer.UserNo})
+	}()
+
+	newRequest := func() string {
+		requestNo := util.GenIdP("acr_")
+		err := db.Exec(`INSERT INTO access_request (request_no, user_no, username, res_code, justification, status) VALUES (?, ?, ?, ?, 'test', ?)`,
+			requestNo, requester.UserNo, requester.Username, resCode, AccessRequestPending).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		return requestNo
+	}
+	assertStatus := func(requestNo string, expected string) {
+		var status string
+		if err := db.Raw(`SELECT status FROM access_request WHERE request_no = ?`, requestNo).Scan(&status).Error; err != nil {
+			t.Fatal(err)
+		}
+		if status != expected {
+			t.Fatalf("request %v, expected %v, actual %v", requestNo, expected, status)
+		}
+	}
+
+	// pending -> cancelled
+	cancelled := newRequest()
+	if err := CancelAccessRequest(rail, db, CancelAccessRequestReq{RequestNo: cancelled}, requester); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(cancelled, AccessRequestCancelled)
+	if err := ApproveAccessRequest(rail, db, ReviewAccessRequestReq{RequestNo: cancelled}, reviewer); err == nil {
+		t.Fatal("cancelled request should not be approved")
+	}
+
+	// pending -> denied
+	denied := newRequest()
+	if err := DenyAccessRequest(rail, db, ReviewAccessRequestReq{RequestNo: denied}, reviewer); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(denied, AccessRequestDenied)
+	if err := CancelAccessRequest(rail, db, CancelAccessRequestReq{RequestNo: denied}, requester); err == nil {
+		t.Fatal("denied request should not be cancelled")
+	}
+
+	// requester is not an approver
+	approved := newRequest()
+	if err := ApproveAccessRequest(rail, db, ReviewAccessRequestReq{RequestNo: approved}, requester); err == nil {
+		t.Fatal("requester should not approve the request")
+	}
+	assertStatus(approved, AccessRequestPending)
+
+	// pending -> approved
+	if err := ApproveAccessRequest(rail, db, ReviewAccessRequestReq{RequestNo: approved}, reviewer); err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(approved, AccessRequestApproved)
+	if ok, err := userHasRes(rail, db, requester.UserNo, resCode); err != nil || !ok {
+		t.Fatalf("resource should be granted, %v", err)
+	}
+	if err := ApproveAccessRequest(rail, db, ReviewAccessRequestReq{RequestNo: approved}, reviewer); err == nil {
+		t.Fatal("approved request should not be approved again")
+	}
+}
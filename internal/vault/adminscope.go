@@ -0,0 +1,333 @@
+package vault
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	AdminScopeRole      = "ROLE"
+	AdminScopeResPrefix = "RES_PREFIX"
+)
+
+type AddAdminScopeReq struct {
+	UserNo     string `json:"userNo" valid:"notEmpty"`
+	ScopeType  string `json:"scopeType" valid:"notEmpty" desc:"ROLE or RES_PREFIX"`
+	ScopeValue string `json:"scopeValue" valid:"notEmpty,maxLen:32" desc:"role no or resource code prefix"`
+}
+
+type RemoveAdminScopeReq struct {
+	UserNo     string `json:"userNo" valid:"notEmpty"`
+	ScopeType  string `json:"scopeType" valid:"notEmpty"`
+	ScopeValue string `json:"scopeValue" valid:"notEmpty"`
+}
+
+type ListAdminScopesReq struct {
+	UserNo string `json:"userNo" desc:"optional, list scopes of all delegated admins if empty"`
+}
+
+type AdminScope struct {
+	UserNo     string     `json:"userNo"`
+	Username   string     `json:"username"`
+	ScopeType  string     `json:"scopeType"`
+	ScopeValue string     `json:"scopeValue"`
+	CreateTime util.ETime `json:"createTime"`
+	CreateBy   string     `json:"createBy"`
+}
+
+// Scopes of admin, admin without any scope is not restricted.
+type adminScopes struct {
+	superAdmin  bool
+	roles       []string
+	resPrefixes []string
+}
+
+func (s adminScopes) restricted() bool {
+	return !s.superAdmin && (len(s.roles) > 0 || len(s.resPrefixes) > 0)
+}
+
+func (s adminScopes) canManageRole(roleNo string) bool {
+	if !s.restricted() || len(s.roles) < 1 {
+		return true
+	}
+	return slices.Contains(s.roles, roleNo)
+}
+
+func (s adminScopes) canManageRes(resCode string) bool {
+	if !s.restricted() || len(s.resPrefixes) < 1 {
+		return true
+	}
+	for _, p := range s.resPrefixes {
+		if strings.HasPrefix(resCode, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadAdminScopes(rail miso.Rail, db *gorm.DB, userNo string) (adminScopes, error) {
+	var s adminScopes
+	superAdmin, err := userHasRole(rail, db, userNo, DefaultAdminRoleNo)
+	if err != nil {
+		return s, err
+	}
+	if superAdmin {
+		return adminScopes{superAdmin: true}, nil
+	}
+
+	var rows []AdminScope
+	if err := db.Raw(`SELECT scope_type, scope_value FROM admin_scope WHERE user_no = ?`, userNo).Scan(&rows).Error; err != nil {
+		return s, err
+	}
+	for _, r := range rows {
+		switch r.ScopeType {
+		case AdminScopeRole:
+			s.roles = append(s.roles, r.ScopeValue)
+		case AdminScopeResPrefix:
+			s.resPrefixes = append(s.resPrefixes, r.ScopeValue)
+		}
+	}
+	return s, nil
+}
+
+func checkSuperAdmin(rail miso.Rail, db *gorm.DB, operator common.User) error {
+	ok, err := userHasRole(rail, db, operator.UserNo, DefaultAdminRoleNo)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return miso.NewErrf("Only administrators can perform this operation").WithCode(ErrCodeOutOfAdminScope)
+	}
+	return nil
+}
+
+// Check whether the operator can assign the role to users or groups.
+//
+// The administrator role can only be assigned by existing administrators.
+func checkRoleAssignable(rail miso.Rail, db *gorm.DB, operator common.User, roleNo string) error {
+	if roleNo == "" {
+		return nil
+	}
+	if roleNo == DefaultAdminRoleNo {
+		return checkSuperAdmin(rail, db, operator)
+	}
+	s, err := loadAdminScopes(rail, db, operator.UserNo)
+	if err != nil {
+		return err
+	}
+	if !s.canManageRole(roleNo) {
+		return miso.NewErrf("You are not allowed to manage the role").WithCode(ErrCodeOutOfAdminScope)
+	}
+	return nil
+}
+
+// Check whether the operator can manage the user, i.e., delete, restore, rename or update the user.
+//
+// Only administrators can manage other administrators, and delegated admins can only manage users whose roles are
+// all within their scopes, personal roles are not counted.
+func checkUserManageable(rail miso.Rail, db *gorm.DB, operator common.User, userNo string) error {
+	roleNos, err := listUserRoleNos(rail, db, userNo)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(roleNos, DefaultAdminRoleNo) {
+		return checkSuperAdmin(rail, db, operator)
+	}
+	s, err := loadAdminScopes(rail, db, operator.UserNo)
+	if err != nil {
+		return err
+	}
+	var personal string
+	if err := db.Raw(`SELECT role_no FROM role WHERE owner_user_no = ?`, userNo).Scan(&personal).Error; err != nil {
+		return err
+	}
+	for _, r := range roleNos {
+		if r != personal && !s.canManageRole(r) {
+			return miso.NewErrf("You are not allowed to manage the user").
+				WithCode(ErrCodeOutOfAdminScope).
+				WithInternalMsg("User %v holds role %v", userNo, r)
+		}
+	}
+	return nil
+}
+
+// Check whether the operator can grant the resource to the role, resCode is optional.
+//
+// Operators cannot grant resources to the roles they hold, including the ones inheriting from the role.
+func checkRoleResGrantable(rail miso.Rail, db *gorm.DB, operator common.User, roleNo string, resCode string) error {
+	s, err := loadAdminScopes(rail, db, operator.UserNo)
+	if err != nil {
+		return err
+	}
+	if s.superAdmin {
+		return nil
+	}
+	if !s.canManageRole(roleNo) {
+		return miso.NewErrf("You are not allowed to manage the role").WithCode(ErrCodeOutOfAdminScope)
+	}
+	if resCode != "" && !s.canManageRes(resCode) {
+		return miso.NewErrf("You are not allowed to manage the resource").WithCode(ErrCodeOutOfAdminScope)
+	}
+
+	return checkRoleNotHeld(rail, db, operator, roleNo, "You cannot grant resources to your own roles")
+}
+
+// Check whether the operator can manage all resources of the role, including the inherited ones, before they are
+// copied to another role, e.g., cloning the role or creating a child role.
+func checkRoleResCopyable(rail miso.Rail, db *gorm.DB, operator common.User, roleNo string) error {
+	s, err := loadAdminScopes(rail, db, operator.UserNo)
+	if err != nil {
+		return err
+	}
+	if !s.restricted() {
+		return nil
+	}
+	parents, err := loadRoleParents(rail)
+	if err != nil {
+		return err
+	}
+	var resCodes []string
+	err = db.Raw(`SELECT DISTINCT res_code FROM role_resource WHERE role_no IN ?`, parents.ancestors(roleNo)).Scan(&resCodes).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range resCodes {
+		if !s.canManageRes(c) {
+			return miso.NewErrf("You are not allowed to manage resource '%v' of the role", c).WithCode(ErrCodeOutOfAdminScope)
+		}
+	}
+	return nil
+}
+
+// Check whether the operator can remove the resource from the role.
+//
+// Like granting resources, operators cannot remove deny rules from the roles they hold, including the ones
+// inheriting from the role.
+func checkRoleResRemovable(rail miso.Rail, db *gorm.DB, operator common.User, roleNo string, resCode string) error {
+	s, err := loadAdminScopes(rail, db, operator.UserNo)
+	if err != nil {
+		return err
+	}
+	if s.superAdmin {
+		return nil
+	}
+	if !s.canManageRole(roleNo) || !s.canManageRes(resCode) {
+		return miso.NewErrf("You are not allowed to manage the resource of the role").WithCode(ErrCodeOutOfAdminScope)
+	}
+
+	var effect string
+	err = db.Raw(`SELECT effect FROM role_resource WHERE role_no = ? AND res_code = ?`, roleNo, resCode).Scan(&effect).Error
+	if err != nil {
+		return err
+	}
+	if effect != ResEffectDeny {
+		return nil
+	}
+	return checkRoleNotHeld(rail, db, operator, roleNo, "You cannot remove deny rules from your own roles")
+}
+
+// Check whether the operator holds the role or any of the roles inheriting from it.
+func checkRoleNotHeld(rail miso.Rail, db *gorm.DB, operator common.User, roleNo string, msg string) error {
+	held, err := listUserRoleNos(rail, db, operator.UserNo)
+	if err != nil {
+		return err
+	}
+	parents, err := loadRoleParents(rail)
+	if err != nil {
+		return err
+	}
+	if r, ok := findHeldDescendant(parents, held, roleNo); ok {
+		return miso.NewErrf("%s", msg).
+			WithCode(ErrCodeOutOfAdminScope).
+			WithInternalMsg("Operator %v holds role %v", operator.Username, r)
+	}
+	return nil
+}
+
+// Find the held role that is the role itself or inherits from the role.
+func findHeldDescendant(parents roleParents, held []string, roleNo string) (string, bool) {
+	for _, r := range parents.descendants(roleNo) {
+		if slices.Contains(held, r) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// Add scope to delegated admin, only administrators can manage the scopes.
+func AddAdminScope(rail miso.Rail, db *gorm.DB, req AddAdminScopeReq, operator common.User) error {
+	if err := checkSuperAdmin(rail, db, operator); err != nil {
+		return err
+	}
+	switch req.ScopeType {
+	case AdminScopeRole:
+		if req.ScopeValue == DefaultAdminRoleNo {
+			return miso.NewErrf("Administrator role cannot be delegated")
+		}
+		var id int
+		if err := db.Raw(`SELECT id FROM role WHERE role_no = ? AND owner_user_no = ''`, req.ScopeValue).Scan(&id).Error; err != nil {
+			return err
+		}
+		if id < 1 {
+			return miso.NewErrf("Role not found").WithCode(ErrCodeRoleNotFound)
+		}
+	case AdminScopeResPrefix:
+	default:
+		return miso.NewErrf("Invalid scope type")
+	}
+
+	u, err := loadUserByUserNo(rail, db, req.UserNo)
+	if err != nil {
+		return err
+	}
+	if u.Deleted() {
+		return miso.NewErrf("User not found")
+	}
+
+	err = db.Exec(`INSERT IGNORE INTO admin_scope (user_no, scope_type, scope_value, create_by) VALUES (?, ?, ?, ?)`,
+		req.UserNo, req.ScopeType, req.ScopeValue, operator.Username).Error
+	if err != nil {
+		return err
+	}
+	rail.Infof("Admin scope %v '%v' added to %v by %v", req.ScopeType, req.ScopeValue, u.Username, operator.Username)
+	return nil
+}
+
+func RemoveAdminScope(rail miso.Rail, db *gorm.DB, req RemoveAdminScopeReq, operator common.User) error {
+	if err := checkSuperAdmin(rail, db, operator); err != nil {
+		return err
+	}
+	t := db.Exec(`DELETE FROM admin_scope WHERE user_no = ? AND scope_type = ? AND scope_value = ?`,
+		req.UserNo, req.ScopeType, req.ScopeValue)
+	if t.Error != nil {
+		return t.Error
+	}
+	if t.RowsAffected < 1 {
+		return miso.NewErrf("Admin scope not found")
+	}
+	rail.Infof("Admin scope %v '%v' removed from %v by %v", req.ScopeType, req.ScopeValue, req.UserNo, operator.Username)
+	return nil
+}
+
+func ListAdminScopes(rail miso.Rail, db *gorm.DB, req ListAdminScopesReq) ([]AdminScope, error) {
+	tx := db.Table("admin_scope s").
+		Select("s.user_no, u.username, s.scope_type, s.scope_value, s.create_time, s.create_by").
+		Joins("LEFT JOIN user u ON s.user_no = u.user_no").
+		Order("s.id DESC")
+	if req.UserNo != "" {
+		tx = tx.Where("s.user_no = ?", req.UserNo)
+	}
+	var scopes []AdminScope
+	if err := tx.Scan(&scopes).Error; err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = []AdminScope{}
+	}
+	return scopes, nil
+}
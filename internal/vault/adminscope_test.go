@@ -0,0 +1,47 @@
+package vault
+
+import "testing"
+
+func TestAdminScopes(t *testing.T) {
+	unrestricted := adminScopes{}
+	if !unrestricted.canManageRole("role_a") || !unrestricted.canManageRes("postbox:notification:query") {
+		t.Fatal("admin without scopes should not be restricted")
+	}
+
+	super := adminScopes{superAdmin: true, roles: []string{"role_a"}}
+	if !super.canManageRole("role_b") {
+		t.Fatal("administrator should not be restricted")
+	}
+
+	roleOnly := adminScopes{roles: []string{"role_a"}}
+	if !roleOnly.canManageRole("role_a") || roleOnly.canManageRole("role_b") {
+		t.Fatal("role scope not applied")
+	}
+	if !roleOnly.canManageRes("manage-users") {
+		t.Fatal("resources should not be restricted without RES_PREFIX scope")
+	}
+
+	resOnly := adminScopes{resPrefixes: []string{"postbox:", "vfm:"}}
+	if !resOnly.canManageRes("postbox:notification:query") || !resOnly.canManageRes("vfm:file") {
+		t.Fatal("resource in scope should be manageable")
+	}
+	if resOnly.canManageRes("manage-users") {
+		t.Fatal("resource out of scope should not be manageable")
+	}
+	if !resOnly.canManageRole("role_b") {
+		t.Fatal("roles should not be restricted without ROLE scope")
+	}
+}
+
+func TestFindHeldDescendant(t *testing.T) {
+	parents := roleParents{"base": "", "team": "base", "lead": "team", "other": ""}
+	if r, ok := findHeldDescendant(parents, []string{"lead"}, "base"); !ok || r != "lead" {
+		t.Fatalf("role inheriting from base should be found, %v", r)
+	}
+	if r, ok := findHeldDescendant(parents, []string{"team"}, "team"); !ok || r != "team" {
+		t.Fatalf("role itself should be found, %v", r)
+	}
+	if _, ok := findHeldDescendant(parents, []string{"base", "other"}, "lead"); ok {
+		t.Fatal("ancestors should not be found")
+	}
+}
@@ -0,0 +1,180 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	challengeTtl = 5 * time.Minute
+)
+
+var (
+	ErrChallengeRequired = miso.NewErrf("Please solve the challenge first").WithCode(ErrCodeChallengeRequired)
+	ErrChallengeFailed   = miso.NewErrf("Challenge failed, please try again").WithCode(ErrCodeChallengeFailed)
+)
+
+// Hashcash-style proof of work challenge.
+//
+// Client must find a solution that sha256(seed + solution) has at least 'difficulty' leading zero bits.
+type Challenge struct {
+	ChallengeId string     `json:"challengeId"`
+	Seed        string     `json:"seed"`
+	Difficulty  int        `json:"difficulty" desc:"number of leading zero bits required in sha256(seed + solution)"`
+	ExpireTime  util.ETime `json:"expireTime"`
+}
+
+func IssueChallenge(rail miso.Rail) (Challenge, error) {
+	c := Challenge{
+		ChallengeId: util.GenIdP("chlg_"),
+		Seed:        util.RandStr(32),
+		Difficulty:  miso.GetPropInt(PropChallengeDifficulty),
+		ExpireTime:  util.Now().Add(challengeTtl),
+	}
+	val := strconv.Itoa(c.Difficulty) + ":" + c.Seed
+	if err := redis.GetRedis().Set(challengeKey(c.ChallengeId), val, challengeTtl).Err(); err != nil {
+		return Challenge{}, fmt.Errorf("failed to save challenge, %w", err)
+	}
+	return c, nil
+}
+
+// Verify the solution of the challenge, each challenge can only be used once.
+func VerifyChallenge(rail miso.Rail, challengeId string, solution string) error {
+	if challengeId == "" || solution == "" {
+		return ErrChallengeRequired
+	}
+
+	key := challengeKey(challengeId)
+	val, err := redis.GetRedis().Get(key).Result()
+	if err != nil {
+		if redis.IsNil(err) {
+			return ErrChallengeFailed.WithInternalMsg("challenge %v not found or expired", challengeId)
+		}
+		return fmt.Errorf("failed to load challenge, %w", err)
+	}
+
+	// the challenge is consumed no matter whether the solution is correct
+	n, err := redis.GetRedis().Del(key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove challenge, %w", err)
+	}
+	if n < 1 {
+		return ErrChallengeFailed.WithInternalMsg("challenge %v is already used", challengeId)
+	}
+
+	ds, seed, ok := strings.Cut(val, ":")
+	if !ok {
+		return ErrChallengeFailed.WithInternalMsg("challenge %v is malformed, %v", challengeId, val)
+	}
+	difficulty, err := strconv.Atoi(ds)
+	if err != nil {
+		return ErrChallengeFailed.WithInternalMsg("challenge %v is malformed, %v", challengeId, val)
+	}
+
+	if !checkPow(seed, solution, difficulty) {
+		return ErrChallengeFailed.WithInternalMsg("incorrect solution for challenge %v", challengeId)
+	}
+	return nil
+}
+
+func challengeKey(challengeId string) string {
+	return "user-vault:challenge:" + challengeId
+}
+
+func checkPow(seed string, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(seed + solution))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, v := range b {
+		if v == 0 {
+			n += 8
+			continue
+		}
+		return n + bits.LeadingZeros8(v)
+	}
+	return n
+}
+
+// Check whether the login attempt should solve a challenge first.
+func checkLoginChallenge(rail miso.Rail, username string, remoteAddr string, challengeId string, solution string) error {
+	threshold := miso.GetPropInt(PropLoginChallengeAfterFailures)
+	if threshold < 1 {
+		return nil
+	}
+
+	failures, err := getWindowCounter(loginFailureKey("username", username))
+	if err != nil {
+		return err
+	}
+	if failures < int64(threshold) && remoteAddr != "unknown" {
+		failures, err = getWindowCounter(loginFailureKey("ip", remoteAddr))
+		if err != nil {
+			return err
+		}
+	}
+	if failures < int64(threshold) {
+		return nil
+	}
+
+	rail.Infof("User %v (%v) has failed to login %v times recently, challenge required", username, remoteAddr, failures)
+	return VerifyChallenge(rail, challengeId, solution)
+}
+
+func recordLoginFailure(rail miso.Rail, username string, remoteAddr string) {
+	window := miso.GetPropDur(PropLoginFailureWindow, time.Minute)
+	if _, err := incrWindowCounter(loginFailureKey("username", username), window); err != nil {
+		rail.Errorf("Failed to record login failure, username: %v, %v", username, err)
+	}
+	if remoteAddr == "unknown" {
+		return
+	}
+	if _, err := incrWindowCounter(loginFailureKey("ip", remoteAddr), window); err != nil {
+		rail.Errorf("Failed to record login failure, remoteAddr: %v, %v", remoteAddr, err)
+	}
+}
+
+func clearLoginFailure(rail miso.Rail, username string) {
+	if err := redis.GetRedis().Del(loginFailureKey("username", username)).Err(); err != nil {
+		rail.Errorf("Failed to clear login failure, username: %v, %v", username, err)
+	}
+}
+
+func loginFailureKey(kind string, val string) string {
+	return "user-vault:login:failure:" + kind + ":" + val
+}
+
+// Increment counter, the counter is reset once the window is passed.
+func incrWindowCounter(key string, window time.Duration) (int64, error) {
+	n, err := redis.GetRedis().Incr(key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to incr counter %v, %w", key, err)
+	}
+	if n == 1 {
+		if err := redis.GetRedis().Expire(key, window).Err(); err != nil {
+			return n, fmt.Errorf("failed to set expiration for counter %v, %w", key, err)
+		}
+	}
+	return n, nil
+}
+
+func getWindowCounter(key string) (int64, error) {
+	n, err := redis.GetRedis().Get(key).Int64()
+	if err != nil {
+		if redis.IsNil(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get counter %v, %w", key, err)
+	}
+	return n, nil
+}
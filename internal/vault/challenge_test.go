@@ -0,0 +1,43 @@
+package vault
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestLeadingZeroBits(t *testing.T) {
+	if v := leadingZeroBits([]byte{0, 0, 1}); v != 23 {
+		t.Fatal(v)
+	}
+	if v := leadingZeroBits([]byte{0x80}); v != 0 {
+		t.Fatal(v)
+	}
+	if v := leadingZeroBits([]byte{0, 0x0f}); v != 12 {
+		t.Fatal(v)
+	}
+	if v := leadingZeroBits([]byte{0, 0}); v != 16 {
+		t.Fatal(v)
+	}
+}
+
+func TestCheckPow(t *testing.T) {
+	seed := "abcdefg"
+	difficulty := 12
+
+	var solution string
+	for i := 0; ; i++ {
+		s := strconv.Itoa(i)
+		if checkPow(seed, s, difficulty) {
+			solution = s
+			break
+		}
+	}
+	t.Logf("solution: %v", solution)
+
+	if !checkPow(seed, solution, difficulty) {
+		t.Fatal("should pass")
+	}
+	if checkPow(seed+"1", solution, 32) {
+		t.Fatal("should not pass")
+	}
+}
@@ -0,0 +1,79 @@
+package vault
+
+import "github.com/curtisnewbie/miso/miso"
+
+const (
+	// pending registrations older than N days are expired automatically, 0 to disable
+	PropRegistrationExpireDays = "user-vault.registration.expire-after-days"
+
+	// number of leading zero bits required for proof of work challenge
+	PropChallengeDifficulty = "user-vault.challenge.difficulty"
+
+	// whether registration requires challenge
+	PropChallengeRegistrationEnabled = "user-vault.challenge.registration.enabled"
+
+	// login requires challenge after N recent failures of the username or ip address, 0 to disable
+	PropLoginChallengeAfterFailures = "user-vault.challenge.login.after-failures"
+
+	// window (in minutes) of recent login failures
+	PropLoginFailureWindow = "user-vault.challenge.login.failure-window"
+
+	// list of custom profile attributes, see ProfileAttributeDef
+	PropProfileAttributes = "user-vault.profile.attributes"
+
+	// base url of email verification link, token is appended as query parameter
+	PropEmailVerifyLink = "user-vault.email.verify-link"
+
+	// base url of password reset link (usually a frontend page), token is appended as query parameter
+	PropPasswordResetLink = "user-vault.email.password-reset-link"
+
+	// whether notifications are mirrored to email for users who opt in
+	PropNotificationEmailEnabled = "user-vault.notification.email.enabled"
+
+	// whether users can login using code or link sent to their verified email
+	PropEmailLoginEnabled = "user-vault.login.email.enabled"
+
+	// base url of email login link (usually a frontend page), token is appended as query parameter
+	PropEmailLoginLink = "user-vault.login.email.link"
+
+	// max number of email login requests per user in an hour
+	PropEmailLoginUserLimit = "user-vault.login.email.user-limit"
+
+	// max number of email login requests per ip address in an hour
+	PropEmailLoginIpLimit = "user-vault.login.email.ip-limit"
+
+	// days that a previous username is reserved for the user after renaming
+	PropUsernameReserveDays = "user-vault.username.reserve-days"
+
+	// days that a deleted user can be restored, the user is purged afterwards
+	PropUserRestoreDays = "user-vault.user.restore-days"
+
+	// grantees are notified N hours before time-bound grants expire, 0 to disable
+	PropGrantExpiryNotifyHours = "user-vault.grant.expiry-notify-hours"
+
+	// holders of the resource are notified of and can review access requests
+	PropAccessRequestApproverResource = "user-vault.access-request.approver-resource"
+
+	// max number of paths that can be checked in one batch access check
+	PropAccessCheckBatchLimit = "user-vault.access-check.batch-limit"
+)
+
+func init() {
+	miso.SetDefProp(PropRegistrationExpireDays, 30)
+	miso.SetDefProp(PropChallengeDifficulty, 20)
+	miso.SetDefProp(PropChallengeRegistrationEnabled, true)
+	miso.SetDefProp(PropLoginChallengeAfterFailures, 3)
+	miso.SetDefProp(PropLoginFailureWindow, 30)
+	miso.SetDefProp(PropEmailVerifyLink, "http://localhost:8089/open/api/user/email/verify")
+	miso.SetDefProp(PropPasswordResetLink, "http://localhost:8089/password/reset")
+	miso.SetDefProp(PropNotificationEmailEnabled, true)
+	miso.SetDefProp(PropEmailLoginEnabled, false)
+	miso.SetDefProp(PropEmailLoginLink, "http://localhost:8089/login/email")
+	miso.SetDefProp(PropEmailLoginUserLimit, 5)
+	miso.SetDefProp(PropEmailLoginIpLimit, 20)
+	miso.SetDefProp(PropUsernameReserveDays, 30)
+	miso.SetDefProp(PropUserRestoreDays, 30)
+	miso.SetDefProp(PropGrantExpiryNotifyHours, 24)
+	miso.SetDefProp(PropAccessRequestApproverResource, ResourceManageResources)
+	miso.SetDefProp(PropAccessCheckBatchLimit, 100)
+}
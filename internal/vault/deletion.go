@@ -0,0 +1,167 @@
+package vault
+
+import (
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+type AdminDeleteUserReq struct {
+	UserNo string `json:"userNo" valid:"notEmpty"`
+}
+
+// Soft-delete user, the user can be restored within the restore window, then it's purged by PurgeDeletedUsers.
+//
+// Only administrators can delete other administrators.
+func AdminDeleteUser(rail miso.Rail, tx *gorm.DB, req AdminDeleteUserReq, operator common.User) error {
+	if req.UserNo == operator.UserNo {
+		return miso.NewErrf("You cannot delete yourself")
+	}
+
+	return redis.RLockExec(rail, "user-vault:user:deletion:"+req.UserNo, func() error {
+		u, err := loadUserByUserNo(rail, tx, req.UserNo)
+		if err != nil {
+			return err
+		}
+		if err := checkUserManageable(rail, tx, operator, u.UserNo); err != nil {
+			return err
+		}
+
+		err = tx.Exec(`UPDATE user SET is_del = 1, del_time = ?, update_by = ? WHERE user_no = ? AND is_del = 0`,
+			util.Now(), operator.Username, u.UserNo).Error
+		if err != nil {
+			return err
+		}
+		rail.Infof("User %v deleted by %v", u.Username, operator.Username)
+
+		if err := InvalidateUserInfoCache(rail, u.Username); err != nil {
+			rail.Errorf("Failed to invalidate user info cache, username: %v, %v", u.Username, err)
+		}
+		if err := RevokeUserTokens(rail, u.UserNo); err != nil {
+			rail.Errorf("Failed to revoke tokens of user %v, %v", u.UserNo, err)
+		}
+		return nil
+	})
+}
+
+type AdminRestoreUserReq struct {
+	UserNo string `json:"userNo" valid:"notEmpty"`
+}
+
+func AdminRestoreUser(rail miso.Rail, tx *gorm.DB, req AdminRestoreUserReq, operator common.User) error {
+	return redis.RLockExec(rail, "user-vault:user:deletion:"+req.UserNo, func() error {
+		var u DeletedUser
+		t := tx.Raw(`SELECT id, user_no, username, del_time FROM user WHERE user_no = ? AND is_del = 1`, req.UserNo).Scan(&u)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("User not found")
+		}
+		if u.DelTime == nil || u.DelTime.Before(restoreDeadline()) {
+			return miso.NewErrf("User can no longer be restored")
+		}
+		if err := checkUserManageable(rail, tx, operator, u.UserNo); err != nil {
+			return err
+		}
+
+		err := tx.Exec(`UPDATE user SET is_del = 0, del_time = NULL, update_by = ? WHERE user_no = ? AND is_del = 1`,
+			operator.Username, u.UserNo).Error
+		if err != nil {
+			return err
+		}
+		rail.Infof("User %v restored by %v", u.Username, operator.Username)
+		return nil
+	})
+}
+
+func restoreDeadline() util.ETime {
+	return util.Now().Add(-time.Duration(miso.GetPropInt(PropUserRestoreDays)) * 24 * time.Hour)
+}
+
+type DeletedUser struct {
+	Id           int         `json:"id"`
+	UserNo       string      `json:"userNo"`
+	Username     string      `json:"username"`
+	DelTime      *util.ETime `json:"delTime"`
+	RestoreUntil *util.ETime `json:"restoreUntil" desc:"the user is purged after this time"`
+}
+
+type ListDeletedUsersReq struct {
+	Paging miso.Paging `json:"paging"`
+}
+
+func ListDeletedUsers(rail miso.Rail, tx *gorm.DB, req ListDeletedUsersReq) (miso.PageRes[DeletedUser], error) {
+	days := time.Duration(miso.GetPropInt(PropUserRestoreDays)) * 24 * time.Hour
+	return mysql.NewPageQuery[DeletedUser]().
+		WithPage(req.Paging).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id, user_no, username, del_time").Order("del_time DESC")
+		}).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("user").Where("is_del = 1")
+		}).
+		ForEach(func(t DeletedUser) DeletedUser {
+			if t.DelTime != nil {
+				ru := t.DelTime.Add(days)
+				t.RestoreUntil = &ru
+			}
+			return t
+		}).
+		Exec(rail, tx)
+}
+
+// Purge users that are deleted and can no longer be restored.
+func PurgeDeletedUsers(rail miso.Rail) error {
+	db := mysql.GetMySQL()
+	var users []DeletedUser
+	err := db.Raw(`SELECT id, user_no, username, del_time FROM user WHERE is_del = 1 AND del_time < ?`, restoreDeadline()).
+		Scan(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if err := purgeUser(rail, db, u); err != nil {
+			rail.Errorf("Failed to purge user %v, %v", u.UserNo, err)
+			continue
+		}
+		rail.Infof("Purged user %v (%v)", u.Username, u.UserNo)
+	}
+	return nil
+}
+
+// Remove the user's rows across all tables, access logs are kept for auditing, but they are anonymized.
+func purgeUser(rail miso.Rail, db *gorm.DB, u DeletedUser) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		anonymized := "deleted_" + u.UserNo
+		stmts := []struct {
+			sql  string
+			args []any
+		}{
+			{`DELETE FROM user_key WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM site_password WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM notification WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM username_history WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM user_role WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM user_group_member WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM role_resource WHERE role_no IN (SELECT role_no FROM role WHERE owner_user_no = ?)`, []any{u.UserNo}},
+			{`DELETE FROM role WHERE owner_user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM access_request WHERE user_no = ?`, []any{u.UserNo}},
+			{`DELETE FROM admin_scope WHERE user_no = ?`, []any{u.UserNo}},
+			{`UPDATE access_log SET username = ?, ip_address = '', user_agent = '' WHERE user_id = ?`, []any{anonymized, u.Id}},
+			{`DELETE FROM user WHERE user_no = ? AND is_del = 1`, []any{u.UserNo}},
+		}
+		for _, s := range stmts {
+			if err := tx.Exec(s.sql, s.args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
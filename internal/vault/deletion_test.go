@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+// Insert an approved user for testing, the user and its rows are removed when the test finishes.
+func insertTestUser(t *testing.T, db *gorm.DB) DeletedUser {
+	u := DeletedUser{UserNo: util.GenIdP("UE"), Username: util.ERand(20)}
+	err := db.Exec(`INSERT INTO user (username, password, salt, review_status, user_no) VALUES (?, '', '', ?, ?)`,
+		u.Username, api.ReviewApproved, u.UserNo).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Raw(`SELECT id FROM user WHERE user_no = ?`, u.UserNo).Scan(&u.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM user_key WHERE user_no = ?`, u.UserNo)
+		db.Exec(`DELETE FROM site_password WHERE user_no = ?`, u.UserNo)
+		db.Exec(`DELETE FROM notification WHERE user_no = ?`, u.UserNo)
+		db.Exec(`DELETE FROM username_history WHERE user_no = ?`, u.UserNo)
+		db.Exec(`DELETE FROM access_log WHERE user_id = ?`, u.Id)
+		db.Exec(`DELETE FROM user WHERE user_no = ?`, u.UserNo)
+	})
+	return u
+}
+
+// Insert rows that belong to the user across tables.
+func insertTestUserData(t *testing.T, db *gorm.DB, u DeletedUser) {
+	stmts := []struct {
+		sql  string
+		args []any
+	}{
+		{`INSERT INTO user_key (user_id, user_no, name, secret_key, expiration_time) VALUES (?, ?, 'test', ?, ?)`,
+			[]any{u.Id, u.UserNo, util.ERand(40), util.Now().AddDate(0, 0, 1)}},
+		{`INSERT INTO site_password (record_id, site, alias, username, password, user_no) VALUES (?, 'site', 'alias', 'site_user', 'pw', ?)`,
+			[]any{util.GenIdP("SP"), u.UserNo}},
+		{`INSERT INTO notification (notifi_no, user_no, title, message) VALUES (?, ?, 'title', 'message')`,
+			[]any{util.GenIdP("NOTI"), u.UserNo}},
+		{`INSERT INTO access_log (ip_address, username, user_id, user_agent) VALUES ('127.0.0.1', ?, ?, 'test agent')`,
+			[]any{u.Username, u.Id}},
+	}
+	for _, s := range stmts {
+		if err := db.Exec(s.sql, s.args...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func countRows(t *testing.T, db *gorm.DB, sql string, args ...any) int {
+	var n int
+	if err := db.Raw(sql, args...).Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAdminDeleteRestoreUser(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+	u := insertTestUser(t, db)
+	operator := common.User{UserNo: util.GenIdP("UE"), Username: "test_operator"}
+
+	if err := AdminDeleteUser(rail, db, AdminDeleteUserReq{UserNo: u.UserNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, `SELECT count(*) FROM user WHERE user_no = ? AND is_del = 1 AND del_time IS NOT NULL`, u.UserNo); n != 1 {
+		t.Fatal("user should be deleted")
+	}
+
+	if err := AdminRestoreUser(rail, db, AdminRestoreUserReq{UserNo: u.UserNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, `SELECT count(*) FROM user WHERE user_no = ? AND is_del = 0 AND del_time IS NULL`, u.UserNo); n != 1 {
+		t.Fatal("user should be restored")
+	}
+
+	// user that is not deleted can't be restored
+	if err := AdminRestoreUser(rail, db, AdminRestoreUserReq{UserNo: u.UserNo}, operator); err == nil {
+		t.Fatal("restoring user that is not deleted should fail")
+	}
+	// operator can't delete themselves
+	if err := AdminDeleteUser(rail, db, AdminDeleteUserReq{UserNo: operator.UserNo}, operator); err == nil {
+		t.Fatal("deleting operator themselves should fail")
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+	u := insertTestUser(t, db)
+	insertTestUserData(t, db, u)
+	if err := db.Exec(`UPDATE user SET is_del = 1, del_time = ? WHERE user_no = ?`, util.Now(), u.UserNo).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := purgeUser(rail, db, u); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sql := range []string{
+		`SELECT count(*) FROM user WHERE user_no = ?`,
+		`SELECT count(*) FROM user_key WHERE user_no = ?`,
+		`SELECT count(*) FROM site_password WHERE user_no = ?`,
+		`SELECT count(*) FROM notification WHERE user_no = ?`,
+	} {
+		if n := countRows(t, db, sql, u.UserNo); n != 0 {
+			t.Fatalf("%v, expected 0 rows, found %v", sql, n)
+		}
+	}
+
+	type accessLog struct {
+		Username  string
+		IpAddress string
+		UserAgent string
+	}
+	var logs []accessLog
+	if err := db.Raw(`SELECT username, ip_address, user_agent FROM access_log WHERE user_id = ?`, u.Id).Scan(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("access log should be kept, found %v", len(logs))
+	}
+	if l := logs[0]; l.Username != "deleted_"+u.UserNo || l.IpAddress != "" || l.UserAgent != "" {
+		t.Fatalf("access log should be anonymized, %+v", l)
+	}
+}
@@ -0,0 +1,88 @@
+package vault
+
+import (
+	"slices"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	ResEffectAllow = "ALLOW"
+	ResEffectDeny  = "DENY"
+
+	// deny rules are cached along with the allowed resources, but prefixed
+	denyResCachePrefix = "!"
+)
+
+func checkResEffect(effect string) error {
+	if effect != ResEffectAllow && effect != ResEffectDeny {
+		return miso.NewErrf("Invalid effect, should be either ALLOW or DENY")
+	}
+	return nil
+}
+
+// Code used in roleResCache for the role resource binding, deny rules are prefixed with '!'.
+func roleResCacheCode(resCode string, effect string) string {
+	if effect == ResEffectDeny {
+		return denyResCachePrefix + resCode
+	}
+	return resCode
+}
+
+// Check whether the role denies access to the resource, including the deny rules of wildcard patterns.
+func checkRoleResDenied(rail miso.Rail, get roleResGetter, roleNo string, resCode string, attrs *accessAttrs) (bool, error) {
+	if roleNo == DefaultAdminRoleNo {
+		return false, nil
+	}
+	for _, c := range append([]string{resCode}, resCodePatterns(resCode)...) {
+		ok, e := lookupRoleRes(rail, get, roleNo, roleResCacheCode(c, ResEffectDeny), attrs, true)
+		if e != nil || ok {
+			return ok, e
+		}
+	}
+	return false, nil
+}
+
+// Check whether any of the roles has access to the resource.
+//
+// Deny rules are evaluated first and override the allows, unless one of the roles is the administrator role.
+func checkRolesRes(rail miso.Rail, roleNos []string, resCode string, attrs *accessAttrs) (bool, error) {
+	get, err := prefetchRoleResCache(roleResCacheKeys(roleNos, []string{resCode}))
+	if err != nil {
+		return false, err
+	}
+	return checkRolesResWith(rail, get, roleNos, resCode, attrs)
+}
+
+func checkRolesResWith(rail miso.Rail, get roleResGetter, roleNos []string, resCode string, attrs *accessAttrs) (bool, error) {
+	if slices.Contains(roleNos, DefaultAdminRoleNo) {
+		return true, nil
+	}
+	for _, r := range roleNos {
+		denied, e := checkRoleResDenied(rail, get, r, resCode, attrs)
+		if e != nil {
+			return false, e
+		}
+		if denied {
+			rail.Infof("Access to resource '%v' is denied by role %v", resCode, r)
+			return false, nil
+		}
+	}
+	for _, r := range roleNos {
+		ok, e := checkRoleRes(rail, get, r, resCode, attrs)
+		if e != nil || ok {
+			return ok, e
+		}
+	}
+	return false, nil
+}
+
+// Check whether the resource is denied by any of the deny rules, the rules may be wildcard patterns.
+func resDenied(denies []string, resCode string) bool {
+	for _, d := range denies {
+		if resCodeMatches(d, resCode) {
+			return true
+		}
+	}
+	return false
+}
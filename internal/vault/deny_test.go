@@ -0,0 +1,31 @@
+package vault
+
+import "testing"
+
+func TestRoleResCacheCode(t *testing.T) {
+	if c := roleResCacheCode("postbox:*", ResEffectAllow); c != "postbox:*" {
+		t.Fatalf("unexpected code: %v", c)
+	}
+	if c := roleResCacheCode("postbox:*", ResEffectDeny); c != "!postbox:*" {
+		t.Fatalf("unexpected code: %v", c)
+	}
+}
+
+func TestResDenied(t *testing.T) {
+	denies := []string{"postbox:notification:*", "manage-users"}
+	if !resDenied(denies, "postbox:notification:create") || !resDenied(denies, "manage-users") {
+		t.Fatal("resource should be denied")
+	}
+	if resDenied(denies, "postbox:message:create") || resDenied(denies, "manage-resources") {
+		t.Fatal("resource should not be denied")
+	}
+}
+
+func TestCheckResEffect(t *testing.T) {
+	if checkResEffect(ResEffectAllow) != nil || checkResEffect(ResEffectDeny) != nil {
+		t.Fatal("valid effect rejected")
+	}
+	if checkResEffect("allow") == nil {
+		t.Fatal("invalid effect accepted")
+	}
+}
@@ -0,0 +1,356 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/internal/mail"
+	"gorm.io/gorm"
+)
+
+const (
+	tokenPurposeVerifyEmail   = "verify-email"
+	tokenPurposeResetPassword = "reset-password"
+
+	verifyEmailTokenExp   = 24 * time.Hour
+	resetPasswordTokenExp = 30 * time.Minute
+
+	verifyEmailLimit        = 5
+	resetPasswordLimit      = 3
+	resetPasswordLimitPerIp = 10
+)
+
+// Single purpose token for links sent by mail.
+//
+// The token is a random string saved in redis, it's not signed by the key of login tokens, and it can only be used once.
+type purposeToken struct {
+	UserNo string            `json:"userNo"`
+	Claims map[string]string `json:"claims"`
+}
+
+func purposeTokenKey(purpose string, token string) string {
+	return "user-vault:email:token:" + purpose + ":" + token
+}
+
+func savePurposeToken(purpose string, userNo string, claims map[string]string, exp time.Duration) (string, error) {
+	v, err := json.Marshal(purposeToken{UserNo: userNo, Claims: claims})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %v token, %w", purpose, err)
+	}
+	token := util.ERand(40)
+	if err := redis.GetRedis().Set(purposeTokenKey(purpose, token), string(v), exp).Err(); err != nil {
+		return "", fmt.Errorf("failed to save %v token, %w", purpose, err)
+	}
+	return token, nil
+}
+
+// Load the token without consuming it, the token is consumed using consumePurposeToken once all checks are passed.
+func loadPurposeToken(purpose string, token string) (purposeToken, error) {
+	errInvalid := miso.NewErrf("Link is invalid or has expired")
+	if token == "" {
+		return purposeToken{}, errInvalid
+	}
+	v, err := redis.GetRedis().Get(purposeTokenKey(purpose, token)).Result()
+	if err != nil {
+		if redis.IsNil(err) {
+			return purposeToken{}, errInvalid
+		}
+		return purposeToken{}, fmt.Errorf("failed to load %v token, %w", purpose, err)
+	}
+	var pt purposeToken
+	if err := json.Unmarshal([]byte(v), &pt); err != nil {
+		return purposeToken{}, fmt.Errorf("failed to unmarshal %v token, %w", purpose, err)
+	}
+	if pt.UserNo == "" {
+		return purposeToken{}, errInvalid.WithInternalMsg("Token missing userNo")
+	}
+	return pt, nil
+}
+
+func consumePurposeToken(purpose string, token string) error {
+	// only the one who deletes the key can use it
+	n, err := redis.GetRedis().Del(purposeTokenKey(purpose, token)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove %v token, %w", purpose, err)
+	}
+	if n < 1 {
+		return miso.NewErrf("Link is invalid or has expired").WithInternalMsg("Token %v is already used", purpose)
+	}
+	return nil
+}
+
+func buildLink(base string, token string) string {
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "token=" + url.QueryEscape(token)
+}
+
+func SendEmailVerification(rail miso.Rail, tx *gorm.DB, user common.User) error {
+	u, err := loadUserByUserNo(rail, tx, user.UserNo)
+	if err != nil {
+		return err
+	}
+	if u.Email == "" {
+		return miso.NewErrf("Please update your email address first")
+	}
+	if u.EmailVerified {
+		return miso.NewErrf("Email address is already verified")
+	}
+
+	n, err := incrWindowCounter("user-vault:email:verify:limit:"+u.UserNo, time.Hour)
+	if err != nil {
+		return err
+	}
+	if n > verifyEmailLimit {
+		return miso.NewErrf("Too many requests, please try again later")
+	}
+
+	tkn, err := savePurposeToken(tokenPurposeVerifyEmail, u.UserNo, map[string]string{"email": u.Email}, verifyEmailTokenExp)
+	if err != nil {
+		return err
+	}
+
+	link := buildLink(miso.GetPropStr(PropEmailVerifyLink), tkn)
+	return mail.GetMailer().Send(rail, mail.Mail{
+		To:      []string{u.Email},
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %v,\n\nPlease verify your email address by opening the following link within %v hours:\n\n%v\n\n"+
+			"If you didn't request this, please ignore this email.", u.Username, int(verifyEmailTokenExp.Hours()), link),
+	})
+}
+
+type VerifyEmailReq struct {
+	Token string `form:"token" desc:"token in the verification link"`
+}
+
+func VerifyEmail(rail miso.Rail, tx *gorm.DB, req VerifyEmailReq) error {
+	pt, err := loadPurposeToken(tokenPurposeVerifyEmail, req.Token)
+	if err != nil {
+		return err
+	}
+	userNo, email := pt.UserNo, pt.Claims["email"]
+
+	u, err := loadUserByUserNo(rail, tx, userNo)
+	if err != nil {
+		return err
+	}
+	if u.Email != email {
+		return miso.NewErrf("Email address has been changed, please request a new verification link")
+	}
+	if u.EmailVerified {
+		return nil
+	}
+
+	var taken int
+	err = tx.Raw(`SELECT count(*) FROM user WHERE email = ? AND email_verified = 1 AND user_no != ? AND is_del = 0`, email, userNo).
+		Scan(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return miso.NewErrf("Email address is already used by another user")
+	}
+
+	if err := consumePurposeToken(tokenPurposeVerifyEmail, req.Token); err != nil {
+		return err
+	}
+
+	err = tx.Exec(`UPDATE user SET email_verified = 1 WHERE user_no = ? AND email = ?`, userNo, email).Error
+	if err != nil {
+		return err
+	}
+	rail.Infof("User %v verified email %v", u.Username, email)
+
+	if err := InvalidateUserInfoCache(rail, u.Username); err != nil {
+		rail.Errorf("Failed to invalidate user info cache, username: %v, %v", u.Username, err)
+	}
+	return nil
+}
+
+type RequestPasswordResetReq struct {
+	Username      string `json:"username" valid:"notEmpty" desc:"username or verified email address"`
+	XForwardedFor string `header:"x-forwarded-for"`
+}
+
+// Send password reset link to user's verified email.
+//
+// It always succeeds regardless of whether the user exists, so that it can't be used to find out the registered users.
+func RequestPasswordReset(rail miso.Rail, tx *gorm.DB, req RequestPasswordResetReq) error {
+	remoteAddr := RemoteAddr(req.XForwardedFor)
+	if remoteAddr != "unknown" {
+		n, err := incrWindowCounter("user-vault:password:reset:limit:ip:"+remoteAddr, time.Hour)
+		if err != nil {
+			return err
+		}
+		if n > resetPasswordLimitPerIp {
+			return miso.NewErrf("Too many requests, please try again later")
+		}
+	}
+
+	u, ok, err := findUserWithVerifiedEmail(rail, tx, strings.TrimSpace(req.Username))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		rail.Infof("Password reset requested for %v (%v), but the user is not found or has no verified email", req.Username, remoteAddr)
+		return nil
+	}
+
+	n, err := incrWindowCounter("user-vault:password:reset:limit:"+u.UserNo, time.Hour)
+	if err != nil {
+		return err
+	}
+	if n > resetPasswordLimit {
+		rail.Infof("Password reset requested for %v too many times, ignored", u.Username)
+		return nil
+	}
+
+	tkn, err := savePurposeToken(tokenPurposeResetPassword, u.UserNo, map[string]string{"pwd": passwordFingerprint(u.Password)}, resetPasswordTokenExp)
+	if err != nil {
+		return err
+	}
+
+	link := buildLink(miso.GetPropStr(PropPasswordResetLink), tkn)
+	m := mail.Mail{
+		To:      []string{u.Email},
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %v,\n\nPlease reset your password by opening the following link within %v minutes:\n\n%v\n\n"+
+			"If you didn't request this, please ignore this email.", u.Username, int(resetPasswordTokenExp.Minutes()), link),
+	}
+	commonPool.Go(func() {
+		if err := mail.GetMailer().Send(rail, m); err != nil {
+			rail.Errorf("Failed to send password reset mail, username: %v, %v", u.Username, err)
+		}
+	})
+	return nil
+}
+
+// Find user by username or verified email, the user found must have a verified email and must not be disabled.
+func findUserWithVerifiedEmail(rail miso.Rail, tx *gorm.DB, name string) (User, bool, error) {
+	if name == "" {
+		return User{}, false, nil
+	}
+
+	names := []string{name, normalizeUsername(name)}
+	var user User
+	err := tx.Raw(`
+		SELECT * FROM user
+		WHERE (username IN ? OR (email = ? AND email_verified = 1)) AND is_del = 0
+		ORDER BY username IN ? DESC
+		LIMIT 1`, names, name, names).
+		Scan(&user).Error
+	if err != nil {
+		return User{}, false, err
+	}
+	if user.Id < 1 || !user.EmailVerified || user.Email == "" || user.IsDisabled == 1 {
+		return User{}, false, nil
+	}
+	return user, true, nil
+}
+
+// Fingerprint of the password, the reset link becomes invalid once the password is changed.
+func passwordFingerprint(encoded string) string {
+	return encodePassword(encoded)[:16]
+}
+
+type ResetPasswordReq struct {
+	Token       string `json:"token" valid:"notEmpty" desc:"token in the password reset link"`
+	NewPassword string `json:"newPassword" valid:"notEmpty"`
+}
+
+func ResetPassword(rail miso.Rail, tx *gorm.DB, req ResetPasswordReq) error {
+	pt, err := loadPurposeToken(tokenPurposeResetPassword, req.Token)
+	if err != nil {
+		return err
+	}
+
+	u, err := loadUserByUserNo(rail, tx, pt.UserNo)
+	if err != nil {
+		return err
+	}
+	if u.Deleted() {
+		return miso.NewErrf("Link is invalid or has expired").WithInternalMsg("User %v is deleted", u.Username)
+	}
+
+	if pt.Claims["pwd"] != passwordFingerprint(u.Password) {
+		return miso.NewErrf("Link is invalid or has expired").WithInternalMsg("Password fingerprint mismatch, user: %v", u.Username)
+	}
+
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	if err := checkNewPassword(req.NewPassword); err != nil {
+		return err
+	}
+	if u.Username == req.NewPassword {
+		return miso.NewErrf("Username and password must be different")
+	}
+	if err := consumePurposeToken(tokenPurposeResetPassword, req.Token); err != nil {
+		return err
+	}
+
+	err = tx.Exec(`UPDATE user SET password = ?, update_by = ? WHERE user_no = ? AND password = ?`,
+		encodePasswordSalt(req.NewPassword, u.Salt), u.Username, u.UserNo, u.Password).Error
+	if err != nil {
+		return miso.NewErrf("Failed to update password, please try again laster").
+			WithInternalMsg("Failed to update password, %v", err)
+	}
+	rail.Infof("User %v reset password", u.Username)
+
+	if err := InvalidateUserInfoCache(rail, u.Username); err != nil {
+		rail.Errorf("Failed to invalidate user info cache, username: %v, %v", u.Username, err)
+	}
+	// the password may be reset after the account is compromised, tokens issued before are no longer trusted
+	if err := RevokeUserTokens(rail, u.UserNo); err != nil {
+		rail.Errorf("Failed to revoke tokens of user %v, %v", u.UserNo, err)
+	}
+	clearLoginFailure(rail, u.Username)
+	return nil
+}
+
+type UpdateEmailNotificationReq struct {
+	Enabled bool `json:"enabled" desc:"whether notifications are also delivered to the verified email"`
+}
+
+func UpdateEmailNotification(rail miso.Rail, tx *gorm.DB, req UpdateEmailNotificationReq, user common.User) error {
+	u, err := loadUserByUserNo(rail, tx, user.UserNo)
+	if err != nil {
+		return err
+	}
+	if req.Enabled && !u.EmailVerified {
+		return miso.NewErrf("Please verify your email address first")
+	}
+	err = tx.Exec(`UPDATE user SET email_notification = ?, update_by = ? WHERE user_no = ?`, req.Enabled, user.Username, u.UserNo).Error
+	if err != nil {
+		return err
+	}
+	if err := InvalidateUserInfoCache(rail, u.Username); err != nil {
+		rail.Errorf("Failed to invalidate user info cache, username: %v, %v", u.Username, err)
+	}
+	return nil
+}
+
+type EmailReceiver struct {
+	UserNo string
+	Email  string
+}
+
+// Find verified emails of users who prefer receiving notifications by email.
+func FindEmailNotificationReceivers(rail miso.Rail, tx *gorm.DB, userNos []string) ([]EmailReceiver, error) {
+	var receivers []EmailReceiver
+	if len(userNos) < 1 {
+		return receivers, nil
+	}
+	err := tx.Raw(`
+		SELECT user_no, email FROM user
+		WHERE user_no IN ? AND email_notification = 1 AND email_verified = 1 AND email != '' AND is_del = 0`, userNos).
+		Scan(&receivers).Error
+	return receivers, err
+}
@@ -0,0 +1,12 @@
+package vault
+
+import "testing"
+
+func TestBuildLink(t *testing.T) {
+	if v := buildLink("http://localhost/verify", "a.b+c"); v != "http://localhost/verify?token=a.b%2Bc" {
+		t.Fatal(v)
+	}
+	if v := buildLink("http://localhost/reset?lang=en", "abc"); v != "http://localhost/reset?lang=en&token=abc" {
+		t.Fatal(v)
+	}
+}
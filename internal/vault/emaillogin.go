@@ -0,0 +1,265 @@
+package vault
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/internal/mail"
+	"gorm.io/gorm"
+)
+
+const (
+	emailLoginUrl = "/user-vault/open/api/user/login/email"
+
+	EmailLoginTypeCode = "CODE"
+	EmailLoginTypeLink = "LINK"
+
+	emailLoginCodeTtl      = 10 * time.Minute
+	emailLoginLinkTtl      = 15 * time.Minute
+	emailLoginCodeAttempts = 5
+)
+
+type SendEmailLoginReq struct {
+	Username      string `json:"username" valid:"notEmpty" desc:"username or verified email address"`
+	Type          string `json:"type" valid:"member:CODE|LINK" desc:"CODE: 6-digit code, LINK: login link"`
+	XForwardedFor string `header:"x-forwarded-for"`
+}
+
+// Send a single-use login code or login link to user's verified email.
+//
+// It always succeeds regardless of whether the user exists, so that it can't be used to find out the registered users.
+func SendEmailLogin(rail miso.Rail, tx *gorm.DB, req SendEmailLoginReq) error {
+	if !miso.GetPropBool(PropEmailLoginEnabled) {
+		return miso.NewErrf("Email login is not enabled")
+	}
+
+	remoteAddr := RemoteAddr(req.XForwardedFor)
+	if remoteAddr != "unknown" {
+		n, err := incrWindowCounter("user-vault:login:email:limit:ip:"+remoteAddr, time.Hour)
+		if err != nil {
+			return err
+		}
+		if n > int64(miso.GetPropInt(PropEmailLoginIpLimit)) {
+			return miso.NewErrf("Too many requests, please try again later")
+		}
+	}
+
+	u, ok, err := findUserWithVerifiedEmail(rail, tx, strings.TrimSpace(req.Username))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		rail.Infof("Email login requested for %v (%v), but the user is not found or has no verified email", req.Username, remoteAddr)
+		return nil
+	}
+	if roleNos, err := listUserRoleNos(rail, tx, u.UserNo); err != nil {
+		return err
+	} else if !emailLoginPermitted(u, roleNos) {
+		rail.Infof("Email login requested for %v (%v), but the user is not permitted to login by email", u.Username, remoteAddr)
+		return nil
+	}
+
+	n, err := incrWindowCounter("user-vault:login:email:limit:"+u.UserNo, time.Hour)
+	if err != nil {
+		return err
+	}
+	if n > int64(miso.GetPropInt(PropEmailLoginUserLimit)) {
+		rail.Infof("Email login requested for %v too many times, ignored", u.Username)
+		return nil
+	}
+
+	var m mail.Mail
+	if req.Type == EmailLoginTypeLink {
+		token := util.ERand(40)
+		if err := redis.GetRedis().Set(emailLoginLinkKey(token), u.UserNo, emailLoginLinkTtl).Err(); err != nil {
+			return fmt.Errorf("failed to save email login token, %w", err)
+		}
+		m = mail.Mail{
+			To:      []string{u.Email},
+			Subject: "Your login link",
+			Body: fmt.Sprintf("Hi %v,\n\nPlease login by opening the following link within %v minutes, the link can only be used once:\n\n%v\n\n"+
+				"If you didn't request this, please ignore this email.", u.Username, int(emailLoginLinkTtl.Minutes()),
+				buildLink(miso.GetPropStr(PropEmailLoginLink), token)),
+		}
+	} else {
+		code, err := genEmailLoginCode()
+		if err != nil {
+			return err
+		}
+		rc := redis.GetRedis()
+		if err := rc.Set(emailLoginCodeKey(u.UserNo), code, emailLoginCodeTtl).Err(); err != nil {
+			return fmt.Errorf("failed to save email login code, %w", err)
+		}
+		if err := rc.Del(emailLoginAttemptKey(u.UserNo)).Err(); err != nil {
+			return fmt.Errorf("failed to reset email login attempts, %w", err)
+		}
+		m = mail.Mail{
+			To:      []string{u.Email},
+			Subject: "Your login code",
+			Body: fmt.Sprintf("Hi %v,\n\nYour login code is: %v\n\nThe code is valid for %v minutes and can only be used once. "+
+				"If you didn't request this, please ignore this email.", u.Username, code, int(emailLoginCodeTtl.Minutes())),
+		}
+	}
+
+	commonPool.Go(func() {
+		if err := mail.GetMailer().Send(rail, m); err != nil {
+			rail.Errorf("Failed to send email login mail, username: %v, %v", u.Username, err)
+		}
+	})
+	return nil
+}
+
+// Email login is only for low-privilege users, administrators must login using password.
+//
+// roleNos are all roles of the user (see listUserRoleNos), the administrator role may be granted to the user or
+// the user's groups besides the primary role.
+func emailLoginPermitted(u User, roleNos []string) bool {
+	return u.RoleNo != DefaultAdminRoleNo && !slices.Contains(roleNos, DefaultAdminRoleNo) && checkUserCanLogin(u) == nil
+}
+
+func genEmailLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate email login code, %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func emailLoginCodeKey(userNo string) string {
+	return "user-vault:login:email:code:" + userNo
+}
+
+func emailLoginAttemptKey(userNo string) string {
+	return "user-vault:login:email:attempt:" + userNo
+}
+
+func emailLoginLinkKey(token string) string {
+	return "user-vault:login:email:link:" + token
+}
+
+type EmailLoginReq struct {
+	Username      string `json:"username" desc:"username or verified email address, required when code is used"`
+	Code          string `json:"code" desc:"6-digit login code"`
+	Token         string `json:"token" desc:"token in the login link"`
+	XForwardedFor string `header:"x-forwarded-for"`
+	UserAgent     string `header:"user-agent"`
+}
+
+// Exchange login code or login link token for a JWT token.
+func EmailLogin(rail miso.Rail, tx *gorm.DB, req EmailLoginReq) (string, User, string, error) {
+	if !miso.GetPropBool(PropEmailLoginEnabled) {
+		return "", User{}, "", miso.NewErrf("Email login is not enabled")
+	}
+
+	var (
+		u           User
+		loginMethod string
+		err         error
+	)
+	if req.Token != "" {
+		loginMethod = LoginMethodEmailLink
+		u, err = consumeEmailLoginLink(rail, tx, req.Token)
+	} else {
+		loginMethod = LoginMethodEmailCode
+		u, err = consumeEmailLoginCode(rail, tx, req.Username, req.Code)
+	}
+	if err != nil {
+		return "", u, loginMethod, err
+	}
+
+	if roleNos, err := listUserRoleNos(rail, tx, u.UserNo); err != nil {
+		return "", u, loginMethod, err
+	} else if !emailLoginPermitted(u, roleNos) {
+		return "", u, loginMethod, miso.NewErrf("Your are not permitted to login by email")
+	}
+
+	tkn, err := buildLoginToken(rail, tx, u, loginMethod)
+	if err != nil {
+		return "", u, loginMethod, err
+	}
+	return tkn, u, loginMethod, nil
+}
+
+func consumeEmailLoginLink(rail miso.Rail, tx *gorm.DB, token string) (User, error) {
+	errInvalid := miso.NewErrf("Link is invalid or has expired")
+
+	key := emailLoginLinkKey(token)
+	rc := redis.GetRedis()
+	userNo, err := rc.Get(key).Result()
+	if err != nil {
+		if redis.IsNil(err) {
+			return User{}, errInvalid
+		}
+		return User{}, fmt.Errorf("failed to load email login token, %w", err)
+	}
+
+	// only the one who deletes the key can use it
+	n, err := rc.Del(key).Result()
+	if err != nil {
+		return User{}, fmt.Errorf("failed to remove email login token, %w", err)
+	}
+	if n < 1 {
+		return User{}, errInvalid.WithInternalMsg("Email login token is already used")
+	}
+
+	return loadUserByUserNo(rail, tx, userNo)
+}
+
+func consumeEmailLoginCode(rail miso.Rail, tx *gorm.DB, username string, code string) (User, error) {
+	errInvalid := miso.NewErrf("Code is incorrect or has expired")
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return User{}, miso.NewErrf("Code is required")
+	}
+
+	u, ok, err := findUserWithVerifiedEmail(rail, tx, strings.TrimSpace(username))
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		return User{}, errInvalid.WithInternalMsg("User %v not found or has no verified email", username)
+	}
+
+	rc := redis.GetRedis()
+	key := emailLoginCodeKey(u.UserNo)
+	expected, err := rc.Get(key).Result()
+	if err != nil {
+		if redis.IsNil(err) {
+			return User{}, errInvalid
+		}
+		return User{}, fmt.Errorf("failed to load email login code, %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+		attempts, err := incrWindowCounter(emailLoginAttemptKey(u.UserNo), emailLoginCodeTtl)
+		if err != nil {
+			return User{}, err
+		}
+		if attempts >= emailLoginCodeAttempts {
+			rail.Infof("User %v failed to login by email code %v times, code is revoked", u.Username, attempts)
+			if err := rc.Del(key).Err(); err != nil {
+				rail.Errorf("Failed to remove email login code, %v", err)
+			}
+		}
+		return User{}, errInvalid
+	}
+
+	// only the one who deletes the key can use it
+	n, err := rc.Del(key).Result()
+	if err != nil {
+		return User{}, fmt.Errorf("failed to remove email login code, %w", err)
+	}
+	if n < 1 {
+		return User{}, errInvalid.WithInternalMsg("Email login code is already used")
+	}
+	return u, nil
+}
@@ -0,0 +1,34 @@
+package vault
+
+import (
+	"testing"
+)
+
+func TestGenEmailLoginCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		c, err := genEmailLoginCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c) != 6 {
+			t.Fatal(c)
+		}
+		for _, r := range c {
+			if r < '0' || r > '9' {
+				t.Fatal(c)
+			}
+		}
+	}
+}
+
+func TestEmailLoginPermitted(t *testing.T) {
+	if emailLoginPermitted(User{RoleNo: DefaultAdminRoleNo}, []string{DefaultAdminRoleNo}) {
+		t.Fatal("admin should not be permitted")
+	}
+	if emailLoginPermitted(User{RoleNo: "role_123"}, []string{"role_123", DefaultAdminRoleNo}) {
+		t.Fatal("user granted admin role in user_role should not be permitted")
+	}
+	if !emailLoginPermitted(User{RoleNo: "role_123"}, []string{"role_123", "role_456"}) {
+		t.Fatal("user should be permitted")
+	}
+}
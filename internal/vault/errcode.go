@@ -1,5 +1,9 @@
 package vault
 
 const (
-	ErrCodeRoleNotFound = "GA0001"
+	ErrCodeRoleNotFound       = "GA0001"
+	ErrCodeChallengeRequired  = "GA0002"
+	ErrCodeChallengeFailed    = "GA0003"
+	ErrCodeRoleHierarchyCycle = "GA0004"
+	ErrCodeOutOfAdminScope    = "GA0005"
 )
@@ -17,11 +17,12 @@ var (
 )
 
 type AccessLogEvent struct {
-	UserAgent  string
-	IpAddress  string
-	UserId     int
-	Username   string
-	Url        string
-	Success    bool
-	AccessTime util.ETime
+	UserAgent   string
+	IpAddress   string
+	UserId      int
+	Username    string
+	Url         string
+	Success     bool
+	LoginMethod string
+	AccessTime  util.ETime
 }
@@ -0,0 +1,174 @@
+package vault
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+	"gorm.io/gorm"
+)
+
+const (
+	AccessDecisionPathNotFound = "PATH_NOT_FOUND"    // path is not registered
+	AccessDecisionPublicPath   = "PUBLIC_PATH"       // path is public
+	AccessDecisionNoRole       = "NO_ROLE"           // user doesn't have any role
+	AccessDecisionNoResource   = "NO_RESOURCE"       // path doesn't have any resource bound
+	AccessDecisionAdminRole    = "ADMIN_ROLE"        // administrator role has access to everything
+	AccessDecisionGranted      = "GRANTED"           // required resources are granted
+	AccessDecisionDenied       = "DENIED"            // access is rejected by deny rule
+	AccessDecisionNoGrant      = "NO_MATCHING_GRANT" // required resources are not granted
+)
+
+type ExplainAccessReq struct {
+	RoleNo      string `json:"roleNo" desc:"role to explain, either roleNo or username is required"`
+	Username    string `json:"username" desc:"user to explain, all roles of the user are checked"`
+	Url         string `json:"url" validation:"notEmpty"`
+	Method      string `json:"method" validation:"notEmpty"`
+	ClientIp    string `json:"clientIp" desc:"optional, client ip address, used to evaluate grant conditions"`
+	LoginMethod string `json:"loginMethod" desc:"optional, login method, used to evaluate grant conditions"`
+}
+
+type ExplainAccessResp struct {
+	Valid        bool             `json:"valid" desc:"whether access is granted"`
+	Decision     string           `json:"decision" desc:"PATH_NOT_FOUND, PUBLIC_PATH, NO_ROLE, NO_RESOURCE, ADMIN_ROLE, GRANTED, DENIED, NO_MATCHING_GRANT"`
+	Url          string           `json:"url" desc:"normalized url"`
+	Method       string           `json:"method" desc:"normalized http method"`
+	Path         *ExplainedPath   `json:"path" desc:"matched path, null if the path is not found"`
+	RequiredRes  []string         `json:"requiredRes" desc:"resources required by the path"`
+	ResMode      string           `json:"resMode" desc:"ANY or ALL of the required resources"`
+	RoleNos      []string         `json:"roleNos" desc:"roles that are checked"`
+	Checks       []ExplainedCheck `json:"checks" desc:"grants of the roles that are checked in order"`
+	DecidingRule *ExplainedCheck  `json:"decidingRule" desc:"grant that decides the result, null if no grant matches"`
+}
+
+type ExplainedPath struct {
+	PathNo   string `json:"pathNo"`
+	Pgroup   string `json:"pgroup"`
+	Url      string `json:"url"`
+	Method   string `json:"method"`
+	Ptype    string `json:"ptype"`
+	Template bool   `json:"template" desc:"whether the url is matched by path template"`
+}
+
+type ExplainedCheck struct {
+	RoleNo    string `json:"roleNo"`
+	Rule      string `json:"rule" desc:"resource code or wildcard pattern that is looked up"`
+	Effect    string `json:"effect" desc:"ALLOW or DENY"`
+	Found     bool   `json:"found" desc:"whether the role (or its ancestors) has the grant"`
+	Condition string `json:"condition" desc:"condition of the grant, empty if the grant is unconditional"`
+	Satisfied bool   `json:"satisfied" desc:"whether the grant is found and its condition is satisfied"`
+}
+
+// Explain the access decision of the role or the user to the path.
+//
+// It follows the same steps as TestResourceAccess, but records each step instead of only the result.
+func ExplainAccess(rail miso.Rail, db *gorm.DB, req ExplainAccessReq) (ExplainAccessResp, error) {
+	req.Username = strings.TrimSpace(req.Username)
+	if req.RoleNo == "" && req.Username == "" {
+		return ExplainAccessResp{}, miso.NewErrf("Either roleNo or username is required")
+	}
+
+	roleNos := []string{req.RoleNo}
+	if req.Username != "" {
+		u, err := loadUser(rail, db, req.Username)
+		if err != nil {
+			return ExplainAccessResp{}, err
+		}
+		userRoleNos, err := listUserRoleNos(rail, db, u.UserNo)
+		if err != nil {
+			return ExplainAccessResp{}, err
+		}
+		roleNos = append(roleNos, userRoleNos...)
+	}
+
+	resp := ExplainAccessResp{
+		Url:         preprocessUrl(req.Url),
+		Method:      strings.ToUpper(strings.TrimSpace(req.Method)),
+		RoleNos:     distinctRoleNos(roleNos),
+		RequiredRes: []string{},
+		Checks:      []ExplainedCheck{},
+	}
+
+	cur, err := lookupUrlRes(rail, resp.Url, resp.Method)
+	if err != nil {
+		if !miso.IsNoneErr(err) {
+			return resp, err
+		}
+		resp.Decision = AccessDecisionPathNotFound
+		return resp, nil
+	}
+	resp.Path = &ExplainedPath{
+		PathNo:   cur.PathNo,
+		Pgroup:   cur.Pgroup,
+		Url:      cur.Url,
+		Method:   cur.Method,
+		Ptype:    cur.Ptype,
+		Template: cur.Url != resp.Url,
+	}
+	resp.RequiredRes = append(resp.RequiredRes, cur.ResCodes...)
+	resp.ResMode = cur.ResMode
+
+	switch {
+	case cur.Ptype == PathTypePublic:
+		resp.Valid, resp.Decision = true, AccessDecisionPublicPath
+		return resp, nil
+	case len(resp.RoleNos) < 1:
+		resp.Decision = AccessDecisionNoRole
+		return resp, nil
+	case len(cur.ResCodes) < 1:
+		resp.Decision = AccessDecisionNoResource
+		return resp, nil
+	case slices.Contains(resp.RoleNos, DefaultAdminRoleNo):
+		resp.Valid, resp.Decision = true, AccessDecisionAdminRole
+		return resp, nil
+	}
+
+	attrs := &accessAttrs{ClientIp: req.ClientIp, LoginMethod: req.LoginMethod, Username: req.Username, Now: time.Now()}
+	get := func(rail miso.Rail, key string) (string, bool, error) {
+		v, ok, err := getRoleResCache(rail, key)
+		if err != nil {
+			return v, ok, err
+		}
+		resp.Checks = append(resp.Checks, explainRoleResCheck(rail, key, v, ok, attrs))
+		return v, ok, nil
+	}
+	resp.Valid, err = checkPathRes(rail, get, cur, resp.RoleNos, attrs)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Decision = AccessDecisionNoGrant
+	if resp.Valid {
+		resp.Decision = AccessDecisionGranted
+	}
+	// the last satisfied allow decides when access is granted, while the satisfied deny decides otherwise
+	for i := len(resp.Checks) - 1; i >= 0; i-- {
+		c := resp.Checks[i]
+		if !c.Satisfied || resp.Valid != (c.Effect == ResEffectAllow) {
+			continue
+		}
+		if c.Effect == ResEffectDeny {
+			resp.Decision = AccessDecisionDenied
+		}
+		resp.DecidingRule = &c
+		break
+	}
+	return resp, nil
+}
+
+// Explain the lookup of roleResCache, key is built by roleResCacheKey.
+func explainRoleResCheck(rail miso.Rail, key string, cached string, found bool, attrs *accessAttrs) ExplainedCheck {
+	roleNo, code, _ := strings.Cut(strings.TrimPrefix(key, "role:"), ":res:")
+	c := ExplainedCheck{RoleNo: roleNo, Rule: code, Effect: ResEffectAllow, Found: found}
+	if rule, ok := strings.CutPrefix(code, denyResCachePrefix); ok {
+		c.Rule, c.Effect = rule, ResEffectDeny
+	}
+	if found {
+		if cached != unconditionalRoleRes {
+			c.Condition = cached
+		}
+		c.Satisfied = evalRoleResCond(rail, cached, attrs, c.Effect == ResEffectDeny)
+	}
+	return c
+}
@@ -0,0 +1,26 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestExplainRoleResCheck(t *testing.T) {
+	rail := miso.EmptyRail()
+
+	c := explainRoleResCheck(rail, roleResCacheKey("role_1", roleResCacheCode("postbox:*", ResEffectDeny)), unconditionalRoleRes, true, nil)
+	if c.RoleNo != "role_1" || c.Rule != "postbox:*" || c.Effect != ResEffectDeny || !c.Satisfied || c.Condition != "" {
+		t.Fatal(c)
+	}
+
+	c = explainRoleResCheck(rail, roleResCacheKey("role_1", "postbox:query"), "", false, nil)
+	if c.Rule != "postbox:query" || c.Effect != ResEffectAllow || c.Found || c.Satisfied {
+		t.Fatal(c)
+	}
+
+	c = explainRoleResCheck(rail, roleResCacheKey("role_1", "postbox:query"), `ip == "127.0.0.1"`, true, &accessAttrs{ClientIp: "10.0.0.1"})
+	if c.Condition == "" || c.Satisfied {
+		t.Fatal(c)
+	}
+}
@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+// Archive of user's personal data.
+type UserDataArchive struct {
+	ExportTime      util.ETime               `json:"exportTime"`
+	Profile         ExportedProfile          `json:"profile"`
+	UserKeys        []ExportedUserKey        `json:"userKeys" desc:"metadata of user keys, secret keys are not included"`
+	SitePasswords   []ExportedSitePassword   `json:"sitePasswords" desc:"metadata of site passwords, passwords are not included"`
+	UsernameHistory []ExportedUsernameChange `json:"usernameHistory"`
+	AccessHistory   []ExportedAccessLog      `json:"accessHistory"`
+	Notifications   []ExportedNotification   `json:"notifications"`
+}
+
+type ExportedProfile struct {
+	UserNo            string            `json:"userNo"`
+	Username          string            `json:"username"`
+	RoleNo            string            `json:"roleNo"`
+	RoleName          string            `json:"roleName"`
+	Email             string            `json:"email"`
+	EmailVerified     bool              `json:"emailVerified"`
+	EmailNotification bool              `json:"emailNotification"`
+	DisplayName       string            `json:"displayName"`
+	Phone             string            `json:"phone"`
+	Attributes        map[string]string `json:"attributes"`
+	ReviewStatus      string            `json:"reviewStatus"`
+	CreateTime        util.ETime        `json:"createTime"`
+	UpdateTime        util.ETime        `json:"updateTime"`
+}
+
+type ExportedUserKey struct {
+	Name           string     `json:"name"`
+	ExpirationTime util.ETime `json:"expirationTime"`
+	CreateTime     util.ETime `json:"createTime"`
+}
+
+type ExportedSitePassword struct {
+	Site       string     `json:"site"`
+	Alias      string     `json:"alias"`
+	Username   string     `json:"username"`
+	CreateTime util.ETime `json:"createTime"`
+}
+
+type ExportedUsernameChange struct {
+	Username    string     `json:"username"`
+	NewUsername string     `json:"newUsername"`
+	CreateTime  util.ETime `json:"createTime"`
+}
+
+type ExportedAccessLog struct {
+	AccessTime  util.ETime `json:"accessTime"`
+	IpAddress   string     `json:"ipAddress"`
+	UserAgent   string     `json:"userAgent"`
+	Url         string     `json:"url"`
+	LoginMethod string     `json:"loginMethod"`
+	Success     bool       `json:"success"`
+}
+
+type ExportedNotification struct {
+	NotifiNo   string     `json:"notifiNo"`
+	Title      string     `json:"title"`
+	Message    string     `json:"message"`
+	Status     string     `json:"status"`
+	CreateTime util.ETime `json:"createTime"`
+}
+
+func ExportUserData(rail miso.Rail, tx *gorm.DB, user common.User) (UserDataArchive, error) {
+	u, err := loadUserByUserNo(rail, tx, user.UserNo)
+	if err != nil {
+		return UserDataArchive{}, err
+	}
+
+	a := UserDataArchive{
+		ExportTime: util.Now(),
+		Profile: ExportedProfile{
+			UserNo:            u.UserNo,
+			Username:          u.Username,
+			RoleNo:            u.RoleNo,
+			RoleName:          u.RoleName,
+			Email:             u.Email,
+			EmailVerified:     u.EmailVerified,
+			EmailNotification: u.EmailNotification,
+			DisplayName:       u.DisplayName,
+			Phone:             u.Phone,
+			Attributes:        u.Attributes,
+			ReviewStatus:      u.ReviewStatus,
+			CreateTime:        u.CreateTime,
+			UpdateTime:        u.UpdateTime,
+		},
+		UserKeys:        []ExportedUserKey{},
+		SitePasswords:   []ExportedSitePassword{},
+		UsernameHistory: []ExportedUsernameChange{},
+		AccessHistory:   []ExportedAccessLog{},
+		Notifications:   []ExportedNotification{},
+	}
+
+	queries := []struct {
+		dest any
+		sql  string
+		arg  any
+	}{
+		{&a.UserKeys, `SELECT name, expiration_time, create_time FROM user_key WHERE user_no = ? AND is_del = 0 ORDER BY id`, u.UserNo},
+		{&a.SitePasswords, `SELECT site, alias, username, create_time FROM site_password WHERE user_no = ? AND is_del = 0 ORDER BY id`, u.UserNo},
+		{&a.UsernameHistory, `SELECT username, new_username, create_time FROM username_history WHERE user_no = ? ORDER BY id`, u.UserNo},
+		{&a.AccessHistory, `SELECT access_time, ip_address, user_agent, url, login_method, success FROM access_log WHERE user_id = ? ORDER BY id`, u.Id},
+		{&a.Notifications, `SELECT notifi_no, title, message, status, create_time FROM notification WHERE user_no = ? ORDER BY id`, u.UserNo},
+	}
+	for _, q := range queries {
+		if err := tx.Raw(q.sql, q.arg).Scan(q.dest).Error; err != nil {
+			return UserDataArchive{}, err
+		}
+	}
+	return a, nil
+}
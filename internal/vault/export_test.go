@@ -0,0 +1,31 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestExportUserData(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+	u := insertTestUser(t, db)
+	insertTestUserData(t, db, u)
+
+	a, err := ExportUserData(rail, db, common.User{UserNo: u.UserNo, Username: u.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Profile.UserNo != u.UserNo || a.Profile.Username != u.Username {
+		t.Fatalf("%+v", a.Profile)
+	}
+	if len(a.UserKeys) != 1 || len(a.SitePasswords) != 1 || len(a.Notifications) != 1 || len(a.AccessHistory) != 1 {
+		t.Fatalf("%+v", a)
+	}
+	if a.UsernameHistory == nil || len(a.UsernameHistory) != 0 {
+		t.Fatalf("username history should be empty, %+v", a.UsernameHistory)
+	}
+}
@@ -0,0 +1,185 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+)
+
+// SQL condition of grants that are currently valid, it takes validGrantArgs() as args.
+func validGrantCond(alias string) string {
+	return fmt.Sprintf("(%[1]s.valid_from IS NULL OR %[1]s.valid_from <= ?) AND (%[1]s.valid_until IS NULL OR %[1]s.valid_until > ?)", alias)
+}
+
+func validGrantArgs() []any {
+	now := util.Now()
+	return []any{now, now}
+}
+
+// Check validity period of time-bound grant, both validFrom and validUntil are optional.
+func checkGrantValidity(validFrom *util.ETime, validUntil *util.ETime, now util.ETime) error {
+	if validUntil == nil {
+		return nil
+	}
+	if !validUntil.After(now) {
+		return miso.NewErrf("Grant must be valid until a future time")
+	}
+	if validFrom != nil && !validUntil.After(*validFrom) {
+		return miso.NewErrf("Grant must be valid until a time after it becomes valid")
+	}
+	return nil
+}
+
+type roleResGrant struct {
+	Id      int
+	RoleNo  string
+	ResCode string
+}
+
+type expiredUserRole struct {
+	Id     int
+	UserNo string
+	RoleNo string
+}
+
+// Remove expired grants and refresh cache for grants that become valid.
+func CleanupExpiredGrants(rail miso.Rail) error {
+	db := mysql.GetMySQL()
+	now := util.Now()
+
+	// role resources that expired, the role and its descendants may lose access to the resource
+	var expiredRes []roleResGrant
+	err := db.Raw(`SELECT id, role_no, res_code FROM role_resource WHERE valid_until <= ?`, now).Scan(&expiredRes).Error
+	if err != nil {
+		return fmt.Errorf("failed to list expired role resources, %w", err)
+	}
+	if len(expiredRes) > 0 {
+		ids := make([]int, 0, len(expiredRes))
+		for _, rr := range expiredRes {
+			ids = append(ids, rr.Id)
+		}
+		if err := db.Exec(`DELETE FROM role_resource WHERE id IN ?`, ids).Error; err != nil {
+			return err
+		}
+		for _, rr := range expiredRes {
+			rail.Infof("Expired resource %v of role %v removed", rr.ResCode, rr.RoleNo)
+		}
+	}
+
+	// role resources that become valid since the last run, the cache is also reloaded by LoadRoleResCache regularly
+	var activatedRes []roleResGrant
+	err = db.Raw(`SELECT id, role_no, res_code FROM role_resource WHERE valid_from > ? AND valid_from <= ?`,
+		now.Add(-2*time.Minute), now).
+		Scan(&activatedRes).Error
+	if err != nil {
+		return fmt.Errorf("failed to list activated role resources, %w", err)
+	}
+
+	// only the roles (and their descendants) whose grants are removed or activated are reloaded
+	if err := reloadRoleResCacheOfGrants(rail, append(expiredRes, activatedRes...)); err != nil {
+		return err
+	}
+
+	// user roles that expired, tokens carry the role, so they are revoked
+	var expiredRoles []expiredUserRole
+	err = db.Raw(`SELECT id, user_no, role_no FROM user_role WHERE valid_until <= ?`, now).Scan(&expiredRoles).Error
+	if err != nil {
+		return fmt.Errorf("failed to list expired user roles, %w", err)
+	}
+	for _, ur := range expiredRoles {
+		if err := db.Exec(`DELETE FROM user_role WHERE id = ?`, ur.Id).Error; err != nil {
+			return err
+		}
+		rail.Infof("Expired role %v of user %v removed", ur.RoleNo, ur.UserNo)
+		if err := RevokeUserTokens(rail, ur.UserNo); err != nil {
+			rail.Errorf("Failed to revoke tokens of user %v, %v", ur.UserNo, err)
+		}
+	}
+	return nil
+}
+
+// Notify grantees of time-bound grants that are about to expire.
+func NotifyExpiringGrants(rail miso.Rail) error {
+	hours := miso.GetPropInt(PropGrantExpiryNotifyHours)
+	if hours < 1 {
+		return nil
+	}
+	db := mysql.GetMySQL()
+	now := util.Now()
+	deadline := now.Add(time.Duration(hours) * time.Hour)
+
+	type expiringUserRole struct {
+		Id         int
+		UserNo     string
+		RoleNo     string
+		RoleName   string
+		ValidUntil util.ETime
+	}
+	var roles []expiringUserRole
+	err := db.Raw(`SELECT ur.id, ur.user_no, ur.role_no, r.name role_name, ur.valid_until FROM user_role ur
+		LEFT JOIN role r ON ur.role_no = r.role_no
+		WHERE ur.valid_until > ? AND ur.valid_until <= ? AND ur.expiry_notified = 0`, now, deadline).
+		Scan(&roles).Error
+	if err != nil {
+		return fmt.Errorf("failed to list expiring user roles, %w", err)
+	}
+	for _, ur := range roles {
+		sendGrantExpiryNotification(rail, []string{ur.UserNo}, "Your role is about to expire",
+			fmt.Sprintf("Role '%v' granted to you expires at %v.", ur.RoleName, ur.ValidUntil.FormatClassic()))
+		if err := db.Exec(`UPDATE user_role SET expiry_notified = 1 WHERE id = ?`, ur.Id).Error; err != nil {
+			return err
+		}
+	}
+
+	type expiringRoleRes struct {
+		Id         int
+		RoleNo     string
+		RoleName   string
+		ResCode    string
+		ResName    string
+		ValidUntil util.ETime
+	}
+	var res []expiringRoleRes
+	err = db.Raw(`SELECT rr.id, rr.role_no, r.name role_name, rr.res_code, re.name res_name, rr.valid_until FROM role_resource rr
+		LEFT JOIN role r ON rr.role_no = r.role_no
+		LEFT JOIN resource re ON rr.res_code = re.code
+		WHERE rr.valid_until > ? AND rr.valid_until <= ? AND rr.expiry_notified = 0 AND rr.effect = ?`, now, deadline, ResEffectAllow).
+		Scan(&res).Error
+	if err != nil {
+		return fmt.Errorf("failed to list expiring role resources, %w", err)
+	}
+	for _, rr := range res {
+		users, err := ItnFindUsersWithRole(rail, db, api.FetchUsersWithRoleReq{RoleNo: rr.RoleNo})
+		if err != nil {
+			return err
+		}
+		userNos := make([]string, 0, len(users))
+		for _, u := range users {
+			userNos = append(userNos, u.UserNo)
+		}
+		sendGrantExpiryNotification(rail, userNos, "Your access is about to expire",
+			fmt.Sprintf("Resource '%v' granted to role '%v' expires at %v.", rr.ResName, rr.RoleName, rr.ValidUntil.FormatClassic()))
+		if err := db.Exec(`UPDATE role_resource SET expiry_notified = 1 WHERE id = ?`, rr.Id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendGrantExpiryNotification(rail miso.Rail, userNos []string, title string, msg string) {
+	if len(userNos) < 1 {
+		return
+	}
+	err := api.CreateNotifiPipeline.Send(rail, api.CreateNotifiEvent{
+		Title:           title,
+		Message:         msg,
+		ReceiverUserNos: userNos,
+	})
+	if err != nil {
+		rail.Errorf("failed to create notification for grant expiry, userNos: %v, %v", userNos, err)
+	}
+}
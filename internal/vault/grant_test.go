@@ -0,0 +1,45 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/util"
+)
+
+func TestCheckGrantValidity(t *testing.T) {
+	now := util.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	farFuture := now.Add(2 * time.Hour)
+
+	if err := checkGrantValidity(nil, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGrantValidity(&future, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGrantValidity(nil, &future, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGrantValidity(&future, &farFuture, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGrantValidity(nil, &past, now); err == nil {
+		t.Fatal("grant expired already should be rejected")
+	}
+	if err := checkGrantValidity(&farFuture, &future, now); err == nil {
+		t.Fatal("grant that expires before it becomes valid should be rejected")
+	}
+}
+
+func TestValidGrantCond(t *testing.T) {
+	c := validGrantCond("rr")
+	if strings.Count(c, "?") != len(validGrantArgs()) {
+		t.Fatal(c)
+	}
+	if !strings.Contains(c, "rr.valid_from") || !strings.Contains(c, "rr.valid_until") {
+		t.Fatal(c)
+	}
+}
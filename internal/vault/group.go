@@ -0,0 +1,381 @@
+package vault
+
+import (
+	"slices"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+type AddGroupReq struct {
+	Name string `json:"name" valid:"notEmpty,maxLen:64"`
+	Desc string `json:"desc" valid:"maxLen:255"`
+}
+
+type AddGroupRes struct {
+	GroupNo string `json:"groupNo"`
+}
+
+type UpdateGroupReq struct {
+	GroupNo string `json:"groupNo" valid:"notEmpty"`
+	Name    string `json:"name" valid:"notEmpty,maxLen:64"`
+	Desc    string `json:"desc" valid:"maxLen:255"`
+}
+
+type DeleteGroupReq struct {
+	GroupNo string `json:"groupNo" valid:"notEmpty"`
+}
+
+type ListGroupsReq struct {
+	Name   string      `json:"name"`
+	Paging miso.Paging `json:"paging"`
+}
+
+type ListedGroup struct {
+	Id         int        `json:"id"`
+	GroupNo    string     `json:"groupNo"`
+	Name       string     `json:"name"`
+	Desc       string     `json:"desc"`
+	CreateTime util.ETime `json:"createTime"`
+	CreateBy   string     `json:"createBy"`
+	UpdateTime util.ETime `json:"updateTime"`
+	UpdateBy   string     `json:"updateBy"`
+}
+
+type AddGroupMembersReq struct {
+	GroupNo string   `json:"groupNo" valid:"notEmpty"`
+	UserNos []string `json:"userNos"`
+}
+
+type RemoveGroupMemberReq struct {
+	GroupNo string `json:"groupNo" valid:"notEmpty"`
+	UserNo  string `json:"userNo" valid:"notEmpty"`
+}
+
+type ListGroupMembersReq struct {
+	GroupNo string      `json:"groupNo" valid:"notEmpty"`
+	Paging  miso.Paging `json:"paging"`
+}
+
+type ListedGroupMember struct {
+	UserNo     string     `json:"userNo"`
+	Username   string     `json:"username"`
+	CreateTime util.ETime `json:"createTime"`
+	CreateBy   string     `json:"createBy"`
+}
+
+type GrantGroupRoleReq struct {
+	GroupNo string `json:"groupNo" valid:"notEmpty"`
+	RoleNo  string `json:"roleNo" valid:"notEmpty"`
+}
+
+type RevokeGroupRoleReq struct {
+	GroupNo string `json:"groupNo" valid:"notEmpty"`
+	RoleNo  string `json:"roleNo" valid:"notEmpty"`
+}
+
+type ListGroupRolesReq struct {
+	GroupNo string `json:"groupNo" valid:"notEmpty"`
+}
+
+type ListedGroupRole struct {
+	RoleNo     string     `json:"roleNo"`
+	RoleName   string     `json:"roleName"`
+	CreateTime util.ETime `json:"createTime"`
+	CreateBy   string     `json:"createBy"`
+}
+
+func AddGroup(rail miso.Rail, tx *gorm.DB, req AddGroupReq, operator common.User) (AddGroupRes, error) {
+	groupNo := util.GenIdP("grp_")
+	err := redis.RLockExec(rail, "user-vault:group:add:"+req.Name, func() error {
+		var id int
+		if err := tx.Raw(`SELECT id FROM user_group WHERE name = ?`, req.Name).Scan(&id).Error; err != nil {
+			return err
+		}
+		if id > 0 {
+			return miso.NewErrf("Group '%v' already exists", req.Name)
+		}
+		return tx.Exec(`INSERT INTO user_group (group_no, name, description, create_by, update_by) VALUES (?, ?, ?, ?, ?)`,
+			groupNo, req.Name, req.Desc, operator.Username, operator.Username).Error
+	})
+	if err != nil {
+		return AddGroupRes{}, err
+	}
+	rail.Infof("Group %v (%v) added by %v", req.Name, groupNo, operator.Username)
+	return AddGroupRes{GroupNo: groupNo}, nil
+}
+
+func UpdateGroup(rail miso.Rail, tx *gorm.DB, req UpdateGroupReq, operator common.User) error {
+	return lockGroup(rail, req.GroupNo, func() error {
+		var id int
+		if err := tx.Raw(`SELECT id FROM user_group WHERE name = ? AND group_no != ?`, req.Name, req.GroupNo).Scan(&id).Error; err != nil {
+			return err
+		}
+		if id > 0 {
+			return miso.NewErrf("Group '%v' already exists", req.Name)
+		}
+		t := tx.Exec(`UPDATE user_group SET name = ?, description = ?, update_by = ? WHERE group_no = ?`,
+			req.Name, req.Desc, operator.Username, req.GroupNo)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Group not found")
+		}
+		return nil
+	})
+}
+
+// Delete group, its members lose the roles granted to the group.
+func DeleteGroup(rail miso.Rail, db *gorm.DB, req DeleteGroupReq, operator common.User) error {
+	return lockGroup(rail, req.GroupNo, func() error {
+		if err := checkGroupRolesAssignable(rail, db, operator, req.GroupNo); err != nil {
+			return err
+		}
+		members, err := ListGroupMemberUserNos(rail, db, req.GroupNo)
+		if err != nil {
+			return err
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			t := tx.Exec(`DELETE FROM user_group WHERE group_no = ?`, req.GroupNo)
+			if t.Error != nil {
+				return t.Error
+			}
+			if t.RowsAffected < 1 {
+				return miso.NewErrf("Group not found")
+			}
+			if err := tx.Exec(`DELETE FROM user_group_member WHERE group_no = ?`, req.GroupNo).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`DELETE FROM user_group_role WHERE group_no = ?`, req.GroupNo).Error
+		})
+		if err != nil {
+			return err
+		}
+		rail.Infof("Group %v deleted by %v", req.GroupNo, operator.Username)
+		revokeTokensOfUsers(rail, members)
+		return nil
+	})
+}
+
+func ListGroups(rail miso.Rail, tx *gorm.DB, req ListGroupsReq) (miso.PageRes[ListedGroup], error) {
+	return mysql.NewPageQuery[ListedGroup]().
+		WithPage(req.Paging).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id, group_no, name, description `desc`, create_time, create_by, update_time, update_by").
+				Order("id DESC")
+		}).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			tx = tx.Table("user_group")
+			if req.Name != "" {
+				tx = tx.Where("name LIKE ?", "%"+req.Name+"%")
+			}
+			return tx
+		}).
+		Exec(rail, tx)
+}
+
+// Add members to group, the members inherit roles granted to the group, so the operator must be able to assign all
+// of them, and the operator cannot add themselves.
+func AddGroupMembers(rail miso.Rail, tx *gorm.DB, req AddGroupMembersReq, operator common.User) error {
+	userNos := util.Distinct(req.UserNos)
+	if len(userNos) < 1 {
+		return miso.NewErrf("Please specify users to add")
+	}
+	if slices.Contains(userNos, operator.UserNo) {
+		return miso.NewErrf("You cannot add yourself to groups").WithCode(ErrCodeOutOfAdminScope)
+	}
+	return lockGroup(rail, req.GroupNo, func() error {
+		if err := checkGroupExists(tx, req.GroupNo); err != nil {
+			return err
+		}
+		if err := checkGroupRolesAssignable(rail, tx, operator, req.GroupNo); err != nil {
+			return err
+		}
+
+		var found []string
+		if err := tx.Raw(`SELECT user_no FROM user WHERE user_no IN ? AND is_del = 0`, userNos).Scan(&found).Error; err != nil {
+			return err
+		}
+		if len(found) < len(userNos) {
+			return miso.NewErrf("User not found")
+		}
+
+		for _, un := range userNos {
+			err := tx.Exec(`INSERT IGNORE INTO user_group_member (group_no, user_no, create_by) VALUES (?, ?, ?)`,
+				req.GroupNo, un, operator.Username).Error
+			if err != nil {
+				return err
+			}
+		}
+		rail.Infof("Users %v added to group %v by %v", userNos, req.GroupNo, operator.Username)
+		return nil
+	})
+}
+
+// Remove member from group, tokens of the user are revoked since they carry the roles granted to the group.
+func RemoveGroupMember(rail miso.Rail, tx *gorm.DB, req RemoveGroupMemberReq, operator common.User) error {
+	return lockGroup(rail, req.GroupNo, func() error {
+		if err := checkGroupRolesAssignable(rail, tx, operator, req.GroupNo); err != nil {
+			return err
+		}
+		t := tx.Exec(`DELETE FROM user_group_member WHERE group_no = ? AND user_no = ?`, req.GroupNo, req.UserNo)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("User is not a member of the group")
+		}
+		rail.Infof("User %v removed from group %v by %v", req.UserNo, req.GroupNo, operator.Username)
+		revokeTokensOfUsers(rail, []string{req.UserNo})
+		return nil
+	})
+}
+
+func ListGroupMembers(rail miso.Rail, tx *gorm.DB, req ListGroupMembersReq) (miso.PageRes[ListedGroupMember], error) {
+	return mysql.NewPageQuery[ListedGroupMember]().
+		WithPage(req.Paging).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("m.user_no, u.username, m.create_time, m.create_by").
+				Order("m.id DESC")
+		}).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("user_group_member m").
+				Joins("LEFT JOIN user u ON m.user_no = u.user_no").
+				Where("m.group_no = ?", req.GroupNo)
+		}).
+		Exec(rail, tx)
+}
+
+// Grant role to group, operators cannot grant roles to the groups they are members of.
+func GrantGroupRole(rail miso.Rail, tx *gorm.DB, req GrantGroupRoleReq, operator common.User) error {
+	if _, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.RoleNo}); err != nil {
+		return err
+	}
+	if err := checkRoleAssignable(rail, tx, operator, req.RoleNo); err != nil {
+		return err
+	}
+	return lockGroup(rail, req.GroupNo, func() error {
+		if err := checkGroupExists(tx, req.GroupNo); err != nil {
+			return err
+		}
+		if err := checkNotGroupMember(rail, tx, operator, req.GroupNo); err != nil {
+			return err
+		}
+		t := tx.Exec(`INSERT IGNORE INTO user_group_role (group_no, role_no, create_by) VALUES (?, ?, ?)`,
+			req.GroupNo, req.RoleNo, operator.Username)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Group already has the role")
+		}
+		rail.Infof("Role %v granted to group %v by %v", req.RoleNo, req.GroupNo, operator.Username)
+		return nil
+	})
+}
+
+// Revoke role from group, tokens of the members are revoked since they carry the role.
+func RevokeGroupRole(rail miso.Rail, tx *gorm.DB, req RevokeGroupRoleReq, operator common.User) error {
+	if err := checkRoleAssignable(rail, tx, operator, req.RoleNo); err != nil {
+		return err
+	}
+	return lockGroup(rail, req.GroupNo, func() error {
+		t := tx.Exec(`DELETE FROM user_group_role WHERE group_no = ? AND role_no = ?`, req.GroupNo, req.RoleNo)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("Group doesn't have the role")
+		}
+		rail.Infof("Role %v revoked from group %v by %v", req.RoleNo, req.GroupNo, operator.Username)
+
+		members, err := ListGroupMemberUserNos(rail, tx, req.GroupNo)
+		if err != nil {
+			return err
+		}
+		revokeTokensOfUsers(rail, members)
+		return nil
+	})
+}
+
+func ListGroupRoles(rail miso.Rail, tx *gorm.DB, req ListGroupRolesReq) ([]ListedGroupRole, error) {
+	var roles []ListedGroupRole
+	err := tx.Raw(`SELECT gr.role_no, r.name role_name, gr.create_time, gr.create_by FROM user_group_role gr
+		LEFT JOIN role r ON gr.role_no = r.role_no
+		WHERE gr.group_no = ?`, req.GroupNo).
+		Scan(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []ListedGroupRole{}
+	}
+	return roles, nil
+}
+
+// List user_no of members of the group, deleted users are excluded.
+func ListGroupMemberUserNos(rail miso.Rail, tx *gorm.DB, groupNo string) ([]string, error) {
+	var userNos []string
+	err := tx.Raw(`SELECT m.user_no FROM user_group_member m
+		JOIN user u ON m.user_no = u.user_no
+		WHERE m.group_no = ? AND u.is_del = 0`, groupNo).
+		Scan(&userNos).Error
+	return userNos, err
+}
+
+// Check whether the operator can assign all the roles granted to the group.
+func checkGroupRolesAssignable(rail miso.Rail, tx *gorm.DB, operator common.User, groupNo string) error {
+	var roleNos []string
+	if err := tx.Raw(`SELECT role_no FROM user_group_role WHERE group_no = ?`, groupNo).Scan(&roleNos).Error; err != nil {
+		return err
+	}
+	for _, r := range roleNos {
+		if err := checkRoleAssignable(rail, tx, operator, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkNotGroupMember(rail miso.Rail, tx *gorm.DB, operator common.User, groupNo string) error {
+	var id int
+	err := tx.Raw(`SELECT id FROM user_group_member WHERE group_no = ? AND user_no = ?`, groupNo, operator.UserNo).Scan(&id).Error
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		return miso.NewErrf("You cannot grant roles to your own groups").
+			WithCode(ErrCodeOutOfAdminScope).
+			WithInternalMsg("Operator %v is a member of group %v", operator.Username, groupNo)
+	}
+	return nil
+}
+
+func checkGroupExists(tx *gorm.DB, groupNo string) error {
+	var id int
+	if err := tx.Raw(`SELECT id FROM user_group WHERE group_no = ?`, groupNo).Scan(&id).Error; err != nil {
+		return err
+	}
+	if id < 1 {
+		return miso.NewErrf("Group not found")
+	}
+	return nil
+}
+
+func revokeTokensOfUsers(rail miso.Rail, userNos []string) {
+	for _, un := range userNos {
+		if err := RevokeUserTokens(rail, un); err != nil {
+			rail.Errorf("Failed to revoke tokens of user %v, %v", un, err)
+		}
+	}
+}
+
+func lockGroup(rail miso.Rail, groupNo string, runnable redis.Runnable) error {
+	return redis.RLockExec(rail, "user-vault:group:"+groupNo, runnable)
+}
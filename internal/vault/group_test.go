@@ -0,0 +1,99 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+// Insert a role for testing, the role is removed when the test finishes.
+func insertTestRole(t *testing.T, db *gorm.DB) string {
+	roleNo := util.GenIdP("role_")
+	if err := db.Exec(`INSERT INTO role (role_no, name) VALUES (?, ?)`, roleNo, util.ERand(20)).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM role WHERE role_no = ?`, roleNo) })
+	return roleNo
+}
+
+func assertUserHasRole(t *testing.T, rail miso.Rail, db *gorm.DB, userNo string, roleNo string, expected bool) {
+	t.Helper()
+	ok, err := userHasRole(rail, db, userNo, roleNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != expected {
+		roleNos, _ := listUserRoleNos(rail, db, userNo)
+		t.Fatalf("user %v has role %v: %v, expected %v, roles: %v", userNo, roleNo, ok, expected, roleNos)
+	}
+}
+
+func TestGroupRoles(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+	operator := common.User{UserNo: util.GenIdP("UE"), Username: "test_operator"}
+	member := insertTestUser(t, db)
+	roleNo := insertTestRole(t, db)
+
+	g, err := AddGroup(rail, db, AddGroupReq{Name: util.ERand(20)}, operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM user_group WHERE group_no = ?`, g.GroupNo)
+		db.Exec(`DELETE FROM user_group_member WHERE group_no = ?`, g.GroupNo)
+		db.Exec(`DELETE FROM user_group_role WHERE group_no = ?`, g.GroupNo)
+	})
+
+	if err := AddGroupMembers(rail, db, AddGroupMembersReq{GroupNo: g.GroupNo, UserNos: []string{member.UserNo}}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, false)
+
+	// members inherit roles granted to the group
+	if err := GrantGroupRole(rail, db, GrantGroupRoleReq{GroupNo: g.GroupNo, RoleNo: roleNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, true)
+
+	// operator cannot add themselves, nor grant roles to their own groups
+	err = AddGroupMembers(rail, db, AddGroupMembersReq{GroupNo: g.GroupNo, UserNos: []string{operator.UserNo}}, operator)
+	if err == nil {
+		t.Fatal("operator should not be able to add themselves to group")
+	}
+	err = GrantGroupRole(rail, db, GrantGroupRoleReq{GroupNo: g.GroupNo, RoleNo: roleNo}, common.User{UserNo: member.UserNo, Username: member.Username})
+	if err == nil {
+		t.Fatal("member should not be able to grant roles to the group")
+	}
+
+	// the role is no longer inherited once the member is removed
+	if err := RemoveGroupMember(rail, db, RemoveGroupMemberReq{GroupNo: g.GroupNo, UserNo: member.UserNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, false)
+
+	// or the role is revoked from the group
+	if err := AddGroupMembers(rail, db, AddGroupMembersReq{GroupNo: g.GroupNo, UserNos: []string{member.UserNo}}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, true)
+	if err := RevokeGroupRole(rail, db, RevokeGroupRoleReq{GroupNo: g.GroupNo, RoleNo: roleNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, false)
+
+	// or the group is deleted
+	if err := GrantGroupRole(rail, db, GrantGroupRoleReq{GroupNo: g.GroupNo, RoleNo: roleNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, true)
+	if err := DeleteGroup(rail, db, DeleteGroupReq{GroupNo: g.GroupNo}, operator); err != nil {
+		t.Fatal(err)
+	}
+	assertUserHasRole(t, rail, db, member.UserNo, roleNo, false)
+}
@@ -16,6 +16,27 @@ func init() {
 		Desc("User Login using password, a JWT token is generated and returned").
 		Public()
 
+	miso.IPost("/open/api/user/login/email/send",
+		func(inb *miso.Inbound, req SendEmailLoginReq) (any, error) {
+			return SendEmailLoginEp(inb, req)
+		}).
+		Desc("Send single-use login code or login link to user's verified email").
+		Public()
+
+	miso.IPost("/open/api/user/login/email",
+		func(inb *miso.Inbound, req EmailLoginReq) (string, error) {
+			return EmailLoginEp(inb, req)
+		}).
+		Desc("User Login using the code or link sent to the verified email, a JWT token is generated and returned").
+		Public()
+
+	miso.Get("/open/api/user/challenge",
+		func(inb *miso.Inbound) (Challenge, error) {
+			return IssueChallengeEp(inb)
+		}).
+		Desc("Issue a proof of work challenge, the challenge is required for registration and for login after multiple failures").
+		Public()
+
 	miso.IPost("/open/api/user/register/request",
 		func(inb *miso.Inbound, req RegisterReq) (any, error) {
 			return UserRegisterEp(inb, req)
@@ -37,6 +58,17 @@ func init() {
 		Desc("Admin list users").
 		Resource(ResourceManagerUser)
 
+	miso.IPost("/open/api/user/import",
+		func(inb *miso.Inbound, req ImportUsersReq) (ImportUsersRes, error) {
+			return AdminImportUsersEp(inb, req)
+		}).
+		Desc("Admin import users in bulk using CSV or JSON rows, rows are validated first, nothing is imported if any row is invalid").
+		Resource(ResourceManagerUser)
+
+	miso.RawGet("/open/api/user/export", AdminExportUsersEp).
+		Desc("Admin export users as CSV, supports the same filters as /open/api/user/list in query parameters: username, roleNo and isDisabled").
+		Resource(ResourceManagerUser)
+
 	miso.IPost("/open/api/user/info/update",
 		func(inb *miso.Inbound, req AdminUpdateUserReq) (any, error) {
 			return AdminUpdateUserEp(inb, req)
@@ -51,6 +83,20 @@ func init() {
 		Desc("Admin review user registration").
 		Resource(ResourceManagerUser)
 
+	miso.IPost("/open/api/user/registration/review/bulk",
+		func(inb *miso.Inbound, req AdminBulkReviewUserReq) (BulkReviewUserRes, error) {
+			return AdminBulkReviewUserEp(inb, req)
+		}).
+		Desc("Admin review user registrations in bulk").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/registration/pending/list",
+		func(inb *miso.Inbound, req ListPendingRegistrationReq) (miso.PageRes[PendingRegistration], error) {
+			return AdminListPendingRegistrationsEp(inb, req)
+		}).
+		Desc("Admin list pending user registrations").
+		Resource(ResourceManagerUser)
+
 	miso.Get("/open/api/user/info",
 		func(inb *miso.Inbound) (UserInfoRes, error) {
 			return UserGetUserInfoEp(inb)
@@ -65,6 +111,283 @@ func init() {
 		Desc("User update password").
 		Resource(ResourceBasicUser)
 
+	miso.Get("/open/api/user/profile",
+		func(inb *miso.Inbound) (UserProfile, error) {
+			return UserGetProfileEp(inb)
+		}).
+		Desc("User get profile").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/user/profile/update",
+		func(inb *miso.Inbound, req UpdateProfileReq) (any, error) {
+			return UserUpdateProfileEp(inb, req)
+		}).
+		Desc("User update profile").
+		Resource(ResourceBasicUser)
+
+	miso.Get("/open/api/user/profile/attributes",
+		func(inb *miso.Inbound) ([]ProfileAttributeDef, error) {
+			return ListProfileAttributeDefsEp(inb)
+		}).
+		Desc("List custom profile attributes defined").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/user/profile/admin/update",
+		func(inb *miso.Inbound, req AdminUpdateProfileReq) (any, error) {
+			return AdminUpdateProfileEp(inb, req)
+		}).
+		Desc("Admin update user profile").
+		Resource(ResourceManagerUser)
+
+	miso.Post("/open/api/user/email/verification/send",
+		func(inb *miso.Inbound) (any, error) {
+			return UserSendEmailVerificationEp(inb)
+		}).
+		Desc("User request email verification, a verification link is sent to the email address").
+		Resource(ResourceBasicUser)
+
+	miso.IGet("/open/api/user/email/verify",
+		func(inb *miso.Inbound, req VerifyEmailReq) (any, error) {
+			return VerifyEmailEp(inb, req)
+		}).
+		Desc("Verify email address using the token in verification link").
+		Public()
+
+	miso.IPost("/open/api/user/email/notification/update",
+		func(inb *miso.Inbound, req UpdateEmailNotificationReq) (any, error) {
+			return UserUpdateEmailNotificationEp(inb, req)
+		}).
+		Desc("User update preference of receiving notifications by email").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/user/password/reset/request",
+		func(inb *miso.Inbound, req RequestPasswordResetReq) (any, error) {
+			return RequestPasswordResetEp(inb, req)
+		}).
+		Desc("Request password reset, a password reset link is sent to user's verified email").
+		Public()
+
+	miso.IPost("/open/api/user/password/reset",
+		func(inb *miso.Inbound, req ResetPasswordReq) (any, error) {
+			return ResetPasswordEp(inb, req)
+		}).
+		Desc("Reset password using the token in password reset link").
+		Public()
+
+	miso.IPost("/open/api/user/username/update",
+		func(inb *miso.Inbound, req UpdateUsernameReq) (any, error) {
+			return UserUpdateUsernameEp(inb, req)
+		}).
+		Desc("User update username, tokens issued previously are revoked").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/user/username/admin/update",
+		func(inb *miso.Inbound, req AdminUpdateUsernameReq) (any, error) {
+			return AdminUpdateUsernameEp(inb, req)
+		}).
+		Desc("Admin update username of user, tokens issued previously are revoked").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/username/history",
+		func(inb *miso.Inbound, req ListUsernameHistoryReq) (miso.PageRes[ListedUsernameHistory], error) {
+			return AdminListUsernameHistoryEp(inb, req)
+		}).
+		Desc("Admin list username history of user").
+		Resource(ResourceManagerUser)
+
+	miso.Get("/open/api/user/username/collisions",
+		func(inb *miso.Inbound) (UsernameCollisionReport, error) {
+			return AdminReportUsernameCollisionsEp(inb)
+		}).
+		Desc("Admin report usernames that are not normalized or collide with each other after normalization").
+		Resource(ResourceManagerUser)
+
+	miso.Post("/open/api/user/username/normalize",
+		func(inb *miso.Inbound) (NormalizeUsernamesRes, error) {
+			return AdminNormalizeUsernamesEp(inb)
+		}).
+		Desc("Admin rename users whose usernames are not normalized, collided usernames are skipped").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/delete",
+		func(inb *miso.Inbound, req AdminDeleteUserReq) (any, error) {
+			return AdminDeleteUserEp(inb, req)
+		}).
+		Desc("Admin delete user, the user can be restored within the restore window").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/restore",
+		func(inb *miso.Inbound, req AdminRestoreUserReq) (any, error) {
+			return AdminRestoreUserEp(inb, req)
+		}).
+		Desc("Admin restore deleted user").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/deleted/list",
+		func(inb *miso.Inbound, req ListDeletedUsersReq) (miso.PageRes[DeletedUser], error) {
+			return AdminListDeletedUsersEp(inb, req)
+		}).
+		Desc("Admin list deleted users").
+		Resource(ResourceManagerUser)
+
+	miso.RawGet("/open/api/user/data/export", UserExportDataEp).
+		Desc("User export personal data as a JSON archive").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/user/role/grant",
+		func(inb *miso.Inbound, req GrantUserRoleReq) (any, error) {
+			return AdminGrantUserRoleEp(inb, req)
+		}).
+		Desc("Admin grant additional role to user").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/role/revoke",
+		func(inb *miso.Inbound, req RevokeUserRoleReq) (any, error) {
+			return AdminRevokeUserRoleEp(inb, req)
+		}).
+		Desc("Admin revoke additional role from user").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/user/role/list",
+		func(inb *miso.Inbound, req ListUserRolesReq) ([]UserRole, error) {
+			return AdminListUserRolesEp(inb, req)
+		}).
+		Desc("Admin list roles of user").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/add",
+		func(inb *miso.Inbound, req AddGroupReq) (AddGroupRes, error) {
+			return AdminAddGroupEp(inb, req)
+		}).
+		Desc("Admin add user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/update",
+		func(inb *miso.Inbound, req UpdateGroupReq) (any, error) {
+			return AdminUpdateGroupEp(inb, req)
+		}).
+		Desc("Admin update user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/delete",
+		func(inb *miso.Inbound, req DeleteGroupReq) (any, error) {
+			return AdminDeleteGroupEp(inb, req)
+		}).
+		Desc("Admin delete user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/list",
+		func(inb *miso.Inbound, req ListGroupsReq) (miso.PageRes[ListedGroup], error) {
+			return AdminListGroupsEp(inb, req)
+		}).
+		Desc("Admin list user groups").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/member/add",
+		func(inb *miso.Inbound, req AddGroupMembersReq) (any, error) {
+			return AdminAddGroupMembersEp(inb, req)
+		}).
+		Desc("Admin add users to user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/member/remove",
+		func(inb *miso.Inbound, req RemoveGroupMemberReq) (any, error) {
+			return AdminRemoveGroupMemberEp(inb, req)
+		}).
+		Desc("Admin remove user from user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/member/list",
+		func(inb *miso.Inbound, req ListGroupMembersReq) (miso.PageRes[ListedGroupMember], error) {
+			return AdminListGroupMembersEp(inb, req)
+		}).
+		Desc("Admin list members of user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/role/grant",
+		func(inb *miso.Inbound, req GrantGroupRoleReq) (any, error) {
+			return AdminGrantGroupRoleEp(inb, req)
+		}).
+		Desc("Admin grant role to user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/role/revoke",
+		func(inb *miso.Inbound, req RevokeGroupRoleReq) (any, error) {
+			return AdminRevokeGroupRoleEp(inb, req)
+		}).
+		Desc("Admin revoke role from user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/group/role/list",
+		func(inb *miso.Inbound, req ListGroupRolesReq) ([]ListedGroupRole, error) {
+			return AdminListGroupRolesEp(inb, req)
+		}).
+		Desc("Admin list roles of user group").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/access-request/create",
+		func(inb *miso.Inbound, req CreateAccessRequestReq) (CreateAccessRequestRes, error) {
+			return CreateAccessRequestEp(inb, req)
+		}).
+		Desc("User request access to resource").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/access-request/cancel",
+		func(inb *miso.Inbound, req CancelAccessRequestReq) (any, error) {
+			return CancelAccessRequestEp(inb, req)
+		}).
+		Desc("User cancel pending access request").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/access-request/list/mine",
+		func(inb *miso.Inbound, req ListMyAccessRequestsReq) (miso.PageRes[ListedAccessRequest], error) {
+			return ListMyAccessRequestsEp(inb, req)
+		}).
+		Desc("User list own access requests").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/access-request/list",
+		func(inb *miso.Inbound, req ListAccessRequestsReq) (miso.PageRes[ListedAccessRequest], error) {
+			return ListAccessRequestsEp(inb, req)
+		}).
+		Desc("Approver list access requests of all users").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/access-request/approve",
+		func(inb *miso.Inbound, req ReviewAccessRequestReq) (any, error) {
+			return ApproveAccessRequestEp(inb, req)
+		}).
+		Desc("Approver approve access request, the resource is granted to the requester").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/access-request/deny",
+		func(inb *miso.Inbound, req ReviewAccessRequestReq) (any, error) {
+			return DenyAccessRequestEp(inb, req)
+		}).
+		Desc("Approver deny access request").
+		Resource(ResourceBasicUser)
+
+	miso.IPost("/open/api/admin-scope/add",
+		func(inb *miso.Inbound, req AddAdminScopeReq) (any, error) {
+			return AdminAddAdminScopeEp(inb, req)
+		}).
+		Desc("Administrator add scope to delegated admin").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/admin-scope/remove",
+		func(inb *miso.Inbound, req RemoveAdminScopeReq) (any, error) {
+			return AdminRemoveAdminScopeEp(inb, req)
+		}).
+		Desc("Administrator remove scope from delegated admin").
+		Resource(ResourceManagerUser)
+
+	miso.IPost("/open/api/admin-scope/list",
+		func(inb *miso.Inbound, req ListAdminScopesReq) ([]AdminScope, error) {
+			return AdminListAdminScopesEp(inb, req)
+		}).
+		Desc("Admin list scopes of delegated admins").
+		Resource(ResourceManagerUser)
+
 	miso.IPost("/open/api/token/exchange",
 		func(inb *miso.Inbound, req ExchangeTokenReq) (string, error) {
 			return ExchangeTokenEp(inb, req)
@@ -149,11 +472,36 @@ func init() {
 		Desc("List all resource brief info").
 		Public()
 
+	miso.Get("/open/api/resource/tree",
+		func(inb *miso.Inbound) ([]ResTreeNode, error) {
+			return ListResTreeEp(inb)
+		}).
+		Desc("List all resources as a tree based on the colon-separated namespaces of resource codes").
+		Resource(ResourceManageResources)
+
+	miso.IPost("/open/api/path/access/explain",
+		func(inb *miso.Inbound, req ExplainAccessReq) (ExplainAccessResp, error) {
+			return AdminExplainAccessEp(inb, req)
+		}).
+		Desc("Admin explain access decision of a role or user to a path").
+		Resource(ResourceManageResources)
+
+	miso.IPost("/open/api/permission/simulate",
+		func(inb *miso.Inbound, req SimulateChangeReq) (SimulateChangeResp, error) {
+			return AdminSimulateChangeEp(inb, req)
+		}).
+		Desc("Admin simulate permission change without applying it, returns the roles and users affected").
+		Resource(ResourceManageResources)
+
+	miso.RawGet("/open/api/permission/matrix", AdminExportPermMatrixEp).
+		Desc("Admin export effective permission matrix (role -> resources -> paths, user -> roles), query parameters: format (json or csv), view (roles, users or unreachable, for csv only), group and resCode").
+		Resource(ResourceManageResources)
+
 	miso.IPost("/open/api/role/resource/add",
 		func(inb *miso.Inbound, req AddRoleResReq) (any, error) {
 			return AdminBindRoleResEp(inb, req)
 		}).
-		Desc("Admin add resource to role").
+		Desc("Admin add resource to role, wildcard pattern such as 'postbox:*' matches all resources under the namespace").
 		Resource(ResourceManageResources)
 
 	miso.IPost("/open/api/role/resource/remove",
@@ -170,6 +518,34 @@ func init() {
 		Desc("Admin add role").
 		Resource(ResourceManageResources)
 
+	miso.IPost("/open/api/role/update",
+		func(inb *miso.Inbound, req UpdateRoleReq) (any, error) {
+			return AdminUpdateRoleEp(inb, req)
+		}).
+		Desc("Admin update role").
+		Resource(ResourceManageResources)
+
+	miso.IPost("/open/api/role/clone",
+		func(inb *miso.Inbound, req CloneRoleReq) (CloneRoleRes, error) {
+			return AdminCloneRoleEp(inb, req)
+		}).
+		Desc("Admin clone role, including its resources").
+		Resource(ResourceManageResources)
+
+	miso.IPost("/open/api/role/delete",
+		func(inb *miso.Inbound, req DeleteRoleReq) (any, error) {
+			return AdminDeleteRoleEp(inb, req)
+		}).
+		Desc("Admin delete role, users that still hold the role are moved to the replacement role").
+		Resource(ResourceManageResources)
+
+	miso.IPost("/open/api/role/parent/update",
+		func(inb *miso.Inbound, req UpdateRoleParentReq) (any, error) {
+			return AdminUpdateRoleParentEp(inb, req)
+		}).
+		Desc("Admin update parent of role, resources of the parent role are inherited").
+		Resource(ResourceManageResources)
+
 	miso.IPost("/open/api/role/list",
 		func(inb *miso.Inbound, req ListRoleReq) (ListRoleResp, error) {
 			return AdminListRolesEp(inb, req)
@@ -275,6 +651,18 @@ func init() {
 		}).
 		Desc("Validate resource access")
 
+	miso.IPost("/remote/path/resource/access-test/batch",
+		func(inb *miso.Inbound, req BatchTestResAccessReq) (BatchTestResAccessResp, error) {
+			return ItnBatchCheckResourceAccessEp(inb, req)
+		}).
+		Desc("Validate resource access of multiple paths")
+
+	miso.IPost("/remote/token/revoked",
+		func(inb *miso.Inbound, req api.CheckTokenRevokedReq) (api.CheckTokenRevokedRes, error) {
+			return ItnCheckTokenRevokedEp(inb, req)
+		}).
+		Desc("Check whether the token is revoked, gateways that verify tokens locally should check it as well")
+
 	miso.IPost("/remote/path/add",
 		func(inb *miso.Inbound, req CreatePathReq) (any, error) {
 			return ItnReportPathEp(inb, req)
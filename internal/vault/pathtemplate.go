@@ -0,0 +1,256 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	pathSeg         = "/"
+	pathSegWildcard = "*"
+	pathTailPattern = "**"
+
+	// version of path templates, it's incremented whenever templates are created or removed
+	pathTemplateVersionKey = "user-vault:path:template:version"
+)
+
+var (
+	pathTemplates = &pathTemplateRegistry{}
+)
+
+// Precompiled path templates, shared by all requests and reloaded when the version in redis changes.
+type pathTemplateRegistry struct {
+	mu      sync.RWMutex
+	matcher *pathMatcher
+	version string
+	loaded  bool
+}
+
+// Trie of path templates grouped by http method.
+type pathMatcher struct {
+	roots map[string]*pathNode
+}
+
+type pathNode struct {
+	children map[string]*pathNode
+	param    *pathNode // '{name}' or '*', matches exactly one segment
+	template string    // template that ends at the node
+	tail     string    // template that ends with '**' at the node, matches any remaining segments
+}
+
+func newPathMatcher() *pathMatcher {
+	return &pathMatcher{roots: map[string]*pathNode{}}
+}
+
+func newPathNode() *pathNode {
+	return &pathNode{children: map[string]*pathNode{}}
+}
+
+func splitPath(url string) []string {
+	url = strings.TrimPrefix(url, pathSeg)
+	if url == "" {
+		return nil
+	}
+	return strings.Split(url, pathSeg)
+}
+
+func isPathParamSeg(seg string) bool {
+	return seg == pathSegWildcard || (len(seg) > 2 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"))
+}
+
+// Check whether the url is a path template, e.g., '/vfm/file/{id}/info' or '/static/**'.
+func isPathTemplate(url string) bool {
+	for _, s := range splitPath(url) {
+		if s == pathTailPattern || isPathParamSeg(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate path template, params and wildcards must take the whole segment, and '**' can only be the last segment.
+func validatePathTemplate(url string) error {
+	segs := splitPath(url)
+	for i, s := range segs {
+		if s == pathTailPattern {
+			if i != len(segs)-1 {
+				return miso.NewErrf("Invalid path template, '**' can only be the last segment")
+			}
+			continue
+		}
+		if s == pathSegWildcard {
+			continue
+		}
+		if isPathParamSeg(s) {
+			if strings.ContainsAny(s[1:len(s)-1], "{}*") {
+				return miso.NewErrf("Invalid path template, illegal param name in segment '%v'", s)
+			}
+			continue
+		}
+		if strings.ContainsAny(s, "{}*") {
+			return miso.NewErrf("Invalid path template, params and wildcards must take the whole segment, '%v'", s)
+		}
+	}
+	return nil
+}
+
+// Canonical form of path template, param names are ignored, e.g., '/file/{id}' and '/file/{fileId}' are both '/file/*'.
+func canonicalPathTemplate(url string) string {
+	segs := splitPath(url)
+	for i, s := range segs {
+		if isPathParamSeg(s) {
+			segs[i] = pathSegWildcard
+		}
+	}
+	return pathSeg + strings.Join(segs, pathSeg)
+}
+
+func (m *pathMatcher) add(method string, url string) {
+	n, ok := m.roots[method]
+	if !ok {
+		n = newPathNode()
+		m.roots[method] = n
+	}
+	for _, s := range splitPath(url) {
+		if s == pathTailPattern {
+			n.tail = url
+			return
+		}
+		if isPathParamSeg(s) {
+			if n.param == nil {
+				n.param = newPathNode()
+			}
+			n = n.param
+			continue
+		}
+		c, ok := n.children[s]
+		if !ok {
+			c = newPathNode()
+			n.children[s] = c
+		}
+		n = c
+	}
+	n.template = url
+}
+
+// Find the template that matches the url, literal segments take precedence over params, and params take
+// precedence over '**'.
+func (m *pathMatcher) match(method string, url string) (string, bool) {
+	n, ok := m.roots[method]
+	if !ok {
+		return "", false
+	}
+	return n.match(splitPath(url))
+}
+
+func (n *pathNode) match(segs []string) (string, bool) {
+	if len(segs) < 1 {
+		if n.template != "" {
+			return n.template, true
+		}
+		if n.tail != "" {
+			return n.tail, true
+		}
+		return "", false
+	}
+	if c, ok := n.children[segs[0]]; ok {
+		if t, ok := c.match(segs[1:]); ok {
+			return t, true
+		}
+	}
+	if n.param != nil && segs[0] != "" {
+		if t, ok := n.param.match(segs[1:]); ok {
+			return t, true
+		}
+	}
+	if n.tail != "" {
+		return n.tail, true
+	}
+	return "", false
+}
+
+// Find the path template that matches the url, templates are reloaded if they are changed.
+func (r *pathTemplateRegistry) match(rail miso.Rail, method string, url string) (string, bool, error) {
+	m, err := r.current(rail)
+	if err != nil {
+		return "", false, err
+	}
+	t, ok := m.match(method, url)
+	return t, ok, nil
+}
+
+// Current matcher of path templates, templates are reloaded if they are changed.
+func (r *pathTemplateRegistry) current(rail miso.Rail) (*pathMatcher, error) {
+	ver, err := redis.GetRedis().Get(pathTemplateVersionKey).Result()
+	if err != nil && !redis.IsNil(err) {
+		return nil, fmt.Errorf("failed to load path template version, %w", err)
+	}
+
+	r.mu.RLock()
+	m := r.matcher
+	if !r.loaded || r.version != ver {
+		m = nil
+	}
+	r.mu.RUnlock()
+
+	if m != nil {
+		return m, nil
+	}
+	return r.reload(rail, ver)
+}
+
+func (r *pathTemplateRegistry) reload(rail miso.Rail, ver string) (*pathMatcher, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loaded && r.version == ver {
+		return r.matcher, nil
+	}
+
+	var paths []EPath
+	err := mysql.GetMySQL().
+		Raw(`SELECT method, url FROM path WHERE url LIKE '%{%' OR url LIKE '%*%'`).
+		Scan(&paths).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load path templates, %w", err)
+	}
+
+	m := newPathMatcher()
+	for _, p := range paths {
+		if isPathTemplate(p.Url) {
+			m.add(p.Method, p.Url)
+		}
+	}
+	r.matcher, r.version, r.loaded = m, ver, true
+	rail.Debugf("Loaded %d path templates, version: '%v'", len(paths), ver)
+	return m, nil
+}
+
+// Notify all instances that the path templates are changed.
+func bumpPathTemplateVersion(rail miso.Rail) {
+	if err := redis.GetRedis().Incr(pathTemplateVersionKey).Err(); err != nil {
+		rail.Errorf("Failed to increment path template version, %v", err)
+	}
+}
+
+// Check whether another path template of the same method matches exactly the same urls.
+func findConflictedPathTemplate(method string, url string, pathNo string) (string, error) {
+	var paths []EPath
+	err := mysql.GetMySQL().
+		Raw(`SELECT path_no, url FROM path WHERE method = ? AND path_no != ? AND (url LIKE '%{%' OR url LIKE '%*%')`, method, pathNo).
+		Scan(&paths).Error
+	if err != nil {
+		return "", err
+	}
+	cano := canonicalPathTemplate(url)
+	for _, p := range paths {
+		if canonicalPathTemplate(p.Url) == cano {
+			return p.Url, nil
+		}
+	}
+	return "", nil
+}
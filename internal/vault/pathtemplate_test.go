@@ -0,0 +1,53 @@
+package vault
+
+import "testing"
+
+func TestPathMatcher(t *testing.T) {
+	m := newPathMatcher()
+	m.add("GET", "/vfm/file/{id}/info")
+	m.add("GET", "/vfm/file/list/info")
+	m.add("GET", "/vfm/**")
+	m.add("GET", "/static/**")
+	m.add("POST", "/vfm/file/*")
+
+	cases := []struct {
+		method   string
+		url      string
+		template string
+	}{
+		{"GET", "/vfm/file/123/info", "/vfm/file/{id}/info"},
+		{"GET", "/vfm/file/list/info", "/vfm/file/list/info"},
+		{"GET", "/vfm/file/123", "/vfm/**"},
+		{"GET", "/vfm", "/vfm/**"},
+		{"GET", "/static/js/app.js", "/static/**"},
+		{"POST", "/vfm/file/123", "/vfm/file/*"},
+		{"POST", "/vfm/file/123/info", ""},
+		{"PUT", "/vfm/file/123", ""},
+		{"GET", "/other", ""},
+	}
+	for _, c := range cases {
+		tp, ok := m.match(c.method, c.url)
+		if ok != (c.template != "") || tp != c.template {
+			t.Fatalf("%v %v, expected '%v', got '%v' (%v)", c.method, c.url, c.template, tp, ok)
+		}
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	for _, u := range []string{"/file/{id}/info", "/static/**", "/file/*", "/file"} {
+		if err := validatePathTemplate(u); err != nil {
+			t.Fatalf("%v should be valid, %v", u, err)
+		}
+	}
+	for _, u := range []string{"/static/**/js", "/file/a{id}", "/file/{i{d}", "/file/a*"} {
+		if err := validatePathTemplate(u); err == nil {
+			t.Fatalf("%v should be invalid", u)
+		}
+	}
+	if !isPathTemplate("/file/{id}") || isPathTemplate("/file/id") {
+		t.Fatal("isPathTemplate")
+	}
+	if canonicalPathTemplate("/file/{id}/info") != canonicalPathTemplate("/file/{fileId}/info") {
+		t.Fatal("canonicalPathTemplate")
+	}
+}
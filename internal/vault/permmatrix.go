@@ -0,0 +1,247 @@
+package vault
+
+import (
+	"encoding/csv"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"gorm.io/gorm"
+)
+
+const (
+	PermMatrixViewRoles       = "roles"
+	PermMatrixViewUsers       = "users"
+	PermMatrixViewUnreachable = "unreachable"
+)
+
+type PermMatrixReq struct {
+	Group   string `json:"group" desc:"optional, only include paths of the group"`
+	ResCode string `json:"resCode" desc:"optional, only include the resource, or the resources matched by the wildcard pattern"`
+}
+
+type PermMatrix struct {
+	Roles            []MatrixRole `json:"roles" desc:"role -> resources -> paths"`
+	Users            []MatrixUser `json:"users" desc:"user -> roles"`
+	UnreachablePaths []MatrixPath `json:"unreachablePaths" desc:"protected paths that no role (except the administrator role) can access"`
+}
+
+type MatrixRole struct {
+	RoleNo    string      `json:"roleNo"`
+	RoleName  string      `json:"roleName"`
+	Resources []MatrixRes `json:"resources" desc:"resources granted to the role, including the inherited ones"`
+}
+
+type MatrixRes struct {
+	ResCode string       `json:"resCode"`
+	ResName string       `json:"resName"`
+	Paths   []MatrixPath `json:"paths" desc:"protected paths that require the resource and are accessible by the role"`
+}
+
+type MatrixPath struct {
+	PathNo string `json:"pathNo"`
+	Group  string `json:"group"`
+	Method string `json:"method"`
+	Url    string `json:"url"`
+}
+
+type MatrixUser struct {
+	UserNo   string   `json:"userNo"`
+	Username string   `json:"username"`
+	RoleNos  []string `json:"roleNos"`
+}
+
+// Build the effective permission matrix from the tables that are used to load the path and role resource caches.
+//
+// Like SimulateChange, access of each role is evaluated on its own and grant conditions are treated as satisfied.
+func BuildPermMatrix(rail miso.Rail, db *gorm.DB, req PermMatrixReq) (PermMatrix, error) {
+	s, err := loadPermSnapshot(db)
+	if err != nil {
+		return PermMatrix{}, err
+	}
+	return buildPermMatrix(s, req, func(roleNos []string) ([]MatrixUser, error) { return listMatrixUsers(db, roleNos) })
+}
+
+func buildPermMatrix(s *permSnapshot, req PermMatrixReq, listUsers func(roleNos []string) ([]MatrixUser, error)) (PermMatrix, error) {
+	req.Group = strings.TrimSpace(req.Group)
+	req.ResCode = strings.TrimSpace(req.ResCode)
+
+	resources := make([]string, 0, len(s.resources))
+	for _, code := range s.resources {
+		if req.ResCode == "" || resCodeMatches(req.ResCode, code) {
+			resources = append(resources, code)
+		}
+	}
+	paths := make([]CachedUrlRes, 0, len(s.paths))
+	for _, p := range s.paths {
+		if p.Ptype == PathTypeProtected && (req.Group == "" || p.Pgroup == req.Group) {
+			paths = append(paths, p)
+		}
+	}
+
+	roleNos := make([]string, 0, len(s.parents))
+	for r := range s.parents {
+		roleNos = append(roleNos, r)
+	}
+	sort.Slice(roleNos, func(i, j int) bool {
+		if s.roleNames[roleNos[i]] != s.roleNames[roleNos[j]] {
+			return s.roleNames[roleNos[i]] < s.roleNames[roleNos[j]]
+		}
+		return roleNos[i] < roleNos[j]
+	})
+
+	m := PermMatrix{Roles: []MatrixRole{}, Users: []MatrixUser{}, UnreachablePaths: []MatrixPath{}}
+	reachable := map[string]bool{}
+	for _, r := range roleNos {
+		mr := MatrixRole{RoleNo: r, RoleName: s.roleNames[r], Resources: []MatrixRes{}}
+		accessible := make([]bool, len(paths))
+		for i, p := range paths {
+			accessible[i] = s.roleCanAccess(r, p)
+			if accessible[i] && r != DefaultAdminRoleNo {
+				reachable[p.PathNo] = true
+			}
+		}
+		for _, code := range resources {
+			if !s.roleHasRes(r, code) {
+				continue
+			}
+			res := MatrixRes{ResCode: code, ResName: s.resNames[code], Paths: []MatrixPath{}}
+			for i, p := range paths {
+				if accessible[i] && slices.Contains(p.ResCodes, code) {
+					res.Paths = append(res.Paths, toMatrixPath(p))
+				}
+			}
+			if req.Group != "" && len(res.Paths) < 1 {
+				continue
+			}
+			mr.Resources = append(mr.Resources, res)
+		}
+		if (req.Group != "" || req.ResCode != "") && len(mr.Resources) < 1 {
+			continue
+		}
+		m.Roles = append(m.Roles, mr)
+	}
+
+	for _, p := range paths {
+		if reachable[p.PathNo] {
+			continue
+		}
+		if req.ResCode != "" && !slices.ContainsFunc(p.ResCodes, func(code string) bool { return resCodeMatches(req.ResCode, code) }) {
+			continue
+		}
+		m.UnreachablePaths = append(m.UnreachablePaths, toMatrixPath(p))
+	}
+
+	if len(m.Roles) > 0 {
+		matrixRoleNos := make([]string, 0, len(m.Roles))
+		for _, r := range m.Roles {
+			matrixRoleNos = append(matrixRoleNos, r.RoleNo)
+		}
+		users, err := listUsers(matrixRoleNos)
+		if err != nil {
+			return PermMatrix{}, err
+		}
+		m.Users = users
+	}
+	return m, nil
+}
+
+func toMatrixPath(p CachedUrlRes) MatrixPath {
+	return MatrixPath{PathNo: p.PathNo, Group: p.Pgroup, Method: p.Method, Url: p.Url}
+}
+
+// List users holding any of the roles, including the roles granted to the users' groups.
+func listMatrixUsers(db *gorm.DB, roleNos []string) ([]MatrixUser, error) {
+	var rows []struct {
+		UserNo   string
+		Username string
+		RoleNo   string
+	}
+	err := db.Raw(`SELECT t.user_no, u.username, t.role_no FROM (`+userRoleUnionSql(userRoleByRole)+`) t
+		JOIN user u ON t.user_no = u.user_no
+		WHERE u.is_del = 0 ORDER BY u.username, t.seq`, userRoleUnionArgs(roleNos)...).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	users := []MatrixUser{}
+	idx := map[string]int{}
+	for _, r := range rows {
+		i, ok := idx[r.UserNo]
+		if !ok {
+			i = len(users)
+			idx[r.UserNo] = i
+			users = append(users, MatrixUser{UserNo: r.UserNo, Username: r.Username})
+		}
+		if !slices.Contains(users[i].RoleNos, r.RoleNo) {
+			users[i].RoleNos = append(users[i].RoleNos, r.RoleNo)
+		}
+	}
+	return users, nil
+}
+
+// Write the view of the matrix as CSV, view is one of roles (by default), users and unreachable.
+//
+// Like WriteUsersCsv, cells that may be interpreted as formulas are escaped.
+func WritePermMatrixCsv(w io.Writer, m PermMatrix, view string) error {
+	cw := csv.NewWriter(w)
+	var err error
+	switch view {
+	case PermMatrixViewUsers:
+		if err = cw.Write([]string{"userNo", "username", "roleNo", "roleName"}); err != nil {
+			return err
+		}
+		roleNames := map[string]string{}
+		for _, r := range m.Roles {
+			roleNames[r.RoleNo] = r.RoleName
+		}
+		for _, u := range m.Users {
+			for _, r := range u.RoleNos {
+				if err = cw.Write(csvSafeRow(u.UserNo, u.Username, r, roleNames[r])); err != nil {
+					return err
+				}
+			}
+		}
+	case PermMatrixViewUnreachable:
+		if err = cw.Write([]string{"pathNo", "group", "method", "url"}); err != nil {
+			return err
+		}
+		for _, p := range m.UnreachablePaths {
+			if err = cw.Write(csvSafeRow(p.PathNo, p.Group, p.Method, p.Url)); err != nil {
+				return err
+			}
+		}
+	case PermMatrixViewRoles, "":
+		if err = cw.Write([]string{"roleNo", "roleName", "resCode", "resName", "pathNo", "group", "method", "url"}); err != nil {
+			return err
+		}
+		for _, r := range m.Roles {
+			for _, res := range r.Resources {
+				if len(res.Paths) < 1 { // resource without path
+					if err = cw.Write(csvSafeRow(r.RoleNo, r.RoleName, res.ResCode, res.ResName, "", "", "", "")); err != nil {
+						return err
+					}
+				}
+				for _, p := range res.Paths {
+					if err = cw.Write(csvSafeRow(r.RoleNo, r.RoleName, res.ResCode, res.ResName, p.PathNo, p.Group, p.Method, p.Url)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	default:
+		return checkPermMatrixView(view)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func checkPermMatrixView(view string) error {
+	switch view {
+	case PermMatrixViewRoles, PermMatrixViewUsers, PermMatrixViewUnreachable, "":
+		return nil
+	}
+	return miso.NewErrf("Invalid view, should be one of roles, users and unreachable")
+}
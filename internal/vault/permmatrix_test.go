@@ -0,0 +1,48 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuildPermMatrix(t *testing.T) {
+	s := testPermSnapshot()
+	s.resNames = map[string]string{"postbox:query": "Query", "postbox:delete": "Delete"}
+	noUsers := func(roleNos []string) ([]MatrixUser, error) { return []MatrixUser{}, nil }
+
+	m, err := buildPermMatrix(s, PermMatrixReq{}, noUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Roles) != 3 {
+		t.Fatal(m.Roles)
+	}
+	for _, r := range m.Roles {
+		if len(r.Resources) != 1 || r.Resources[0].ResCode != "postbox:query" || len(r.Resources[0].Paths) != 1 {
+			t.Fatal(r)
+		}
+	}
+	if len(m.UnreachablePaths) != 1 || m.UnreachablePaths[0].PathNo != "p2" {
+		t.Fatal(m.UnreachablePaths)
+	}
+
+	m, err = buildPermMatrix(s, PermMatrixReq{ResCode: "postbox:delete"}, noUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Roles) != 0 || len(m.UnreachablePaths) != 1 {
+		t.Fatal(m)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePermMatrixCsv(&buf, m, PermMatrixViewUnreachable); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "p2,") {
+		t.Fatal(buf.String())
+	}
+	if err := WritePermMatrixCsv(&buf, m, "unknown"); err == nil {
+		t.Fatal("view should be invalid")
+	}
+}
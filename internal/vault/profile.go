@@ -0,0 +1,207 @@
+package vault
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+var (
+	emailRegexp       = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	phoneRegexp       = regexp.MustCompile(`^\+?[0-9][0-9\- ]{2,31}$`)
+	displayNameMaxLen = 64
+	emailMaxLen       = 255
+)
+
+// Custom profile attribute defined in configuration.
+type ProfileAttributeDef struct {
+	Name         string `json:"name" desc:"name of the attribute"`
+	Desc         string `json:"desc" desc:"description of the attribute"`
+	MaxLen       int    `json:"maxLen" desc:"max length of the attribute value"`
+	SelfEditable bool   `json:"selfEditable" desc:"whether the attribute can be edited by the user"`
+}
+
+func LoadProfileAttributeDefs() []ProfileAttributeDef {
+	var defs []ProfileAttributeDef
+	miso.UnmarshalFromPropKey(PropProfileAttributes, &defs)
+	if defs == nil {
+		defs = []ProfileAttributeDef{}
+	}
+	return defs
+}
+
+type UserProfile struct {
+	UserNo      string             `json:"userNo"`
+	Username    string             `json:"username"`
+	Email       string             `json:"email"`
+	DisplayName string             `json:"displayName"`
+	Phone       string             `json:"phone"`
+	Attributes  api.UserAttributes `json:"attributes"`
+
+	EmailVerified     bool `json:"emailVerified"`
+	EmailNotification bool `json:"emailNotification" desc:"whether notifications are also delivered to the verified email"`
+}
+
+func GetUserProfile(rail miso.Rail, tx *gorm.DB, username string) (UserProfile, error) {
+	u, err := LoadUserBriefThrCache(rail, tx, username)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	if u.Attributes == nil {
+		u.Attributes = api.UserAttributes{}
+	}
+	return UserProfile{
+		UserNo:      u.UserNo,
+		Username:    u.Username,
+		Email:       u.Email,
+		DisplayName: u.DisplayName,
+		Phone:       u.Phone,
+		Attributes:  u.Attributes,
+
+		EmailVerified:     u.EmailVerified,
+		EmailNotification: u.EmailNotification,
+	}, nil
+}
+
+// Fields that are absent are left unchanged, an empty value clears the field.
+type UpdateProfileReq struct {
+	Email       *string           `json:"email" desc:"optional, unchanged if absent"`
+	DisplayName *string           `json:"displayName" desc:"optional, unchanged if absent"`
+	Phone       *string           `json:"phone" desc:"optional, unchanged if absent"`
+	Attributes  map[string]string `json:"attributes" desc:"custom attributes, only attributes defined in configuration are accepted, attributes that are absent are unchanged"`
+}
+
+type AdminUpdateProfileReq struct {
+	UserNo      string            `json:"userNo" valid:"notEmpty"`
+	Email       *string           `json:"email" desc:"optional, unchanged if absent"`
+	DisplayName *string           `json:"displayName" desc:"optional, unchanged if absent"`
+	Phone       *string           `json:"phone" desc:"optional, unchanged if absent"`
+	Attributes  map[string]string `json:"attributes" desc:"custom attributes, only attributes defined in configuration are accepted, attributes that are absent are unchanged"`
+}
+
+func UserUpdateProfile(rail miso.Rail, tx *gorm.DB, req UpdateProfileReq, user common.User) error {
+	u, err := loadUser(rail, tx, user.Username)
+	if err != nil {
+		return err
+	}
+	return updateProfile(rail, tx, u, req, user, false)
+}
+
+func AdminUpdateProfile(rail miso.Rail, tx *gorm.DB, req AdminUpdateProfileReq, operator common.User) error {
+	u, err := loadUserByUserNo(rail, tx, req.UserNo)
+	if err != nil {
+		return err
+	}
+	return updateProfile(rail, tx, u, UpdateProfileReq{
+		Email:       req.Email,
+		DisplayName: req.DisplayName,
+		Phone:       req.Phone,
+		Attributes:  req.Attributes,
+	}, operator, true)
+}
+
+func updateProfile(rail miso.Rail, tx *gorm.DB, u User, req UpdateProfileReq, operator common.User, isAdmin bool) error {
+	p, err := mergeProfile(u, req, isAdmin)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec(`UPDATE user SET email = ?, email_verified = ?, display_name = ?, phone = ?, attributes = ?, update_by = ? WHERE user_no = ?`,
+		p.Email, p.EmailVerified, p.DisplayName, p.Phone, p.Attributes, operator.Username, u.UserNo).Error
+	if err != nil {
+		return err
+	}
+	rail.Infof("User %v's profile updated by %v", u.Username, operator.Username)
+
+	if err := InvalidateUserInfoCache(rail, u.Username); err != nil {
+		rail.Errorf("Failed to invalidate user info cache, username: %v, %v", u.Username, err)
+	}
+	return nil
+}
+
+type mergedProfile struct {
+	Email         string
+	EmailVerified bool
+	DisplayName   string
+	Phone         string
+	Attributes    api.UserAttributes
+}
+
+// Merge the update into user's current profile, fields that are absent in the request are unchanged.
+func mergeProfile(u User, req UpdateProfileReq, isAdmin bool) (mergedProfile, error) {
+	p := mergedProfile{Email: u.Email, DisplayName: u.DisplayName, Phone: u.Phone}
+	if req.Email != nil {
+		p.Email = strings.TrimSpace(*req.Email)
+		if p.Email != "" && (len(p.Email) > emailMaxLen || !emailRegexp.MatchString(p.Email)) {
+			return p, miso.NewErrf("Invalid email address")
+		}
+	}
+	if req.DisplayName != nil {
+		p.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if len([]rune(p.DisplayName)) > displayNameMaxLen {
+			return p, miso.NewErrf("Display name must have at most %v characters", displayNameMaxLen)
+		}
+	}
+	if req.Phone != nil {
+		p.Phone = strings.TrimSpace(*req.Phone)
+		if p.Phone != "" && !phoneRegexp.MatchString(p.Phone) {
+			return p, miso.NewErrf("Invalid phone number")
+		}
+	}
+
+	attrs, err := mergeProfileAttributes(u.Attributes, req.Attributes, isAdmin)
+	if err != nil {
+		return p, err
+	}
+	p.Attributes = attrs
+
+	// changed email must be verified again
+	p.EmailVerified = u.EmailVerified && u.Email == p.Email
+	return p, nil
+}
+
+func mergeProfileAttributes(prev api.UserAttributes, updated map[string]string, isAdmin bool) (api.UserAttributes, error) {
+	defs := LoadProfileAttributeDefs()
+	merged := api.UserAttributes{}
+	for _, d := range defs {
+		if v, ok := prev[d.Name]; ok {
+			merged[d.Name] = v
+		}
+	}
+
+	for k, v := range updated {
+		d, ok := findProfileAttributeDef(defs, k)
+		if !ok {
+			return nil, miso.NewErrf("Unknown profile attribute '%v'", k)
+		}
+		v = strings.TrimSpace(v)
+		if !isAdmin && !d.SelfEditable {
+			if prev[k] == v {
+				continue
+			}
+			return nil, miso.NewErrf("Profile attribute '%v' can only be updated by administrator", k)
+		}
+		if d.MaxLen > 0 && len([]rune(v)) > d.MaxLen {
+			return nil, miso.NewErrf("Profile attribute '%v' must have at most %v characters", k, d.MaxLen)
+		}
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func findProfileAttributeDef(defs []ProfileAttributeDef, name string) (ProfileAttributeDef, bool) {
+	for _, d := range defs {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return ProfileAttributeDef{}, false
+}
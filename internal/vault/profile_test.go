@@ -0,0 +1,85 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/user-vault/api"
+)
+
+func TestMergeProfileAttributes(t *testing.T) {
+	miso.SetProp(PropProfileAttributes, []map[string]any{
+		{"name": "department", "maxLen": 5, "selfEditable": false},
+		{"name": "nickname", "selfEditable": true},
+	})
+
+	prev := api.UserAttributes{"department": "dev", "removed": "abc"}
+
+	m, err := mergeProfileAttributes(prev, map[string]string{"nickname": " yo "}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m["nickname"] != "yo" || m["department"] != "dev" {
+		t.Fatalf("%+v", m)
+	}
+
+	if _, err := mergeProfileAttributes(prev, map[string]string{"department": "ops"}, false); err == nil {
+		t.Fatal("should not be self editable")
+	}
+	if _, err := mergeProfileAttributes(prev, map[string]string{"department": "dev"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mergeProfileAttributes(prev, map[string]string{"unknown": "1"}, true); err == nil {
+		t.Fatal("should reject unknown attribute")
+	}
+	if _, err := mergeProfileAttributes(prev, map[string]string{"department": "toolong"}, true); err == nil {
+		t.Fatal("should reject long value")
+	}
+
+	m, err = mergeProfileAttributes(prev, map[string]string{"department": ""}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 0 {
+		t.Fatalf("%+v", m)
+	}
+}
+
+func TestMergeProfile(t *testing.T) {
+	miso.SetProp(PropProfileAttributes, []map[string]any{
+		{"name": "nickname", "selfEditable": true},
+	})
+	u := User{
+		Email:         "a@b.com",
+		EmailVerified: true,
+		DisplayName:   "Alice",
+		Phone:         "123456",
+		Attributes:    api.UserAttributes{"nickname": "al"},
+	}
+	str := func(s string) *string { return &s }
+
+	// absent fields are unchanged
+	p, err := mergeProfile(u, UpdateProfileReq{DisplayName: str(" Bob ")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DisplayName != "Bob" || p.Email != u.Email || !p.EmailVerified || p.Phone != u.Phone || p.Attributes["nickname"] != "al" {
+		t.Fatalf("%+v", p)
+	}
+
+	// empty values clear the fields, changed email must be verified again
+	p, err = mergeProfile(u, UpdateProfileReq{Email: str("c@d.com"), Phone: str("")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Email != "c@d.com" || p.EmailVerified || p.Phone != "" || p.DisplayName != u.DisplayName {
+		t.Fatalf("%+v", p)
+	}
+
+	if _, err := mergeProfile(u, UpdateProfileReq{Email: str("invalid")}, false); err == nil {
+		t.Fatal("should reject invalid email")
+	}
+	if _, err := mergeProfile(u, UpdateProfileReq{Phone: str("abc")}, false); err == nil {
+		t.Fatal("should reject invalid phone")
+	}
+}
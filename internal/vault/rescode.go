@@ -0,0 +1,85 @@
+package vault
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	resCodeSep      = ":"
+	resCodeWildcard = "*"
+)
+
+type ResTreeNode struct {
+	Segment  string        `json:"segment" desc:"last segment of the resource code"`
+	Code     string        `json:"code" desc:"resource code, empty if the node is only a namespace"`
+	Name     string        `json:"name" desc:"resource name, empty if the node is only a namespace"`
+	Pattern  string        `json:"pattern" desc:"wildcard pattern matching all resources under the node, empty if the node doesn't have children"`
+	Children []ResTreeNode `json:"children"`
+}
+
+// Check whether the resource code is a wildcard pattern, e.g., 'postbox:*' or 'postbox:notification:*'.
+func isResCodePattern(code string) bool {
+	prefix, ok := strings.CutSuffix(code, resCodeSep+resCodeWildcard)
+	return ok && prefix != "" && !strings.Contains(prefix, resCodeWildcard)
+}
+
+// Wildcard patterns that match the resource code, the closest one comes first.
+//
+// E.g., 'postbox:notification:query' is matched by 'postbox:notification:*' and 'postbox:*'.
+func resCodePatterns(code string) []string {
+	segs := strings.Split(code, resCodeSep)
+	patterns := make([]string, 0, len(segs)-1)
+	for i := len(segs) - 1; i > 0; i-- {
+		patterns = append(patterns, strings.Join(segs[:i], resCodeSep)+resCodeSep+resCodeWildcard)
+	}
+	return patterns
+}
+
+// Check whether the resource code is matched by the pattern, pattern without wildcard only matches itself.
+func resCodeMatches(pattern string, code string) bool {
+	if !isResCodePattern(pattern) {
+		return pattern == code
+	}
+	return strings.HasPrefix(code, strings.TrimSuffix(pattern, resCodeWildcard))
+}
+
+// Build tree of resources based on the colon-separated namespaces of the codes.
+func buildResTree(res []ResBrief) []ResTreeNode {
+	type node struct {
+		ResTreeNode
+		children map[string]*node
+	}
+	root := &node{children: map[string]*node{}}
+	for _, r := range res {
+		cur := root
+		segs := strings.Split(r.Code, resCodeSep)
+		for i, s := range segs {
+			child, ok := cur.children[s]
+			if !ok {
+				child = &node{ResTreeNode: ResTreeNode{Segment: s}, children: map[string]*node{}}
+				cur.children[s] = child
+			}
+			if i == len(segs)-1 {
+				child.Code = r.Code
+				child.Name = r.Name
+			} else {
+				child.Pattern = strings.Join(segs[:i+1], resCodeSep) + resCodeSep + resCodeWildcard
+			}
+			cur = child
+		}
+	}
+
+	var convert func(n *node) []ResTreeNode
+	convert = func(n *node) []ResTreeNode {
+		nodes := make([]ResTreeNode, 0, len(n.children))
+		for _, c := range n.children {
+			t := c.ResTreeNode
+			t.Children = convert(c)
+			nodes = append(nodes, t)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Segment < nodes[j].Segment })
+		return nodes
+	}
+	return convert(root)
+}
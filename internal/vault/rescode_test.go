@@ -0,0 +1,60 @@
+package vault
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResCodePatterns(t *testing.T) {
+	p := resCodePatterns("postbox:notification:query")
+	if !slices.Equal(p, []string{"postbox:notification:*", "postbox:*"}) {
+		t.Fatalf("unexpected patterns: %v", p)
+	}
+	if p := resCodePatterns("manage-users"); len(p) != 0 {
+		t.Fatalf("unexpected patterns: %v", p)
+	}
+}
+
+func TestIsResCodePattern(t *testing.T) {
+	for _, c := range []string{"postbox:*", "postbox:notification:*"} {
+		if !isResCodePattern(c) {
+			t.Fatalf("%v should be pattern", c)
+		}
+	}
+	for _, c := range []string{"*", ":*", "postbox", "postbox*", "post*:*"} {
+		if isResCodePattern(c) {
+			t.Fatalf("%v should not be pattern", c)
+		}
+	}
+}
+
+func TestResCodeMatches(t *testing.T) {
+	if !resCodeMatches("postbox:*", "postbox:notification:query") {
+		t.Fatal("should match")
+	}
+	if resCodeMatches("postbox:*", "postboxes:query") {
+		t.Fatal("should not match")
+	}
+	if !resCodeMatches("manage-users", "manage-users") || resCodeMatches("manage-users", "manage-users:x") {
+		t.Fatal("code without wildcard should only match itself")
+	}
+}
+
+func TestBuildResTree(t *testing.T) {
+	tree := buildResTree([]ResBrief{
+		{Code: "postbox:notification:query", Name: "Query Notification"},
+		{Code: "postbox:notification:create", Name: "Create Notification"},
+		{Code: "manage-users", Name: "Manage Users"},
+	})
+	if len(tree) != 2 || tree[0].Code != "manage-users" || tree[1].Segment != "postbox" {
+		t.Fatalf("unexpected tree: %+v", tree)
+	}
+	pb := tree[1]
+	if pb.Code != "" || pb.Pattern != "postbox:*" || len(pb.Children) != 1 {
+		t.Fatalf("unexpected namespace node: %+v", pb)
+	}
+	n := pb.Children[0]
+	if n.Pattern != "postbox:notification:*" || len(n.Children) != 2 || n.Children[0].Code != "postbox:notification:create" {
+		t.Fatalf("unexpected node: %+v", n)
+	}
+}
@@ -3,8 +3,8 @@ package vault
 import (
 	"crypto/md5"
 	"encoding/base64"
-	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,18 +24,24 @@ var (
 	roleInfoCache = redis.NewRCache[api.RoleInfoResp]("user-vault:role:info", redis.RCacheConfig{Exp: 10 * time.Minute, NoSync: true})
 
 	// cache for url's resource, url -> CachedUrlRes
-	urlResCache = redis.NewRCache[CachedUrlRes]("user-vault:url:res:v2", redis.RCacheConfig{Exp: 30 * time.Minute})
+	urlResCache = redis.NewRCache[CachedUrlRes](urlResCacheName, redis.RCacheConfig{Exp: 30 * time.Minute})
 
 	// cache for role's resource, role + res -> flag ("1")
-	roleResCache = redis.NewRCache[string]("user-vault:role:res", redis.RCacheConfig{Exp: 1 * time.Hour, NoSync: true})
+	roleResCache = redis.NewRCache[string](roleResCacheName, redis.RCacheConfig{Exp: 1 * time.Hour, NoSync: true})
 )
 
 const (
+	urlResCacheName  = "user-vault:url:res:v3"
+	roleResCacheName = "user-vault:role:res"
+
 	// default roleno for admin
 	DefaultAdminRoleNo = "role_554107924873216177918"
 
 	PathTypeProtected string = "PROTECTED"
 	PathTypePublic    string = "PUBLIC"
+
+	PathResModeAny string = "ANY" // any of the resources bound to the path is required
+	PathResModeAll string = "ALL" // all of the resources bound to the path are required
 )
 
 type PathRes struct {
@@ -57,6 +63,7 @@ type ExtendedPathRes struct {
 	Url        string // url
 	Method     string // http method
 	Ptype      string // path type: PROTECTED, PUBLIC
+	ResMode    string // ANY, ALL
 	CreateTime util.ETime
 	CreateBy   string
 	UpdateTime util.ETime
@@ -71,6 +78,7 @@ type EPath struct {
 	Url        string // url
 	Method     string // method
 	Ptype      string // path type: PROTECTED, PUBLIC
+	ResMode    string // ANY, ALL
 	CreateTime util.ETime
 	CreateBy   string
 	UpdateTime util.ETime
@@ -88,43 +96,51 @@ type ERes struct {
 }
 
 type ERoleRes struct {
-	Id         int    // id
-	RoleNo     string // role no
-	ResCode    string // resource code
-	CreateTime util.ETime
-	CreateBy   string
-	UpdateTime util.ETime
-	UpdateBy   string
+	Id           int         // id
+	RoleNo       string      // role no
+	ResCode      string      // resource code
+	Effect       string      // ALLOW or DENY
+	ResCondition string      // condition of the grant, see package cond
+	ValidFrom    *util.ETime // when the grant becomes valid
+	ValidUntil   *util.ETime // when the grant expires
+	CreateTime   util.ETime
+	CreateBy     string
+	UpdateTime   util.ETime
+	UpdateBy     string
 }
 
 type ERole struct {
-	Id         int
-	RoleNo     string
-	Name       string
-	CreateTime util.ETime
-	CreateBy   string
-	UpdateTime util.ETime
-	UpdateBy   string
+	Id           int
+	RoleNo       string
+	Name         string
+	ParentRoleNo string
+	OwnerUserNo  string
+	CreateTime   util.ETime
+	CreateBy     string
+	UpdateTime   util.ETime
+	UpdateBy     string
 }
 
 type WRole struct {
-	Id         int        `json:"id"`
-	RoleNo     string     `json:"roleNo"`
-	Name       string     `json:"name"`
-	CreateTime util.ETime `json:"createTime"`
-	CreateBy   string     `json:"createBy"`
-	UpdateTime util.ETime `json:"updateTime"`
-	UpdateBy   string     `json:"updateBy"`
+	Id           int        `json:"id"`
+	RoleNo       string     `json:"roleNo"`
+	Name         string     `json:"name"`
+	ParentRoleNo string     `json:"parentRoleNo"`
+	CreateTime   util.ETime `json:"createTime"`
+	CreateBy     string     `json:"createBy"`
+	UpdateTime   util.ETime `json:"updateTime"`
+	UpdateBy     string     `json:"updateBy"`
 }
 
 type CachedUrlRes struct {
-	Id      int    // id
-	Pgroup  string // path group
-	PathNo  string // path no
-	ResCode string // resource code
-	Url     string // url
-	Method  string // http method
-	Ptype   string // path type: PROTECTED, PUBLIC
+	Id       int      // id
+	Pgroup   string   // path group
+	PathNo   string   // path no
+	ResCodes []string // resource codes
+	ResMode  string   // ANY, ALL
+	Url      string   // url
+	Method   string   // http method
+	Ptype    string   // path type: PROTECTED, PUBLIC
 }
 
 type ResBrief struct {
@@ -133,13 +149,18 @@ type ResBrief struct {
 }
 
 type AddRoleReq struct {
-	Name string `json:"name" validation:"notEmpty,maxLen:32"` // role name
+	Name         string `json:"name" validation:"notEmpty,maxLen:32"` // role name
+	ParentRoleNo string `json:"parentRoleNo" desc:"optional parent role, resources of the parent role are inherited"`
 }
 
 type TestResAccessReq struct {
-	RoleNo string `json:"roleNo"`
-	Url    string `json:"url"`
-	Method string `json:"method"`
+	RoleNo      string   `json:"roleNo"`
+	RoleNos     []string `json:"roleNos" desc:"all roles of the user (the 'roles' claim in token), access is granted if any of them has the resource"`
+	Url         string   `json:"url"`
+	Method      string   `json:"method"`
+	ClientIp    string   `json:"clientIp" desc:"client ip address, used to evaluate grant conditions"`
+	LoginMethod string   `json:"loginMethod" desc:"login method of the token (the 'loginmethod' claim), used to evaluate grant conditions"`
+	Username    string   `json:"username" desc:"username of the user, used to evaluate grant conditions on user attributes"`
 }
 
 type TestResAccessResp struct {
@@ -176,6 +197,8 @@ type WPath struct {
 	Desc       string     `json:"desc"`
 	Url        string     `json:"url"`
 	Ptype      string     `json:"ptype" desc:"path type: 'PROTECTED' - authorization required, 'PUBLIC' - publicly accessible"`
+	ResMode    string     `json:"resMode" desc:"'ANY' - any of the resources is required, 'ALL' - all of the resources are required"`
+	ResCodes   []string   `json:"resCodes" desc:"resources bound to the path"`
 	CreateTime util.ETime `json:"createTime"`
 	CreateBy   string     `json:"createBy"`
 	UpdateTime util.ETime `json:"updateTime"`
@@ -204,7 +227,7 @@ type BindPathResReq struct {
 
 type UnbindPathResReq struct {
 	PathNo  string `json:"pathNo" validation:"notEmpty"`
-	ResCode string `json:"resCode" validation:"notEmpty"`
+	ResCode string `json:"resCode" desc:"optional, resource to unbind, all resources of the path are unbound if it's empty"`
 }
 
 type ListRoleResReq struct {
@@ -218,8 +241,12 @@ type RemoveRoleResReq struct {
 }
 
 type AddRoleResReq struct {
-	RoleNo  string `json:"roleNo" validation:"notEmpty"`
-	ResCode string `json:"resCode" validation:"notEmpty"`
+	RoleNo     string      `json:"roleNo" validation:"notEmpty"`
+	ResCode    string      `json:"resCode" validation:"notEmpty"`
+	Effect     string      `json:"effect" desc:"optional, ALLOW (by default) or DENY, deny rules override allows of all roles of the user"`
+	Condition  string      `json:"condition" validation:"maxLen:255" desc:"optional, condition on request attributes, the grant only applies when the condition is satisfied, e.g., cidr(ip, \"10.0.0.0/8\") && hour >= 9"`
+	ValidFrom  *util.ETime `json:"validFrom" desc:"optional, when the grant becomes valid"`
+	ValidUntil *util.ETime `json:"validUntil" desc:"optional, when the grant expires"`
 }
 
 type ListRoleResResp struct {
@@ -228,11 +255,19 @@ type ListRoleResResp struct {
 }
 
 type ListedRoleRes struct {
-	Id         int        `json:"id"`
-	ResCode    string     `json:"resCode"`
-	ResName    string     `json:"resName"`
-	CreateTime util.ETime `json:"createTime"`
-	CreateBy   string     `json:"createBy"`
+	Id            int         `json:"id"`
+	ResCode       string      `json:"resCode"`
+	ResName       string      `json:"resName"`
+	Wildcard      bool        `json:"wildcard" desc:"whether the resCode is a wildcard pattern, e.g., 'postbox:*'"`
+	Effect        string      `json:"effect" desc:"ALLOW or DENY"`
+	ResCondition  string      `json:"condition" desc:"condition of the grant, empty if the grant is unconditional"`
+	Overridden    bool        `json:"overridden" desc:"whether the allowed resource is overridden by deny rule of the role or its ancestors"`
+	Inherited     bool        `json:"inherited" desc:"whether the resource is inherited from ancestor role"`
+	InheritedFrom string      `json:"inheritedFrom" desc:"role no of the ancestor role that the resource is bound to"`
+	ValidFrom     *util.ETime `json:"validFrom"`
+	ValidUntil    *util.ETime `json:"validUntil"`
+	CreateTime    util.ETime  `json:"createTime"`
+	CreateBy      string      `json:"createBy"`
 }
 
 type GenResScriptReq struct {
@@ -240,18 +275,20 @@ type GenResScriptReq struct {
 }
 
 type UpdatePathReq struct {
-	Type   string `json:"type" validation:"notEmpty,member:PROTECTED|PUBLIC" desc:"path type: 'PROTECTED' - authorization required, 'PUBLIC' - publicly accessible"`
-	PathNo string `json:"pathNo" validation:"notEmpty"`
-	Group  string `json:"group" validation:"notEmpty,maxLen:20"`
+	Type    string `json:"type" validation:"notEmpty,member:PROTECTED|PUBLIC" desc:"path type: 'PROTECTED' - authorization required, 'PUBLIC' - publicly accessible"`
+	PathNo  string `json:"pathNo" validation:"notEmpty"`
+	Group   string `json:"group" validation:"notEmpty,maxLen:20"`
+	ResMode string `json:"resMode" desc:"optional, 'ANY' - any of the resources is required, 'ALL' - all of the resources are required, unchanged if it's empty"`
 }
 
 type CreatePathReq struct {
 	Type    string `json:"type" validation:"notEmpty" desc:"path type: 'PROTECTED' - authorization required, 'PUBLIC' - publicly accessible"`
-	Url     string `json:"url" validation:"notEmpty,maxLen:128"`
+	Url     string `json:"url" validation:"notEmpty,maxLen:128" desc:"url or path template, e.g., '/file/{id}/info' or '/static/**'"`
 	Group   string `json:"group" validation:"notEmpty,maxLen:20"`
 	Method  string `json:"method" validation:"notEmpty,maxLen:10"`
 	Desc    string `json:"desc" validation:"maxLen:255"`
 	ResCode string `json:"resCode"`
+	ResMode string `json:"resMode" desc:"optional, 'ANY' (by default) - any of the resources is required, 'ALL' - all of the resources are required"`
 }
 
 type DeletePathReq struct {
@@ -280,10 +317,10 @@ func DeleteResource(rail miso.Rail, req DeleteResourceReq) error {
 
 	_, err := lockResourceGlobal(rail, func() (any, error) {
 		return nil, mysql.GetMySQL().Transaction(func(tx *gorm.DB) error {
-			if t := tx.Exec(`delete from resource where code = ?`, req.ResCode); t != nil {
+			if t := tx.Exec(`delete from resource where code = ?`, req.ResCode); t.Error != nil {
 				return t.Error
 			}
-			if t := tx.Exec(`delete from role_resource where res_code = ?`, req.ResCode); t != nil {
+			if t := tx.Exec(`delete from role_resource where res_code = ?`, req.ResCode); t.Error != nil {
 				return t.Error
 			}
 			return tx.Exec(`delete from path_resource where res_code = ?`, req.ResCode).Error
@@ -331,25 +368,74 @@ func ListResourceCandidatesForRole(ec miso.Rail, roleNo string) ([]ResBrief, err
 }
 
 func ListAllResBriefsOfRole(ec miso.Rail, roleNo string) ([]ResBrief, error) {
+	return ListAllResBriefsOfRoles(ec, []string{roleNo})
+}
+
+func ListAllResBriefsOfRoles(ec miso.Rail, roleNos []string) ([]ResBrief, error) {
 	var res []ResBrief
 
-	if roleNo == DefaultAdminRoleNo {
+	if slices.Contains(roleNos, DefaultAdminRoleNo) {
 		return ListAllResBriefs(ec)
 	}
+	if len(roleNos) < 1 {
+		return []ResBrief{}, nil
+	}
+
+	parents, err := loadRoleParents(ec)
+	if err != nil {
+		return nil, err
+	}
+	effective := []string{}
+	for _, r := range roleNos {
+		effective = append(effective, parents.ancestors(r)...)
+	}
 
 	tx := mysql.GetMySQL().
-		Select(`r.name, r.code`).
+		Select(`DISTINCT r.name, r.code`).
 		Table(`role_resource rr`).
-		Joins(`LEFT JOIN resource r ON r.code = rr.res_code`).
-		Where(`rr.role_no = ?`, roleNo).
+		Joins(`JOIN resource r ON r.code = rr.res_code OR (rr.res_code LIKE '%:*'
+			AND LEFT(r.code, LENGTH(rr.res_code) - 1) = LEFT(rr.res_code, LENGTH(rr.res_code) - 1))`).
+		Where(`rr.role_no IN ?`, distinctRoleNos(effective)).
+		Where(`rr.effect = ?`, ResEffectAllow).
+		Where(validGrantCond("rr"), validGrantArgs()...).
 		Scan(&res)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
-	if res == nil {
-		res = []ResBrief{}
+
+	denies, err := listRoleResDenies(ec, effective)
+	if err != nil {
+		return nil, err
 	}
-	return res, nil
+	allowed := make([]ResBrief, 0, len(res))
+	for _, r := range res {
+		if !resDenied(denies, r.Code) {
+			allowed = append(allowed, r)
+		}
+	}
+	return allowed, nil
+}
+
+// List codes of deny rules that are bound to the roles, only valid grants are included.
+func listRoleResDenies(rail miso.Rail, roleNos []string) ([]string, error) {
+	var denies []string
+	if len(roleNos) < 1 {
+		return denies, nil
+	}
+	err := mysql.GetMySQL().
+		Raw(`select distinct res_code from role_resource rr where role_no in ? and effect = ? and `+validGrantCond("rr"),
+			append([]any{roleNos, ResEffectDeny}, validGrantArgs()...)...).
+		Scan(&denies).Error
+	return denies, err
+}
+
+// List all resources as a tree based on the colon-separated namespaces of resource codes.
+func ListResTree(rail miso.Rail) ([]ResTreeNode, error) {
+	res, err := ListAllResBriefs(rail)
+	if err != nil {
+		return nil, err
+	}
+	return buildResTree(res), nil
 }
 
 func ListAllResBriefs(rail miso.Rail) ([]ResBrief, error) {
@@ -385,8 +471,23 @@ func ListResources(ec miso.Rail, req ListResReq) (ListResResp, error) {
 	return ListResResp{Paging: miso.RespPage(req.Paging, count), Payload: resources}, nil
 }
 
+func checkPathResMode(mode string) error {
+	if mode != "" && mode != PathResModeAny && mode != PathResModeAll {
+		return miso.NewErrf("Invalid resMode, should be either ANY or ALL")
+	}
+	return nil
+}
+
 func UpdatePath(ec miso.Rail, req UpdatePathReq) error {
+	req.ResMode = strings.ToUpper(strings.TrimSpace(req.ResMode))
+	if err := checkPathResMode(req.ResMode); err != nil {
+		return err
+	}
 	_, e := lockPath(ec, req.PathNo, func() (any, error) {
+		if req.ResMode != "" {
+			return nil, mysql.GetMySQL().Exec(`update path set pgroup = ?, ptype = ?, res_mode = ? where path_no = ?`,
+				req.Group, req.Type, req.ResMode, req.PathNo).Error
+		}
 		tx := mysql.GetMySQL().Exec(`update path set pgroup = ?, ptype = ? where path_no = ?`,
 			req.Group, req.Type, req.PathNo)
 		return nil, tx.Error
@@ -402,14 +503,14 @@ func loadOnePathResCacheAsync(rail miso.Rail, pathNo string) {
 	commonPool.Go(func() {
 		rail := rail.NextSpan()
 		// ec.Infof("Refreshing path cache, pathNo: %s", pathNo)
-		ep, e := findPathRes(pathNo)
+		eps, e := findPathRes(pathNo)
 		if e != nil {
 			rail.Errorf("Failed to reload path cache, pathNo: %s, %v", pathNo, e)
 			return
 		}
 
-		ep.Url = preprocessUrl(ep.Url)
-		if e := urlResCache.Put(rail, ep.Method+":"+ep.Url, toCachedUrlRes(ep)); e != nil {
+		cur := toCachedUrlRes(eps)[0]
+		if e := urlResCache.Put(rail, cur.Method+":"+cur.Url, cur); e != nil {
 			rail.Errorf("Failed to save cached url resource, pathNo: %s, %v", pathNo, e)
 			return
 		}
@@ -473,9 +574,32 @@ func CreatePath(rail miso.Rail, req CreatePathReq, user common.User) error {
 	req.Url = preprocessUrl(req.Url)
 	req.Group = strings.TrimSpace(req.Group)
 	req.Method = strings.ToUpper(strings.TrimSpace(req.Method))
+	req.ResMode = strings.ToUpper(strings.TrimSpace(req.ResMode))
+	if err := checkPathResMode(req.ResMode); err != nil {
+		return err
+	}
+	if isAmbiguousUrl(req.Url) {
+		return miso.NewErrf("Invalid url, dot segments, empty segments, backslashes and encoded slashes or dots are not allowed")
+	}
 	pathNo := genPathNo(req.Group, req.Url, req.Method)
+	template := isPathTemplate(req.Url)
+	if template {
+		if err := validatePathTemplate(req.Url); err != nil {
+			return err
+		}
+	}
 
 	changed, err := lockPath(rail, pathNo, func() (bool, error) {
+		if template {
+			conflicted, err := findConflictedPathTemplate(req.Method, req.Url, pathNo)
+			if err != nil {
+				return false, err
+			}
+			if conflicted != "" {
+				return false, miso.NewErrf("Path template '%s %s' conflicts with existing one '%s'", req.Method, req.Url, conflicted)
+			}
+		}
+
 		var prev EPath
 		tx := mysql.GetMySQL().Raw(`select * from path where path_no = ? limit 1`, pathNo).Scan(&prev)
 		if tx.Error != nil {
@@ -490,15 +614,28 @@ func CreatePath(rail miso.Rail, req CreatePathReq, user common.User) error {
 					return false, err
 				}
 			}
+			if req.ResMode != "" && prev.ResMode != req.ResMode {
+				err := mysql.GetMySQL().Exec(`UPDATE path SET res_mode = ? WHERE path_no = ?`, req.ResMode, pathNo).Error
+				if err != nil {
+					rail.Errorf("failed to update path.res_mode, pathNo: %v, %v", pathNo, err)
+					return false, err
+				}
+				return true, nil
+			}
 			return false, nil
 		}
 
+		if req.ResMode == "" {
+			req.ResMode = PathResModeAny
+		}
+
 		ep := EPath{
 			Url:      req.Url,
 			Desc:     req.Desc,
 			Ptype:    req.Type,
 			Pgroup:   req.Group,
 			Method:   req.Method,
+			ResMode:  req.ResMode,
 			PathNo:   pathNo,
 			CreateBy: user.Username,
 			UpdateBy: user.Username,
@@ -520,6 +657,9 @@ func CreatePath(rail miso.Rail, req CreatePathReq, user common.User) error {
 
 	if changed { // reload cache for the path
 		loadOnePathResCacheAsync(rail, pathNo)
+		if template {
+			bumpPathTemplateVersion(rail)
+		}
 	}
 
 	if req.ResCode != "" { // rebind path and resource
@@ -543,23 +683,25 @@ func DeletePath(ec miso.Rail, req DeletePathReq) error {
 
 		return nil, er
 	})
+	if e == nil {
+		bumpPathTemplateVersion(ec)
+	}
 	return e
 }
 
 func UnbindPathRes(ec miso.Rail, req UnbindPathResReq) error {
 	req.PathNo = strings.TrimSpace(req.PathNo)
 	_, e := lockPath(ec, req.PathNo, func() (any, error) {
+		if req.ResCode != "" {
+			return nil, mysql.GetMySQL().Exec(`delete from path_resource where path_no = ? and res_code = ?`, req.PathNo, req.ResCode).Error
+		}
 		tx := mysql.GetMySQL().Exec(`delete from path_resource where path_no = ?`, req.PathNo)
 		return nil, tx.Error
 	})
 
-	if e != nil {
+	if e == nil {
 		// asynchronously reload the cache of paths and resources
-		commonPool.Go(func() {
-			if e := LoadPathResCache(ec); e != nil {
-				ec.Errorf("Failed to load path resource cache, %v", e)
-			}
-		})
+		loadOnePathResCacheAsync(ec, req.PathNo)
 	}
 	return e
 }
@@ -643,6 +785,30 @@ func ListPaths(ec miso.Rail, req ListPathReq) (ListPathResp, error) {
 	if tx.Error != nil {
 		return ListPathResp{}, tx.Error
 	}
+	if paths == nil {
+		paths = []WPath{}
+	}
+	if len(paths) > 0 {
+		pathNos := make([]string, 0, len(paths))
+		for _, p := range paths {
+			pathNos = append(pathNos, p.PathNo)
+		}
+		var prs []PathRes
+		tx = mysql.GetMySQL().Raw(`SELECT path_no, res_code FROM path_resource WHERE path_no IN ?`, pathNos).Scan(&prs)
+		if tx.Error != nil {
+			return ListPathResp{}, tx.Error
+		}
+		codes := map[string][]string{}
+		for _, pr := range prs {
+			codes[pr.PathNo] = append(codes[pr.PathNo], pr.ResCode)
+		}
+		for i := range paths {
+			paths[i].ResCodes = codes[paths[i].PathNo]
+			if paths[i].ResCodes == nil {
+				paths[i].ResCodes = []string{}
+			}
+		}
+	}
 
 	var count int
 	tx = mysql.GetMySQL().
@@ -660,41 +826,102 @@ func ListPaths(ec miso.Rail, req ListPathReq) (ListPathResp, error) {
 }
 
 func AddRole(ec miso.Rail, req AddRoleReq, user common.User) error {
-	_, e := redis.RLockRun(ec, "user-vault:role:add"+req.Name, func() (any, error) {
+	if req.ParentRoleNo != "" {
+		if req.ParentRoleNo == DefaultAdminRoleNo {
+			return miso.NewErrf("Administrator role cannot be part of role hierarchy")
+		}
+		if _, err := GetRoleInfo(ec, api.RoleInfoReq{RoleNo: req.ParentRoleNo}); err != nil {
+			return err
+		}
+		// the new role inherits resources of the parent, like UpdateRoleParent
+		if err := checkRoleResGrantable(ec, mysql.GetMySQL(), user, req.ParentRoleNo, ""); err != nil {
+			return err
+		}
+		if err := checkRoleResCopyable(ec, mysql.GetMySQL(), user, req.ParentRoleNo); err != nil {
+			return err
+		}
+	}
+
+	_, e := lockRoleName(ec, req.Name, func() (any, error) {
 		r := ERole{
-			RoleNo:   util.GenIdP("role_"),
-			Name:     req.Name,
-			CreateBy: user.Username,
-			UpdateBy: user.Username,
+			RoleNo:       util.GenIdP("role_"),
+			Name:         req.Name,
+			ParentRoleNo: req.ParentRoleNo,
+			CreateBy:     user.Username,
+			UpdateBy:     user.Username,
 		}
-		return nil, mysql.GetMySQL().
+		err := mysql.GetMySQL().
 			Table("role").
 			Omit("Id", "CreateTime", "UpdateTime").
 			Create(&r).Error
+		if err != nil || r.ParentRoleNo == "" {
+			return nil, err
+		}
+
+		// inherited resources
+		parents, err := loadRoleParents(ec)
+		if err != nil {
+			return nil, err
+		}
+		return nil, _loadResOfRole(ec, parents, r.RoleNo)
 	})
 	return e
 }
 
-func RemoveResFromRole(ec miso.Rail, req RemoveRoleResReq) error {
+func RemoveResFromRole(ec miso.Rail, req RemoveRoleResReq, user common.User) error {
+	if err := checkRoleResRemovable(ec, mysql.GetMySQL(), user, req.RoleNo, req.ResCode); err != nil {
+		return err
+	}
+
 	_, e := redis.RLockRun(ec, "user-vault:role:"+req.RoleNo, func() (any, error) {
 		tx := mysql.GetMySQL().Exec(`delete from role_resource where role_no = ? and res_code = ?`, req.RoleNo, req.ResCode)
 		return nil, tx.Error
 	})
-
 	if e != nil {
-		e = roleResCache.Put(ec, fmt.Sprintf("role:%s:res:%s", req.RoleNo, req.ResCode), "")
+		return e
 	}
 
-	return e
+	// the role and its descendants may still have the resource inherited from other ancestors
+	return reloadRoleResCacheOfDescendants(ec, req.RoleNo, req.ResCode)
 }
 
+// Bind resource to role, if the resource is bound already, the validity period of the grant is updated.
 func AddResToRoleIfNotExist(rail miso.Rail, req AddRoleResReq, user common.User) error {
+	if err := checkGrantValidity(req.ValidFrom, req.ValidUntil, util.Now()); err != nil {
+		return err
+	}
+	if req.Effect != "" {
+		if err := checkResEffect(req.Effect); err != nil {
+			return err
+		}
+	}
+	if req.Condition = strings.TrimSpace(req.Condition); req.Condition != "" {
+		if err := validateGrantCond(req.Condition); err != nil {
+			return err
+		}
+	}
+	if err := checkRoleResGrantable(rail, mysql.GetMySQL(), user, req.RoleNo, req.ResCode); err != nil {
+		return err
+	}
+	return addResToRole(rail, req, user)
+}
+
+func addResToRole(rail miso.Rail, req AddRoleResReq, user common.User) error {
+	if req.Effect == "" {
+		req.Effect = ResEffectAllow
+	}
 
 	res, e := redis.RLockRun(rail, "user-vault:role:"+req.RoleNo, func() (any, error) { // lock for role
 		return lockResourceGlobal(rail, func() (any, error) {
-			// check if resource exist
+			// check if resource exist, wildcard pattern should match at least one resource
 			var resId int
-			tx := mysql.GetMySQL().Raw(`select id from resource where code = ?`, req.ResCode).Scan(&resId)
+			var tx *gorm.DB
+			if isResCodePattern(req.ResCode) {
+				prefix := strings.TrimSuffix(req.ResCode, resCodeWildcard)
+				tx = mysql.GetMySQL().Raw(`select id from resource where left(code, ?) = ? limit 1`, len(prefix), prefix).Scan(&resId)
+			} else {
+				tx = mysql.GetMySQL().Raw(`select id from resource where code = ?`, req.ResCode).Scan(&resId)
+			}
 			if tx.Error != nil {
 				return false, tx.Error
 			}
@@ -708,16 +935,23 @@ func AddResToRoleIfNotExist(rail miso.Rail, req AddRoleResReq, user common.User)
 			if tx.Error != nil {
 				return false, tx.Error
 			}
-			if id > 0 { // relation exists already
-				return false, nil
+			if id > 0 { // relation exists already, update the effect, condition and validity period
+				return true, mysql.GetMySQL().
+					Exec(`update role_resource set effect = ?, res_condition = ?, valid_from = ?, valid_until = ?, expiry_notified = 0, update_by = ? where id = ?`,
+						req.Effect, req.Condition, req.ValidFrom, req.ValidUntil, user.Username, id).
+					Error
 			}
 
 			// create role-resource relation
 			rr := ERoleRes{
-				RoleNo:   req.RoleNo,
-				ResCode:  req.ResCode,
-				CreateBy: user.Username,
-				UpdateBy: user.Username,
+				RoleNo:       req.RoleNo,
+				ResCode:      req.ResCode,
+				Effect:       req.Effect,
+				ResCondition: req.Condition,
+				ValidFrom:    req.ValidFrom,
+				ValidUntil:   req.ValidUntil,
+				CreateBy:     user.Username,
+				UpdateBy:     user.Username,
 			}
 
 			return true, mysql.GetMySQL().
@@ -732,18 +966,26 @@ func AddResToRoleIfNotExist(rail miso.Rail, req AddRoleResReq, user common.User)
 	}
 
 	if isAdded := res.(bool); isAdded {
-		e = _loadResOfRole(rail, req.RoleNo)
+		e = reloadRoleResCacheOfDescendants(rail, req.RoleNo, req.ResCode)
 	}
 
 	return e
 }
 
 func ListRoleRes(ec miso.Rail, req ListRoleResReq) (ListRoleResResp, error) {
+	parents, err := loadRoleParents(ec)
+	if err != nil {
+		return ListRoleResResp{}, err
+	}
+	ancestors := parents.ancestors(req.RoleNo)
+
+	// direct grants come first
 	var res []ListedRoleRes
 	tx := mysql.GetMySQL().
-		Raw(`select rr.id, rr.res_code, rr.create_time, rr.create_by, r.name 'res_name' from role_resource rr
+		Raw(`select rr.id, rr.res_code, rr.effect, rr.res_condition, rr.role_no 'inherited_from', rr.valid_from, rr.valid_until, rr.create_time, rr.create_by, r.name 'res_name' from role_resource rr
 			left join resource r on rr.res_code = r.code
-			where rr.role_no = ? order by rr.id desc limit ?, ?`, req.RoleNo, req.Paging.GetOffset(), req.Paging.GetLimit()).
+			where rr.role_no in ? order by rr.role_no = ? desc, rr.id desc limit ?, ?`,
+			ancestors, req.RoleNo, req.Paging.GetOffset(), req.Paging.GetLimit()).
 		Scan(&res)
 
 	if tx.Error != nil {
@@ -753,12 +995,25 @@ func ListRoleRes(ec miso.Rail, req ListRoleResReq) (ListRoleResResp, error) {
 	if res == nil {
 		res = []ListedRoleRes{}
 	}
+	denies, err := listRoleResDenies(ec, ancestors)
+	if err != nil {
+		return ListRoleResResp{}, err
+	}
+	for i := range res {
+		res[i].Wildcard = isResCodePattern(res[i].ResCode)
+		res[i].Overridden = res[i].Effect == ResEffectAllow && resDenied(denies, res[i].ResCode)
+		if res[i].InheritedFrom == req.RoleNo {
+			res[i].InheritedFrom = ""
+		} else {
+			res[i].Inherited = true
+		}
+	}
 
 	var count int
 	tx = mysql.GetMySQL().
 		Raw(`select count(*) from role_resource rr
 			left join resource r on rr.res_code = r.code
-			where rr.role_no = ?`, req.RoleNo).
+			where rr.role_no in ?`, ancestors).
 		Scan(&count)
 
 	if tx.Error != nil {
@@ -768,9 +1023,10 @@ func ListRoleRes(ec miso.Rail, req ListRoleResReq) (ListRoleResResp, error) {
 	return ListRoleResResp{Payload: res, Paging: miso.Paging{Limit: req.Paging.Limit, Page: req.Paging.Page, Total: count}}, nil
 }
 
+// List briefs of all roles, personal roles are excluded.
 func ListAllRoleBriefs(ec miso.Rail) ([]RoleBrief, error) {
 	var roles []RoleBrief
-	tx := mysql.GetMySQL().Raw("select role_no, name from role").Scan(&roles)
+	tx := mysql.GetMySQL().Raw("select role_no, name from role where owner_user_no = ''").Scan(&roles)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -780,10 +1036,11 @@ func ListAllRoleBriefs(ec miso.Rail) ([]RoleBrief, error) {
 	return roles, nil
 }
 
+// List roles, personal roles are excluded.
 func ListRoles(ec miso.Rail, req ListRoleReq) (ListRoleResp, error) {
 	var roles []WRole
 	tx := mysql.GetMySQL().
-		Raw("select * from role order by id desc limit ?, ?", req.Paging.GetOffset(), req.Paging.GetLimit()).
+		Raw("select * from role where owner_user_no = '' order by id desc limit ?, ?", req.Paging.GetOffset(), req.Paging.GetLimit()).
 		Scan(&roles)
 	if tx.Error != nil {
 		return ListRoleResp{}, tx.Error
@@ -793,7 +1050,7 @@ func ListRoles(ec miso.Rail, req ListRoleReq) (ListRoleResp, error) {
 	}
 
 	var count int
-	tx = mysql.GetMySQL().Raw("select count(*) from role").Scan(&count)
+	tx = mysql.GetMySQL().Raw("select count(*) from role where owner_user_no = ''").Scan(&count)
 	if tx.Error != nil {
 		return ListRoleResp{}, tx.Error
 	}
@@ -804,7 +1061,6 @@ func ListRoles(ec miso.Rail, req ListRoleReq) (ListRoleResp, error) {
 // Test access to resource
 func TestResourceAccess(ec miso.Rail, req TestResAccessReq) (TestResAccessResp, error) {
 	url := req.Url
-	roleNo := req.RoleNo
 
 	// some sanitization & standardization for the url
 	url = preprocessUrl(url)
@@ -817,49 +1073,105 @@ func TestResourceAccess(ec miso.Rail, req TestResAccessReq) (TestResAccessResp,
 		return forbidden, nil
 	}
 
+	roleNos := distinctRoleNos(append([]string{req.RoleNo}, req.RoleNos...))
+	attrs := &accessAttrs{ClientIp: req.ClientIp, LoginMethod: req.LoginMethod, Username: req.Username, Now: time.Now()}
+
+	// resources, their patterns and deny rules of all roles are read in one pipeline
+	get := getRoleResCache
+	if cur.Ptype != PathTypePublic {
+		if get, e = prefetchRoleResCache(roleResCacheKeys(roleNos, cur.ResCodes)); e != nil {
+			return forbidden, e
+		}
+	}
+	return checkUrlAccess(ec, get, cur, url, roleNos, attrs)
+}
+
+// Check whether the roles have access to the path.
+func checkUrlAccess(ec miso.Rail, get roleResGetter, cur CachedUrlRes, url string, roleNos []string, attrs *accessAttrs) (TestResAccessResp, error) {
 	// public path type, doesn't require access to resource
 	if cur.Ptype == PathTypePublic {
 		return permitted, nil
 	}
 
 	// doesn't even have role
-	roleNo = strings.TrimSpace(roleNo)
-	if roleNo == "" {
+	if len(roleNos) < 1 {
 		ec.Infof("Rejected '%s', user doesn't have roleNo", url)
 		return forbidden, nil
 	}
 
-	// the requiredRes resources no
-	requiredRes := cur.ResCode
-	if requiredRes == "" {
+	// the required resources
+	if len(cur.ResCodes) < 1 {
 		ec.Infof("Rejected '%s', path doesn't have any resource bound yet", url)
 		return forbidden, nil
 	}
 
-	ok, e := checkRoleRes(ec, roleNo, requiredRes)
+	// any of the roles has access to the required resources, and none of them denies it
+	ok, e := checkPathRes(ec, get, cur, roleNos, attrs)
 	if e != nil {
 		return forbidden, e
 	}
-
-	// the role doesn't have access to the required resource
-	if !ok {
-		ec.Infof("Rejected '%s', roleNo: '%s', role doesn't have access to required resource '%s'", url, roleNo, requiredRes)
-		return forbidden, nil
+	if ok {
+		return permitted, nil
 	}
 
-	return permitted, nil
+	ec.Infof("Rejected '%s', roleNos: %v, roles don't have access to required resources %v (%v)", url, roleNos, cur.ResCodes, cur.ResMode)
+	return forbidden, nil
+}
+
+// Check whether the roles have access to the resources of the path, either any of them or all of them
+// based on the ResMode.
+func checkPathRes(rail miso.Rail, get roleResGetter, cur CachedUrlRes, roleNos []string, attrs *accessAttrs) (bool, error) {
+	all := cur.ResMode == PathResModeAll
+	for _, code := range cur.ResCodes {
+		ok, e := checkRolesResWith(rail, get, roleNos, code, attrs)
+		if e != nil {
+			return false, e
+		}
+		if ok != all {
+			return ok, nil
+		}
+	}
+	return all, nil
 }
 
-func checkRoleRes(rail miso.Rail, roleNo string, resCode string) (bool, error) {
+// Check whether the role has access to the resource, attrs is nil if the check is not bound to a request,
+// in which case, conditional grants are not applied.
+func checkRoleRes(rail miso.Rail, get roleResGetter, roleNo string, resCode string, attrs *accessAttrs) (bool, error) {
 	if roleNo == DefaultAdminRoleNo {
 		return true, nil
 	}
 
-	ok, e := roleResCache.Exists(rail, fmt.Sprintf("role:%s:res:%s", roleNo, resCode))
+	// patterns are cached as they are, e.g., 'postbox:notification:*' and 'postbox:*'
+	for _, c := range append([]string{resCode}, resCodePatterns(resCode)...) {
+		ok, e := lookupRoleRes(rail, get, roleNo, c, attrs, false)
+		if e != nil || ok {
+			return ok, e
+		}
+	}
+	return false, nil
+}
+
+// Reads roleResCache by key, returns false if the key is absent.
+type roleResGetter func(rail miso.Rail, key string) (string, bool, error)
+
+func getRoleResCache(rail miso.Rail, key string) (string, bool, error) {
+	v, e := roleResCache.Get(rail, key, nil)
 	if e != nil {
+		if miso.IsNoneErr(e) {
+			return "", false, nil
+		}
+		return "", false, e
+	}
+	return v, true, nil
+}
+
+// Lookup roleResCache and evaluate the condition of the grant.
+func lookupRoleRes(rail miso.Rail, get roleResGetter, roleNo string, code string, attrs *accessAttrs, deny bool) (bool, error) {
+	v, ok, e := get(rail, roleResCacheKey(roleNo, code))
+	if e != nil || !ok {
 		return false, e
 	}
-	return ok, nil
+	return evalRoleResCond(rail, v, attrs, deny), nil
 }
 
 // Load cache for role -> resources
@@ -867,13 +1179,13 @@ func LoadRoleResCache(ec miso.Rail) error {
 
 	_, e := lockRoleResCache(ec, func() (any, error) {
 
-		lr, e := listRoleNos(ec)
+		parents, e := loadRoleParents(ec)
 		if e != nil {
 			return nil, e
 		}
 
-		for _, roleNo := range lr {
-			e = _loadResOfRole(ec, roleNo)
+		for roleNo := range parents {
+			e = _loadResOfRole(ec, parents, roleNo)
 			if e != nil {
 				return nil, e
 			}
@@ -883,50 +1195,46 @@ func LoadRoleResCache(ec miso.Rail) error {
 	return e
 }
 
-func _loadResOfRole(ec miso.Rail, roleNo string) error {
-	roleResList, e := listRoleRes(ec, roleNo)
+// Load effective resources of role, including the ones inherited from ancestors.
+func _loadResOfRole(ec miso.Rail, parents roleParents, roleNo string) error {
+	codes, e := listEffectiveRoleResCodes(ec, parents, roleNo)
 	if e != nil {
 		return e
 	}
 
-	for _, rr := range roleResList {
-		roleResCache.Put(ec, fmt.Sprintf("role:%s:res:%s", rr.RoleNo, rr.ResCode), "1")
+	for code, v := range codes {
+		roleResCache.Put(ec, roleResCacheKey(roleNo, code), v)
 	}
 	return nil
 }
 
-func listRoleNos(ec miso.Rail) ([]string, error) {
-	var ern []string
-	t := mysql.GetMySQL().Raw("select role_no from role").Scan(&ern)
-	if t.Error != nil {
-		return nil, t.Error
-	}
-
-	if ern == nil {
-		ern = []string{}
-	}
-	return ern, nil
+func roleResCacheKey(roleNo string, resCode string) string {
+	return fmt.Sprintf("role:%s:res:%s", roleNo, resCode)
 }
 
-func listRoleRes(ec miso.Rail, roleNo string) ([]ERoleRes, error) {
-	var rr []ERoleRes
-	t := mysql.GetMySQL().Raw("select * from role_resource where role_no = ?", roleNo).Scan(&rr)
-	if t.Error != nil {
-		if errors.Is(t.Error, gorm.ErrRecordNotFound) {
-			return []ERoleRes{}, nil
-		}
-		return nil, t.Error
+// Lookup resource of the url, exact match wins over path templates.
+func lookupUrlRes(ec miso.Rail, url string, method string) (CachedUrlRes, error) {
+	// the backend may resolve the url to another path, e.g., '/static/../admin' to '/admin'
+	if isAmbiguousUrl(url) {
+		return CachedUrlRes{}, miso.NoneErr
 	}
 
-	return rr, nil
-}
-
-func lookupUrlRes(ec miso.Rail, url string, method string) (CachedUrlRes, error) {
 	cur, e := urlResCache.Get(ec, method+":"+url, nil)
-	if e != nil {
+	if e == nil {
+		return cur, nil
+	}
+	if !miso.IsNoneErr(e) {
+		return CachedUrlRes{}, e
+	}
+
+	template, ok, te := pathTemplates.match(ec, method, url)
+	if te != nil {
+		return CachedUrlRes{}, te
+	}
+	if !ok {
 		return CachedUrlRes{}, e
 	}
-	return cur, nil
+	return urlResCache.Get(ec, method+":"+template, nil)
 }
 
 // Load cache for path -> resource
@@ -944,9 +1252,8 @@ func LoadPathResCache(rail miso.Rail) error {
 			return nil, nil
 		}
 
-		for _, ep := range paths {
-			ep.Url = preprocessUrl(ep.Url)
-			if e := urlResCache.Put(rail, ep.Method+":"+ep.Url, toCachedUrlRes(ep)); e != nil {
+		for _, cur := range toCachedUrlRes(paths) {
+			if e := urlResCache.Put(rail, cur.Method+":"+cur.Url, cur); e != nil {
 				return nil, fmt.Errorf("failed to store urlResCache, %w", e)
 			}
 		}
@@ -956,17 +1263,35 @@ func LoadPathResCache(rail miso.Rail) error {
 	return e
 }
 
-func toCachedUrlRes(epath ExtendedPathRes) CachedUrlRes {
-	cur := CachedUrlRes{
-		Id:      epath.Id,
-		Pgroup:  epath.Pgroup,
-		PathNo:  epath.PathNo,
-		ResCode: epath.ResCode,
-		Url:     epath.Url,
-		Method:  epath.Method,
-		Ptype:   epath.Ptype,
+// Group the rows of path and resource by path, each path may be bound to multiple resources.
+func toCachedUrlRes(epaths []ExtendedPathRes) []CachedUrlRes {
+	idx := map[string]int{}
+	curs := make([]CachedUrlRes, 0, len(epaths))
+	for _, ep := range epaths {
+		i, ok := idx[ep.PathNo]
+		if !ok {
+			i = len(curs)
+			idx[ep.PathNo] = i
+			mode := ep.ResMode
+			if mode == "" {
+				mode = PathResModeAny
+			}
+			curs = append(curs, CachedUrlRes{
+				Id:       ep.Id,
+				Pgroup:   ep.Pgroup,
+				PathNo:   ep.PathNo,
+				ResCodes: []string{},
+				ResMode:  mode,
+				Url:      preprocessUrl(ep.Url),
+				Method:   ep.Method,
+				Ptype:    ep.Ptype,
+			})
+		}
+		if ep.ResCode != "" && !slices.Contains(curs[i].ResCodes, ep.ResCode) {
+			curs[i].ResCodes = append(curs[i].ResCodes, ep.ResCode)
+		}
 	}
-	return cur
+	return curs
 }
 
 // preprocess url, the processed url will always starts with '/' and never ends with '/'
@@ -1000,10 +1325,32 @@ func preprocessUrl(url string) string {
 	return string(ru)
 }
 
-func findPathRes(pathNo string) (ExtendedPathRes, error) {
-	var ep ExtendedPathRes
+// Check whether the url may be resolved to another path by the backend, i.e., the url contains '.' or '..'
+// segments, empty segments, backslashes, or percent-encoded slashes, backslashes or dots.
+//
+// The url should be preprocessed by preprocessUrl.
+func isAmbiguousUrl(url string) bool {
+	if strings.Contains(url, "\\") {
+		return true
+	}
+	lower := strings.ToLower(url)
+	for _, enc := range []string{"%2f", "%5c", "%2e"} {
+		if strings.Contains(lower, enc) {
+			return true
+		}
+	}
+	for _, s := range splitPath(url) {
+		if s == "" || s == "." || s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func findPathRes(pathNo string) ([]ExtendedPathRes, error) {
+	var ep []ExtendedPathRes
 	tx := mysql.GetMySQL().
-		Raw("select p.*, pr.res_code from path p left join path_resource pr on p.path_no = pr.path_no where p.path_no = ? limit 1", pathNo).
+		Raw("select p.*, pr.res_code from path p left join path_resource pr on p.path_no = pr.path_no where p.path_no = ?", pathNo).
 		Scan(&ep)
 	if tx.Error != nil {
 		return ep, tx.Error
@@ -132,6 +132,56 @@ func TestPreprocessUrl(t *testing.T) {
 	}
 }
 
+func TestIsAmbiguousUrl(t *testing.T) {
+	for _, u := range []string{"/static/../admin/user", "/static/./js", "/static//js", "/static/%2e%2e/admin",
+		"/static/..%2Fadmin", "/static/..%2fadmin", "/static/..%5cadmin", "/static/..\\admin", "/static/.."} {
+		if !isAmbiguousUrl(preprocessUrl(u)) {
+			t.Fatalf("%v should be ambiguous", u)
+		}
+	}
+	for _, u := range []string{"/", "/static/js/app.js", "/static/app.min.js", "/static/..js", "/static/js/"} {
+		if isAmbiguousUrl(preprocessUrl(u)) {
+			t.Fatalf("%v should not be ambiguous", u)
+		}
+	}
+
+	// ambiguous urls are rejected before the caches are looked up
+	rail := miso.EmptyRail()
+	for _, u := range []string{"/static/../admin/user", "/static/..%2Fadmin/user"} {
+		if _, err := lookupUrlRes(rail, preprocessUrl(u), "GET"); !miso.IsNoneErr(err) {
+			t.Fatalf("%v should not be found, %v", u, err)
+		}
+	}
+}
+
+func TestToCachedUrlRes(t *testing.T) {
+	curs := toCachedUrlRes([]ExtendedPathRes{
+		{PathNo: "p1", Url: "/a/", Method: "GET", ResCode: "r1"},
+		{PathNo: "p1", Url: "/a/", Method: "GET", ResCode: "r2"},
+		{PathNo: "p2", Url: "/b", Method: "GET"},
+	})
+	if len(curs) != 2 {
+		t.Fatal(curs)
+	}
+	if curs[0].Url != "/a" || len(curs[0].ResCodes) != 2 || curs[0].ResMode != PathResModeAny {
+		t.Fatal(curs[0])
+	}
+	if len(curs[1].ResCodes) != 0 || curs[1].ResMode != PathResModeAny {
+		t.Fatal(curs[1])
+	}
+}
+
+func TestCheckPathRes(t *testing.T) {
+	rail := miso.EmptyRail()
+	for _, mode := range []string{PathResModeAny, PathResModeAll} {
+		cur := CachedUrlRes{ResCodes: []string{"r1", "r2"}, ResMode: mode}
+		ok, err := checkPathRes(rail, getRoleResCache, cur, []string{DefaultAdminRoleNo}, nil)
+		if err != nil || !ok {
+			t.Fatal(mode, ok, err)
+		}
+	}
+}
+
 func TestUnbindPathRes(t *testing.T) {
 	before(t)
 
@@ -189,7 +239,7 @@ func TestRemoveResFromRole(t *testing.T) {
 		ResCode: "res_555323073019904208429",
 	}
 
-	e := RemoveResFromRole(miso.EmptyRail(), req)
+	e := RemoveResFromRole(miso.EmptyRail(), req, common.NilUser())
 	if e != nil {
 		t.Fatal(e)
 	}
@@ -0,0 +1,265 @@
+package vault
+
+import (
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+type UpdateRoleReq struct {
+	RoleNo string `json:"roleNo" validation:"notEmpty"`
+	Name   string `json:"name" validation:"notEmpty,maxLen:32"`
+}
+
+type CloneRoleReq struct {
+	RoleNo string `json:"roleNo" validation:"notEmpty" desc:"role to clone from"`
+	Name   string `json:"name" validation:"notEmpty,maxLen:32" desc:"name of the new role"`
+}
+
+type CloneRoleRes struct {
+	RoleNo string `json:"roleNo"`
+}
+
+type DeleteRoleReq struct {
+	RoleNo            string `json:"roleNo" validation:"notEmpty"`
+	ReplacementRoleNo string `json:"replacementRoleNo" desc:"role assigned to users and groups that still hold the deleted role"`
+}
+
+// Rename role, the administrator role and personal roles cannot be renamed.
+func UpdateRole(rail miso.Rail, req UpdateRoleReq, user common.User) error {
+	if req.RoleNo == DefaultAdminRoleNo {
+		return miso.NewErrf("Administrator role cannot be renamed")
+	}
+	if err := checkRoleResGrantable(rail, mysql.GetMySQL(), user, req.RoleNo, ""); err != nil {
+		return err
+	}
+	_, err := lockRoleName(rail, req.Name, func() (any, error) {
+		return redis.RLockRun(rail, "user-vault:role:"+req.RoleNo, func() (any, error) {
+			var owner string
+			if err := mysql.GetMySQL().Raw(`select owner_user_no from role where role_no = ?`, req.RoleNo).Scan(&owner).Error; err != nil {
+				return nil, err
+			}
+			if owner != "" {
+				return nil, miso.NewErrf("Personal role cannot be renamed")
+			}
+			if err := checkRoleNameAvailable(mysql.GetMySQL(), req.Name, req.RoleNo); err != nil {
+				return nil, err
+			}
+			t := mysql.GetMySQL().Exec(`update role set name = ?, update_by = ? where role_no = ?`, req.Name, user.Username, req.RoleNo)
+			if t.Error != nil {
+				return nil, t.Error
+			}
+			if t.RowsAffected < 1 {
+				return nil, miso.NewErrf("Role not found").WithCode(ErrCodeRoleNotFound)
+			}
+			rail.Infof("Role %v renamed to '%v' by %v", req.RoleNo, req.Name, user.Username)
+
+			// role name is cached in user info as well
+			var usernames []string
+			if err := mysql.GetMySQL().Raw(`select username from user where role_no = ?`, req.RoleNo).Scan(&usernames).Error; err != nil {
+				return nil, err
+			}
+			for _, un := range usernames {
+				if err := InvalidateUserInfoCache(rail, un); err != nil {
+					rail.Errorf("Failed to invalidate user info cache, username: %v, %v", un, err)
+				}
+			}
+			return nil, roleInfoCache.Del(rail, req.RoleNo)
+		})
+	})
+	return err
+}
+
+// Create a new role with the same parent and resources of the given role, the name must not be used by other roles.
+func CloneRole(rail miso.Rail, req CloneRoleReq, user common.User) (CloneRoleRes, error) {
+	if req.RoleNo == DefaultAdminRoleNo {
+		return CloneRoleRes{}, miso.NewErrf("Administrator role cannot be cloned")
+	}
+	if err := checkRoleResGrantable(rail, mysql.GetMySQL(), user, req.RoleNo, ""); err != nil {
+		return CloneRoleRes{}, err
+	}
+	// the clone is not restricted by the scopes, all resources it has must be within the scopes
+	if err := checkRoleResCopyable(rail, mysql.GetMySQL(), user, req.RoleNo); err != nil {
+		return CloneRoleRes{}, err
+	}
+
+	var src ERole
+	t := mysql.GetMySQL().Raw(`select * from role where role_no = ?`, req.RoleNo).Scan(&src)
+	if t.Error != nil {
+		return CloneRoleRes{}, t.Error
+	}
+	if t.RowsAffected < 1 {
+		return CloneRoleRes{}, miso.NewErrf("Role not found").WithCode(ErrCodeRoleNotFound)
+	}
+
+	r := ERole{
+		RoleNo:       util.GenIdP("role_"),
+		Name:         req.Name,
+		ParentRoleNo: src.ParentRoleNo,
+		CreateBy:     user.Username,
+		UpdateBy:     user.Username,
+	}
+	_, err := lockRoleName(rail, req.Name, func() (any, error) {
+		if err := checkRoleNameAvailable(mysql.GetMySQL(), req.Name, ""); err != nil {
+			return nil, err
+		}
+		return nil, mysql.GetMySQL().Transaction(func(tx *gorm.DB) error {
+			err := tx.Table("role").
+				Omit("Id", "CreateTime", "UpdateTime").
+				Create(&r).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec(`insert into role_resource (role_no, res_code, effect, res_condition, valid_from, valid_until, create_by, update_by)
+				select ?, res_code, effect, res_condition, valid_from, valid_until, ?, ? from role_resource where role_no = ?`,
+				r.RoleNo, user.Username, user.Username, req.RoleNo).Error
+		})
+	})
+	if err != nil {
+		return CloneRoleRes{}, err
+	}
+	rail.Infof("Role %v cloned from %v by %v", r.RoleNo, req.RoleNo, user.Username)
+
+	parents, err := loadRoleParents(rail)
+	if err != nil {
+		return CloneRoleRes{}, err
+	}
+	if err := _loadResOfRole(rail, parents, r.RoleNo); err != nil {
+		return CloneRoleRes{}, err
+	}
+	return CloneRoleRes{RoleNo: r.RoleNo}, nil
+}
+
+// Delete role, users and groups that still hold the role are moved to the replacement role.
+func DeleteRole(rail miso.Rail, req DeleteRoleReq, user common.User) error {
+	if req.RoleNo == DefaultAdminRoleNo {
+		return miso.NewErrf("Administrator role cannot be deleted")
+	}
+	if req.ReplacementRoleNo == req.RoleNo {
+		return miso.NewErrf("Replacement role cannot be the deleted role")
+	}
+	if err := checkRoleResGrantable(rail, mysql.GetMySQL(), user, req.RoleNo, ""); err != nil {
+		return err
+	}
+	if req.ReplacementRoleNo != "" {
+		if _, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.ReplacementRoleNo}); err != nil {
+			return err
+		}
+		if err := checkRoleAssignable(rail, mysql.GetMySQL(), user, req.ReplacementRoleNo); err != nil {
+			return err
+		}
+	}
+
+	_, err := lockRoleResCache(rail, func() (any, error) {
+		return redis.RLockRun(rail, "user-vault:role:"+req.RoleNo, func() (any, error) {
+			parents, err := loadRoleParents(rail)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := parents[req.RoleNo]; !ok {
+				return nil, miso.NewErrf("Role not found").WithCode(ErrCodeRoleNotFound)
+			}
+			if children := parents.descendants(req.RoleNo); len(children) > 1 {
+				return nil, miso.NewErrf("Role is the parent of other roles, please update their parent first").
+					WithInternalMsg("Child roles: %v", children[1:])
+			}
+
+			type roleHolder struct {
+				UserNo   string
+				Username string
+			}
+			var holders []roleHolder
+			err = mysql.GetMySQL().Raw(`select user_no, username from user
+				where user_no in (select user_no from (`+userRoleUnionSql(userRoleByRole)+`) t)`, userRoleUnionArgs([]string{req.RoleNo})...).
+				Scan(&holders).Error
+			if err != nil {
+				return nil, err
+			}
+			var groups int
+			if err := mysql.GetMySQL().Raw(`select count(*) from user_group_role where role_no = ?`, req.RoleNo).Scan(&groups).Error; err != nil {
+				return nil, err
+			}
+			if (len(holders) > 0 || groups > 0) && req.ReplacementRoleNo == "" {
+				return nil, miso.NewErrf("Role is still held by %v users and %v groups, please specify a replacement role", len(holders), groups)
+			}
+
+			resCodes, err := listEffectiveRoleResCodes(rail, parents, req.RoleNo)
+			if err != nil {
+				return nil, err
+			}
+
+			err = mysql.GetMySQL().Transaction(func(tx *gorm.DB) error {
+				type stmt struct {
+					sql  string
+					args []any
+				}
+				stmts := []stmt{}
+				if req.ReplacementRoleNo != "" {
+					stmts = append(stmts,
+						stmt{`update user set role_no = ?, update_by = ? where role_no = ?`, []any{req.ReplacementRoleNo, user.Username, req.RoleNo}},
+						stmt{`update ignore user_role set role_no = ? where role_no = ?`, []any{req.ReplacementRoleNo, req.RoleNo}},
+						stmt{`delete ur from user_role ur join user u on ur.user_no = u.user_no where ur.role_no = u.role_no and ur.role_no = ?`, []any{req.ReplacementRoleNo}},
+						stmt{`update ignore user_group_role set role_no = ? where role_no = ?`, []any{req.ReplacementRoleNo, req.RoleNo}},
+					)
+				}
+				stmts = append(stmts,
+					stmt{`delete from user_role where role_no = ?`, []any{req.RoleNo}},
+					stmt{`delete from user_group_role where role_no = ?`, []any{req.RoleNo}},
+					stmt{`delete from role_resource where role_no = ?`, []any{req.RoleNo}},
+					stmt{`delete from role where role_no = ?`, []any{req.RoleNo}},
+				)
+				for _, s := range stmts {
+					if err := tx.Exec(s.sql, s.args...).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			rail.Infof("Role %v deleted by %v, %v users moved to role %v", req.RoleNo, user.Username, len(holders), req.ReplacementRoleNo)
+
+			if err := roleInfoCache.Del(rail, req.RoleNo); err != nil {
+				rail.Errorf("Failed to invalidate role info cache, roleNo: %v, %v", req.RoleNo, err)
+			}
+			for code := range resCodes {
+				if err := roleResCache.Del(rail, roleResCacheKey(req.RoleNo, code)); err != nil {
+					rail.Errorf("Failed to invalidate role resource cache, roleNo: %v, resCode: %v, %v", req.RoleNo, code, err)
+				}
+			}
+
+			// tokens carry the role, users have to login again to obtain the replacement role
+			for _, h := range holders {
+				if err := InvalidateUserInfoCache(rail, h.Username); err != nil {
+					rail.Errorf("Failed to invalidate user info cache, username: %v, %v", h.Username, err)
+				}
+				if err := RevokeUserTokens(rail, h.UserNo); err != nil {
+					rail.Errorf("Failed to revoke tokens of user %v, %v", h.UserNo, err)
+				}
+			}
+			return nil, nil
+		})
+	})
+	return err
+}
+
+// Check whether the name is not used by other roles, roleNo is the role to be renamed, it's empty for new roles.
+func checkRoleNameAvailable(db *gorm.DB, name string, roleNo string) error {
+	var id int
+	if err := db.Raw(`select id from role where name = ? and role_no != ? limit 1`, name, roleNo).Scan(&id).Error; err != nil {
+		return err
+	}
+	if id > 0 {
+		return miso.NewErrf("Role name '%v' is already used", name)
+	}
+	return nil
+}
+
+func lockRoleName(rail miso.Rail, name string, runnable redis.LRunnable[any]) (any, error) {
+	return redis.RLockRun(rail, "user-vault:role:add:"+name, runnable)
+}
@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+func TestCloneRole(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+	operator := common.User{UserNo: "test_operator", Username: "test_operator"}
+
+	parent := util.GenIdP("role_")
+	src := util.GenIdP("role_")
+	setup := [][]any{
+		{`INSERT INTO role (role_no, name) VALUES (?, ?)`, parent, "test_clone_parent"},
+		{`INSERT INTO role (role_no, name, parent_role_no) VALUES (?, ?, ?)`, src, "test_clone_src", parent},
+		{`INSERT INTO role_resource (role_no, res_code, effect) VALUES (?, ?, ?)`, src, "test:clone:allow", ResEffectAllow},
+		{`INSERT INTO role_resource (role_no, res_code, effect) VALUES (?, ?, ?)`, src, "test:clone:deny", ResEffectDeny},
+	}
+	for _, s := range setup {
+		if err := db.Exec(s[0].(string), s[1:]...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	cleanup := []string{src, parent}
+	defer func() {
+		db.Exec(`DELETE FROM role_resource WHERE role_no IN ?`, cleanup)
+		db.Exec(`DELETE FROM role WHERE role_no IN ?`, cleanup)
+	}()
+
+	res, err := CloneRole(rail, CloneRoleReq{RoleNo: src, Name: "test_clone_dst"}, operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = append(cleanup, res.RoleNo)
+
+	var cloned ERole
+	if err := db.Raw(`SELECT * FROM role WHERE role_no = ?`, res.RoleNo).Scan(&cloned).Error; err != nil {
+		t.Fatal(err)
+	}
+	if cloned.Name != "test_clone_dst" || cloned.ParentRoleNo != parent {
+		t.Fatalf("unexpected clone, %+v", cloned)
+	}
+	var grants []string
+	err = db.Raw(`SELECT concat(effect, ':', res_code) FROM role_resource WHERE role_no = ? ORDER BY res_code`, res.RoleNo).
+		Scan(&grants).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(grants, []string{ResEffectAllow + ":test:clone:allow", ResEffectDeny + ":test:clone:deny"}) {
+		t.Fatalf("unexpected resources, %v", grants)
+	}
+
+	// names are not reused
+	for _, name := range []string{"test_clone_src", "test_clone_dst"} {
+		if _, err := CloneRole(rail, CloneRoleReq{RoleNo: src, Name: name}, operator); err == nil {
+			t.Fatalf("name %v is used, clone should fail", name)
+		}
+	}
+	if err := UpdateRole(rail, UpdateRoleReq{RoleNo: res.RoleNo, Name: "test_clone_src"}, operator); err == nil {
+		t.Fatal("name test_clone_src is used, rename should fail")
+	}
+	if _, err := CloneRole(rail, CloneRoleReq{RoleNo: DefaultAdminRoleNo, Name: "test_clone_admin"}, operator); err == nil {
+		t.Fatal("administrator role should not be cloned")
+	}
+}
+
+func TestDeleteRole(t *testing.T) {
+	before(t)
+	rail := miso.EmptyRail()
+	db := mysql.GetMySQL()
+	operator := common.User{UserNo: "test_operator", Username: "test_operator"}
+
+	deleted := util.GenIdP("role_")
+	replacement := util.GenIdP("role_")
+	userNo := util.GenIdP("test_")
+	groupNo := util.GenIdP("test_")
+	setup := [][]any{
+		{`INSERT INTO role (role_no, name) VALUES (?, ?)`, deleted, "test_delete_role"},
+		{`INSERT INTO role (role_no, name) VALUES (?, ?)`, replacement, "test_delete_replacement"},
+		{`INSERT INTO role_resource (role_no, res_code, effect) VALUES (?, ?, ?)`, deleted, "test:delete", ResEffectAllow},
+		{`INSERT INTO user_group_role (group_no, role_no) VALUES (?, ?)`, groupNo, deleted},
+	}
+	for _, s := range setup {
+		if err := db.Exec(s[0].(string), s[1:]...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		roleNos := []string{deleted, replacement}
+		db.Exec(`DELETE FROM user_role WHERE user_no = ?`, userNo)
+		db.Exec(`DELETE FROM user_group_role WHERE group_no = ?`, groupNo)
+		db.Exec(`DELETE FROM role_resource WHERE role_no IN ?`, roleNos)
+		db.Exec(`DELETE FROM role WHERE role_no IN ?`, roleNos)
+	}()
+
+	// held by group only
+	if err := DeleteRole(rail, DeleteRoleReq{RoleNo: deleted}, operator); err == nil {
+		t.Fatal("role held by group should not be deleted without replacement")
+	}
+
+	// held by user as well
+	if err := db.Exec(`INSERT INTO user_role (user_no, role_no) VALUES (?, ?)`, userNo, deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteRole(rail, DeleteRoleReq{RoleNo: deleted}, operator); err == nil {
+		t.Fatal("role held by user should not be deleted without replacement")
+	}
+	if err := DeleteRole(rail, DeleteRoleReq{RoleNo: deleted, ReplacementRoleNo: deleted}, operator); err == nil {
+		t.Fatal("replacement should not be the deleted role")
+	}
+
+	if err := DeleteRole(rail, DeleteRoleReq{RoleNo: deleted, ReplacementRoleNo: replacement}, operator); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.Raw(`SELECT count(*) FROM role WHERE role_no = ?`, deleted).Scan(&count).Error; err != nil || count > 0 {
+		t.Fatalf("role should be deleted, %v", err)
+	}
+	if err := db.Raw(`SELECT count(*) FROM role_resource WHERE role_no = ?`, deleted).Scan(&count).Error; err != nil || count > 0 {
+		t.Fatalf("resources of role should be deleted, %v", err)
+	}
+	var roleNo string
+	if err := db.Raw(`SELECT role_no FROM user_role WHERE user_no = ?`, userNo).Scan(&roleNo).Error; err != nil || roleNo != replacement {
+		t.Fatalf("user should hold the replacement role, %v, %v", roleNo, err)
+	}
+	if err := db.Raw(`SELECT role_no FROM user_group_role WHERE group_no = ?`, groupNo).Scan(&roleNo).Error; err != nil || roleNo != replacement {
+		t.Fatalf("group should hold the replacement role, %v, %v", roleNo, err)
+	}
+}
@@ -0,0 +1,229 @@
+package vault
+
+import (
+	"slices"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+)
+
+type UpdateRoleParentReq struct {
+	RoleNo       string `json:"roleNo" validation:"notEmpty"`
+	ParentRoleNo string `json:"parentRoleNo" desc:"parent role no, resources of the parent role are inherited, empty to remove the parent"`
+}
+
+// role no -> parent role no
+type roleParents map[string]string
+
+// Find the role itself and all its ancestors, the closest one comes first.
+func (p roleParents) ancestors(roleNo string) []string {
+	seen := util.NewSet[string]()
+	chain := []string{}
+	for r := roleNo; r != "" && seen.Add(r); r = p[r] {
+		chain = append(chain, r)
+	}
+	return chain
+}
+
+// Find the role itself and all its descendants.
+func (p roleParents) descendants(roleNo string) []string {
+	children := map[string][]string{}
+	for r, parent := range p {
+		if parent != "" {
+			children[parent] = append(children[parent], r)
+		}
+	}
+
+	seen := util.NewSet[string]()
+	seen.Add(roleNo)
+	found := []string{roleNo}
+	for i := 0; i < len(found); i++ {
+		for _, c := range children[found[i]] {
+			if seen.Add(c) {
+				found = append(found, c)
+			}
+		}
+	}
+	return found
+}
+
+// Check whether making parentRoleNo the parent of roleNo creates a cycle.
+func (p roleParents) createsCycle(roleNo string, parentRoleNo string) bool {
+	if parentRoleNo == "" {
+		return false
+	}
+	for _, r := range p.ancestors(parentRoleNo) {
+		if r == roleNo {
+			return true
+		}
+	}
+	return false
+}
+
+func loadRoleParents(rail miso.Rail) (roleParents, error) {
+	type roleParent struct {
+		RoleNo       string
+		ParentRoleNo string
+	}
+	var rows []roleParent
+	if err := mysql.GetMySQL().Raw(`select role_no, parent_role_no from role`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	p := roleParents{}
+	for _, r := range rows {
+		p[r.RoleNo] = r.ParentRoleNo
+	}
+	return p, nil
+}
+
+// List resources that are directly bound to the role or inherited from its ancestors, only valid grants are included.
+func listEffectiveRoleRes(rail miso.Rail, parents roleParents, roleNo string) ([]ERoleRes, error) {
+	var rr []ERoleRes
+	t := mysql.GetMySQL().
+		Raw("select * from role_resource rr where role_no in ? and "+validGrantCond("rr"), append([]any{parents.ancestors(roleNo)}, validGrantArgs()...)...).
+		Scan(&rr)
+	if t.Error != nil {
+		return nil, t.Error
+	}
+	return rr, nil
+}
+
+// List effective resources as entries of roleResCache, i.e., code -> cached value.
+//
+// Codes of deny rules are prefixed, see roleResCacheCode. Conditions of the same code are merged, see mergeRoleResCond.
+func listEffectiveRoleResCodes(rail miso.Rail, parents roleParents, roleNo string) (map[string]string, error) {
+	codes := map[string]string{}
+	rr, err := listEffectiveRoleRes(rail, parents, roleNo)
+	if err != nil {
+		return codes, err
+	}
+	for _, r := range rr {
+		c := roleResCacheCode(r.ResCode, r.Effect)
+		prev, ok := codes[c]
+		codes[c] = mergeRoleResCond(prev, ok, r.ResCondition)
+	}
+	return codes, nil
+}
+
+// Update parent of the role, the role and all its descendants inherit resources of the new parent.
+func UpdateRoleParent(rail miso.Rail, req UpdateRoleParentReq, user common.User) error {
+	if req.ParentRoleNo == req.RoleNo {
+		return miso.NewErrf("Role cannot inherit from itself").WithCode(ErrCodeRoleHierarchyCycle)
+	}
+	if req.RoleNo == DefaultAdminRoleNo || req.ParentRoleNo == DefaultAdminRoleNo {
+		return miso.NewErrf("Administrator role cannot be part of role hierarchy")
+	}
+	if _, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.RoleNo}); err != nil {
+		return err
+	}
+	if req.ParentRoleNo != "" {
+		if _, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.ParentRoleNo}); err != nil {
+			return err
+		}
+	}
+	// the role and its descendants inherit resources of the parent, it's the same as granting resources to the role
+	if err := checkRoleResGrantable(rail, mysql.GetMySQL(), user, req.RoleNo, ""); err != nil {
+		return err
+	}
+
+	_, err := lockRoleResCache(rail, func() (any, error) {
+		parents, err := loadRoleParents(rail)
+		if err != nil {
+			return nil, err
+		}
+		if parents.createsCycle(req.RoleNo, req.ParentRoleNo) {
+			return nil, miso.NewErrf("Role hierarchy cannot contain cycle").
+				WithCode(ErrCodeRoleHierarchyCycle).
+				WithInternalMsg("Role %v is an ancestor of %v", req.RoleNo, req.ParentRoleNo)
+		}
+
+		affected := parents.descendants(req.RoleNo)
+		before := map[string]map[string]string{}
+		for _, r := range affected {
+			if before[r], err = listEffectiveRoleResCodes(rail, parents, r); err != nil {
+				return nil, err
+			}
+		}
+
+		err = mysql.GetMySQL().
+			Exec(`update role set parent_role_no = ?, update_by = ? where role_no = ?`, req.ParentRoleNo, user.Username, req.RoleNo).
+			Error
+		if err != nil {
+			return nil, err
+		}
+		parents[req.RoleNo] = req.ParentRoleNo
+		rail.Infof("Parent of role %v updated to '%v' by %v", req.RoleNo, req.ParentRoleNo, user.Username)
+
+		for _, r := range affected {
+			after, err := listEffectiveRoleResCodes(rail, parents, r)
+			if err != nil {
+				return nil, err
+			}
+			for code := range before[r] {
+				if _, ok := after[code]; !ok {
+					if err := roleResCache.Del(rail, roleResCacheKey(r, code)); err != nil {
+						return nil, err
+					}
+				}
+			}
+			for code, v := range after {
+				if err := roleResCache.Put(rail, roleResCacheKey(r, code), v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// Reload cache for the role and its descendants after resource is bound to or removed from the role.
+func reloadRoleResCacheOfDescendants(rail miso.Rail, roleNo string, resCode string) error {
+	return reloadRoleResCacheOfGrants(rail, []roleResGrant{{RoleNo: roleNo, ResCode: resCode}})
+}
+
+// Reload cache for the roles (and their descendants) whose resources are bound or removed, effective resources of
+// each affected role are only loaded once.
+func reloadRoleResCacheOfGrants(rail miso.Rail, grants []roleResGrant) error {
+	if len(grants) < 1 {
+		return nil
+	}
+	parents, err := loadRoleParents(rail)
+	if err != nil {
+		return err
+	}
+	affected := map[string][]string{} // role no -> resource codes
+	roleNos := []string{}
+	for _, g := range grants {
+		for _, r := range parents.descendants(g.RoleNo) {
+			if _, ok := affected[r]; !ok {
+				roleNos = append(roleNos, r)
+			}
+			if !slices.Contains(affected[r], g.ResCode) {
+				affected[r] = append(affected[r], g.ResCode)
+			}
+		}
+	}
+	for _, r := range roleNos {
+		codes, err := listEffectiveRoleResCodes(rail, parents, r)
+		if err != nil {
+			return err
+		}
+		for _, resCode := range affected[r] {
+			for _, c := range []string{roleResCacheCode(resCode, ResEffectAllow), roleResCacheCode(resCode, ResEffectDeny)} {
+				if v, ok := codes[c]; ok {
+					err = roleResCache.Put(rail, roleResCacheKey(r, c), v)
+				} else {
+					err = roleResCache.Del(rail, roleResCacheKey(r, c))
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
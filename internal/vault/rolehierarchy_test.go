@@ -0,0 +1,50 @@
+package vault
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRoleParents(t *testing.T) {
+	p := roleParents{
+		"senior": "dev",
+		"dev":    "intern",
+		"intern": "",
+		"lead":   "senior",
+		"qa":     "intern",
+		"ops":    "",
+	}
+
+	if v := p.ancestors("lead"); !slices.Equal(v, []string{"lead", "senior", "dev", "intern"}) {
+		t.Fatal(v)
+	}
+	if v := p.ancestors("ops"); !slices.Equal(v, []string{"ops"}) {
+		t.Fatal(v)
+	}
+
+	v := p.descendants("dev")
+	slices.Sort(v)
+	if !slices.Equal(v, []string{"dev", "lead", "senior"}) {
+		t.Fatal(v)
+	}
+
+	if !p.createsCycle("dev", "lead") {
+		t.Fatal("dev -> lead should create cycle")
+	}
+	if !p.createsCycle("intern", "qa") {
+		t.Fatal("intern -> qa should create cycle")
+	}
+	if p.createsCycle("ops", "lead") {
+		t.Fatal("ops -> lead should not create cycle")
+	}
+	if p.createsCycle("dev", "") {
+		t.Fatal("removing parent should not create cycle")
+	}
+}
+
+func TestRoleParentsExistingCycle(t *testing.T) {
+	p := roleParents{"a": "b", "b": "a"}
+	if v := p.ancestors("a"); !slices.Equal(v, []string{"a", "b"}) {
+		t.Fatal(v)
+	}
+}
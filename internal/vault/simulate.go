@@ -0,0 +1,306 @@
+package vault
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/curtisnewbie/miso/miso"
+	"gorm.io/gorm"
+)
+
+const (
+	SimulateRoleResAdd    = "ROLE_RES_ADD"
+	SimulateRoleResRemove = "ROLE_RES_REMOVE"
+	SimulatePathType      = "PATH_TYPE_UPDATE"
+	SimulatePathUnbind    = "PATH_UNBIND"
+	SimulateResDelete     = "RES_DELETE"
+)
+
+type SimulateChangeReq struct {
+	Type     string `json:"type" validation:"notEmpty,member:ROLE_RES_ADD|ROLE_RES_REMOVE|PATH_TYPE_UPDATE|PATH_UNBIND|RES_DELETE" desc:"proposed change: ROLE_RES_ADD, ROLE_RES_REMOVE, PATH_TYPE_UPDATE, PATH_UNBIND, RES_DELETE"`
+	RoleNo   string `json:"roleNo" desc:"role of ROLE_RES_ADD and ROLE_RES_REMOVE"`
+	ResCode  string `json:"resCode" desc:"resource of ROLE_RES_ADD, ROLE_RES_REMOVE, RES_DELETE and PATH_UNBIND (all resources of the path are unbound if it's empty)"`
+	Effect   string `json:"effect" desc:"effect of ROLE_RES_ADD, ALLOW (by default) or DENY"`
+	PathNo   string `json:"pathNo" desc:"path of PATH_TYPE_UPDATE and PATH_UNBIND"`
+	PathType string `json:"pathType" desc:"new path type of PATH_TYPE_UPDATE, PROTECTED or PUBLIC"`
+}
+
+type SimulateChangeResp struct {
+	Summary       SimulationSummary `json:"summary"`
+	Roles         []SimulatedRole   `json:"roles" desc:"roles whose access is changed"`
+	AffectedUsers []AffectedUser    `json:"affectedUsers" desc:"users holding the roles whose access is changed"`
+}
+
+type SimulationSummary struct {
+	AffectedRoles int `json:"affectedRoles"`
+	AffectedUsers int `json:"affectedUsers"`
+	LostPaths     int `json:"lostPaths" desc:"number of (role, path) pairs that become inaccessible"`
+	GainedPaths   int `json:"gainedPaths" desc:"number of (role, path) pairs that become accessible"`
+	LostRes       int `json:"lostRes" desc:"number of (role, resource) pairs that are no longer granted"`
+	GainedRes     int `json:"gainedRes" desc:"number of (role, resource) pairs that become granted"`
+}
+
+type SimulatedRole struct {
+	RoleNo      string          `json:"roleNo"`
+	RoleName    string          `json:"roleName"`
+	LostPaths   []SimulatedPath `json:"lostPaths" desc:"paths that become inaccessible"`
+	GainedPaths []SimulatedPath `json:"gainedPaths" desc:"paths that become accessible"`
+	LostRes     []string        `json:"lostRes" desc:"resources that are no longer granted"`
+	GainedRes   []string        `json:"gainedRes" desc:"resources that become granted"`
+}
+
+type SimulatedPath struct {
+	PathNo string `json:"pathNo"`
+	Pgroup string `json:"pgroup"`
+	Method string `json:"method"`
+	Url    string `json:"url"`
+}
+
+type AffectedUser struct {
+	UserNo   string   `json:"userNo"`
+	Username string   `json:"username"`
+	RoleNos  []string `json:"roleNos" desc:"roles of the user whose access is changed"`
+}
+
+type simulatedGrant struct {
+	ResCode string
+	Effect  string
+}
+
+// In-memory snapshot of roles, grants and paths, conditions of the grants are not evaluated.
+type permSnapshot struct {
+	parents   roleParents
+	roleNames map[string]string
+	grants    map[string][]simulatedGrant // role no -> grants bound to the role directly
+	paths     []CachedUrlRes
+	resources []string
+	resNames  map[string]string
+}
+
+// Simulate the proposed change without applying it.
+//
+// Access of each role is evaluated on its own, i.e., deny rules of the other roles held by the same user are
+// not considered, and the conditions of the grants are treated as satisfied.
+func SimulateChange(rail miso.Rail, db *gorm.DB, req SimulateChangeReq) (SimulateChangeResp, error) {
+	before, err := loadPermSnapshot(db)
+	if err != nil {
+		return SimulateChangeResp{}, err
+	}
+	after, err := before.apply(req)
+	if err != nil {
+		return SimulateChangeResp{}, err
+	}
+
+	resp := SimulateChangeResp{Roles: diffPermSnapshots(before, after), AffectedUsers: []AffectedUser{}}
+	roleNos := make([]string, 0, len(resp.Roles))
+	for _, r := range resp.Roles {
+		roleNos = append(roleNos, r.RoleNo)
+		resp.Summary.LostPaths += len(r.LostPaths)
+		resp.Summary.GainedPaths += len(r.GainedPaths)
+		resp.Summary.LostRes += len(r.LostRes)
+		resp.Summary.GainedRes += len(r.GainedRes)
+	}
+
+	if len(roleNos) > 0 {
+		users, err := listMatrixUsers(db, roleNos)
+		if err != nil {
+			return SimulateChangeResp{}, err
+		}
+		for _, u := range users {
+			resp.AffectedUsers = append(resp.AffectedUsers, AffectedUser(u))
+		}
+	}
+	resp.Summary.AffectedRoles = len(resp.Roles)
+	resp.Summary.AffectedUsers = len(resp.AffectedUsers)
+	rail.Infof("Simulated %v, summary: %+v", req.Type, resp.Summary)
+	return resp, nil
+}
+
+func loadPermSnapshot(db *gorm.DB) (*permSnapshot, error) {
+	s := &permSnapshot{parents: roleParents{}, roleNames: map[string]string{}, grants: map[string][]simulatedGrant{},
+		resNames: map[string]string{}}
+
+	var roles []ERole
+	if err := db.Raw(`SELECT role_no, name, parent_role_no FROM role`).Scan(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		s.parents[r.RoleNo] = r.ParentRoleNo
+		s.roleNames[r.RoleNo] = r.Name
+	}
+
+	var rr []ERoleRes
+	if err := db.Raw(`SELECT role_no, res_code, effect FROM role_resource rr WHERE `+validGrantCond("rr"), validGrantArgs()...).
+		Scan(&rr).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rr {
+		s.grants[r.RoleNo] = append(s.grants[r.RoleNo], simulatedGrant{ResCode: r.ResCode, Effect: r.Effect})
+	}
+
+	var paths []ExtendedPathRes
+	if err := db.Raw(`SELECT p.*, pr.res_code FROM path p LEFT JOIN path_resource pr ON p.path_no = pr.path_no`).
+		Scan(&paths).Error; err != nil {
+		return nil, err
+	}
+	s.paths = toCachedUrlRes(paths)
+
+	var res []ResBrief
+	if err := db.Raw(`SELECT code, name FROM resource ORDER BY code`).Scan(&res).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range res {
+		s.resources = append(s.resources, r.Code)
+		s.resNames[r.Code] = r.Name
+	}
+	return s, nil
+}
+
+// Copy of the snapshot with the change applied.
+func (s *permSnapshot) apply(req SimulateChangeReq) (*permSnapshot, error) {
+	c := &permSnapshot{
+		parents:   s.parents,
+		roleNames: s.roleNames,
+		grants:    make(map[string][]simulatedGrant, len(s.grants)),
+		paths:     make([]CachedUrlRes, 0, len(s.paths)),
+		resources: s.resources,
+		resNames:  s.resNames,
+	}
+	for r, g := range s.grants {
+		c.grants[r] = slices.Clone(g)
+	}
+	for _, p := range s.paths {
+		p.ResCodes = slices.Clone(p.ResCodes)
+		c.paths = append(c.paths, p)
+	}
+
+	req.ResCode = strings.TrimSpace(req.ResCode)
+	switch req.Type {
+	case SimulateRoleResAdd, SimulateRoleResRemove:
+		if _, ok := s.parents[req.RoleNo]; !ok {
+			return nil, miso.NewErrf("Role not found")
+		}
+		if req.ResCode == "" {
+			return nil, miso.NewErrf("Resource code is required")
+		}
+		if req.Type == SimulateRoleResRemove {
+			c.grants[req.RoleNo] = slices.DeleteFunc(c.grants[req.RoleNo], func(g simulatedGrant) bool { return g.ResCode == req.ResCode })
+			break
+		}
+		if req.Effect == "" {
+			req.Effect = ResEffectAllow
+		}
+		if err := checkResEffect(req.Effect); err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(s.resources, func(code string) bool { return resCodeMatches(req.ResCode, code) }) {
+			return nil, miso.NewErrf("Resource not found")
+		}
+		// like addResToRole, effect of the existing binding is updated
+		c.grants[req.RoleNo] = slices.DeleteFunc(c.grants[req.RoleNo], func(g simulatedGrant) bool { return g.ResCode == req.ResCode })
+		c.grants[req.RoleNo] = append(c.grants[req.RoleNo], simulatedGrant{ResCode: req.ResCode, Effect: req.Effect})
+
+	case SimulatePathType, SimulatePathUnbind:
+		i := slices.IndexFunc(c.paths, func(p CachedUrlRes) bool { return p.PathNo == req.PathNo })
+		if i < 0 {
+			return nil, miso.NewErrf("Path not found")
+		}
+		if req.Type == SimulatePathUnbind {
+			c.paths[i].ResCodes = slices.DeleteFunc(c.paths[i].ResCodes, func(code string) bool { return req.ResCode == "" || code == req.ResCode })
+			break
+		}
+		if req.PathType != PathTypeProtected && req.PathType != PathTypePublic {
+			return nil, miso.NewErrf("Invalid path type, should be either PROTECTED or PUBLIC")
+		}
+		c.paths[i].Ptype = req.PathType
+
+	case SimulateResDelete:
+		if !slices.Contains(s.resources, req.ResCode) {
+			return nil, miso.NewErrf("Resource not found")
+		}
+		c.resources = slices.DeleteFunc(slices.Clone(s.resources), func(code string) bool { return code == req.ResCode })
+		for r := range c.grants {
+			c.grants[r] = slices.DeleteFunc(c.grants[r], func(g simulatedGrant) bool { return g.ResCode == req.ResCode })
+		}
+		for i := range c.paths {
+			c.paths[i].ResCodes = slices.DeleteFunc(c.paths[i].ResCodes, func(code string) bool { return code == req.ResCode })
+		}
+
+	default:
+		return nil, miso.NewErrf("Unsupported change type: %v", req.Type)
+	}
+	return c, nil
+}
+
+// Check whether the role (and its ancestors) grants the resource, deny rules override the allows.
+func (s *permSnapshot) roleHasRes(roleNo string, resCode string) bool {
+	if roleNo == DefaultAdminRoleNo {
+		return true
+	}
+	allowed := false
+	for _, r := range s.parents.ancestors(roleNo) {
+		for _, g := range s.grants[r] {
+			if !resCodeMatches(g.ResCode, resCode) {
+				continue
+			}
+			if g.Effect == ResEffectDeny {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func (s *permSnapshot) roleCanAccess(roleNo string, p CachedUrlRes) bool {
+	if p.Ptype == PathTypePublic || roleNo == DefaultAdminRoleNo {
+		return true
+	}
+	if len(p.ResCodes) < 1 {
+		return false
+	}
+	all := p.ResMode == PathResModeAll
+	for _, code := range p.ResCodes {
+		if s.roleHasRes(roleNo, code) != all {
+			return !all
+		}
+	}
+	return all
+}
+
+// Diff access of each role between the snapshots, roles without changes are excluded.
+func diffPermSnapshots(before *permSnapshot, after *permSnapshot) []SimulatedRole {
+	roleNos := make([]string, 0, len(before.parents))
+	for r := range before.parents {
+		roleNos = append(roleNos, r)
+	}
+	sort.Strings(roleNos)
+
+	roles := []SimulatedRole{}
+	for _, r := range roleNos {
+		sr := SimulatedRole{RoleNo: r, RoleName: before.roleNames[r], LostPaths: []SimulatedPath{}, GainedPaths: []SimulatedPath{},
+			LostRes: []string{}, GainedRes: []string{}}
+		for i, bp := range before.paths {
+			b, a := before.roleCanAccess(r, bp), after.roleCanAccess(r, after.paths[i]) // changes never add or remove paths
+			sp := SimulatedPath{PathNo: bp.PathNo, Pgroup: bp.Pgroup, Method: bp.Method, Url: bp.Url}
+			if b && !a {
+				sr.LostPaths = append(sr.LostPaths, sp)
+			} else if !b && a {
+				sr.GainedPaths = append(sr.GainedPaths, sp)
+			}
+		}
+		for _, code := range before.resources {
+			b, a := before.roleHasRes(r, code), slices.Contains(after.resources, code) && after.roleHasRes(r, code)
+			if b && !a {
+				sr.LostRes = append(sr.LostRes, code)
+			} else if !b && a {
+				sr.GainedRes = append(sr.GainedRes, code)
+			}
+		}
+		if len(sr.LostPaths)+len(sr.GainedPaths)+len(sr.LostRes)+len(sr.GainedRes) > 0 {
+			roles = append(roles, sr)
+		}
+	}
+	return roles
+}
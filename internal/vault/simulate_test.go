@@ -0,0 +1,96 @@
+package vault
+
+import "testing"
+
+func testPermSnapshot() *permSnapshot {
+	return &permSnapshot{
+		parents:   roleParents{"parent": "", "child": "parent", "other": ""},
+		roleNames: map[string]string{"parent": "Parent", "child": "Child", "other": "Other"},
+		grants: map[string][]simulatedGrant{
+			"parent": {{ResCode: "postbox:query", Effect: ResEffectAllow}},
+			"other":  {{ResCode: "postbox:*", Effect: ResEffectAllow}, {ResCode: "postbox:delete", Effect: ResEffectDeny}},
+		},
+		paths: []CachedUrlRes{
+			{PathNo: "p1", Url: "/postbox/query", Ptype: PathTypeProtected, ResCodes: []string{"postbox:query"}, ResMode: PathResModeAny},
+			{PathNo: "p2", Url: "/postbox/delete", Ptype: PathTypeProtected, ResCodes: []string{"postbox:delete"}, ResMode: PathResModeAny},
+			{PathNo: "p3", Url: "/postbox/open", Ptype: PathTypePublic, ResCodes: []string{"postbox:delete"}, ResMode: PathResModeAny},
+		},
+		resources: []string{"postbox:query", "postbox:delete"},
+	}
+}
+
+func TestSimulateRoleResRemove(t *testing.T) {
+	before := testPermSnapshot()
+	after, err := before.apply(SimulateChangeReq{Type: SimulateRoleResRemove, RoleNo: "parent", ResCode: "postbox:query"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := diffPermSnapshots(before, after)
+	if len(roles) != 2 || roles[0].RoleNo != "child" || roles[1].RoleNo != "parent" {
+		t.Fatal(roles)
+	}
+	if len(roles[0].LostPaths) != 1 || roles[0].LostPaths[0].PathNo != "p1" || len(roles[0].LostRes) != 1 {
+		t.Fatal(roles[0])
+	}
+	if len(before.grants["parent"]) != 1 {
+		t.Fatal("snapshot is modified")
+	}
+}
+
+func TestSimulatePathType(t *testing.T) {
+	before := testPermSnapshot()
+	after, err := before.apply(SimulateChangeReq{Type: SimulatePathType, PathNo: "p3", PathType: PathTypeProtected})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := diffPermSnapshots(before, after)
+	if len(roles) != 3 {
+		t.Fatal(roles)
+	}
+	for _, r := range roles {
+		if len(r.LostPaths) != 1 || r.LostPaths[0].PathNo != "p3" || len(r.GainedPaths) != 0 {
+			t.Fatal(r)
+		}
+	}
+}
+
+func TestSimulateDenyAndDelete(t *testing.T) {
+	before := testPermSnapshot()
+	after, err := before.apply(SimulateChangeReq{Type: SimulateRoleResAdd, RoleNo: "parent", ResCode: "postbox:*", Effect: ResEffectDeny})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := diffPermSnapshots(before, after); len(roles) != 2 {
+		t.Fatal(roles)
+	}
+
+	after, err = before.apply(SimulateChangeReq{Type: SimulateResDelete, ResCode: "postbox:delete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := diffPermSnapshots(before, after); len(roles) != 0 {
+		t.Fatal(roles)
+	}
+
+	if _, err := before.apply(SimulateChangeReq{Type: SimulatePathUnbind, PathNo: "p404"}); err == nil {
+		t.Fatal("path should not be found")
+	}
+}
+
+func TestSimulateAllowOverDeny(t *testing.T) {
+	before := testPermSnapshot()
+	after, err := before.apply(SimulateChangeReq{Type: SimulateRoleResAdd, RoleNo: "other", ResCode: "postbox:delete", Effect: ResEffectAllow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := diffPermSnapshots(before, after)
+	if len(roles) != 1 || roles[0].RoleNo != "other" {
+		t.Fatal(roles)
+	}
+	if len(roles[0].GainedPaths) != 1 || roles[0].GainedPaths[0].PathNo != "p2" {
+		t.Fatal(roles[0])
+	}
+	if len(after.grants["other"]) != 2 || len(before.grants["other"]) != 2 {
+		t.Fatal("existing binding should be replaced")
+	}
+}
@@ -27,5 +27,45 @@ func ScheduleTasks(rail miso.Rail) error {
 	if err != nil {
 		return err
 	}
+	err = task.ScheduleDistributedTask(miso.Job{
+		Cron:                   "0 * * * *",
+		CronWithSeconds:        false,
+		Name:                   "ExpireStaleRegistrationsTask",
+		TriggeredOnBoostrapped: false,
+		Run:                    ExpireStaleRegistrations,
+	})
+	if err != nil {
+		return err
+	}
+	err = task.ScheduleDistributedTask(miso.Job{
+		Cron:                   "* * * * *",
+		CronWithSeconds:        false,
+		Name:                   "CleanupExpiredGrantsTask",
+		TriggeredOnBoostrapped: false,
+		Run:                    CleanupExpiredGrants,
+	})
+	if err != nil {
+		return err
+	}
+	err = task.ScheduleDistributedTask(miso.Job{
+		Cron:                   "*/10 * * * *",
+		CronWithSeconds:        false,
+		Name:                   "NotifyExpiringGrantsTask",
+		TriggeredOnBoostrapped: false,
+		Run:                    NotifyExpiringGrants,
+	})
+	if err != nil {
+		return err
+	}
+	err = task.ScheduleDistributedTask(miso.Job{
+		Cron:                   "30 3 * * *",
+		CronWithSeconds:        false,
+		Name:                   "PurgeDeletedUsersTask",
+		TriggeredOnBoostrapped: false,
+		Run:                    PurgeDeletedUsers,
+	})
+	if err != nil {
+		return err
+	}
 	return nil
 }
@@ -46,11 +46,19 @@ type User struct {
 	RoleNo       string
 	RoleName     string
 	IsDisabled   int
+	Email        string
+	DisplayName  string
+	Phone        string
+	Attributes   api.UserAttributes
 	CreateTime   util.ETime
 	CreateBy     string
 	UpdateTime   util.ETime
 	UpdateBy     string
 	IsDel        bool
+	RejectReason string
+
+	EmailVerified     bool
+	EmailNotification bool
 }
 
 func (u *User) Deleted() bool {
@@ -62,14 +70,21 @@ func (u *User) CanReview() bool {
 }
 
 type UserDetail struct {
-	Id           int    `json:"id"`
-	Username     string `json:"username"`
-	RoleName     string `json:"roleName"`
-	RoleNo       string `json:"roleNo"`
-	UserNo       string `json:"userNo"`
-	RegisterDate string `json:"registerDate"`
-	Password     string `json:"password"`
-	Salt         string `json:"salt"`
+	Id           int                `json:"id"`
+	Username     string             `json:"username"`
+	RoleName     string             `json:"roleName"`
+	RoleNo       string             `json:"roleNo"`
+	UserNo       string             `json:"userNo"`
+	RegisterDate string             `json:"registerDate"`
+	Password     string             `json:"password"`
+	Salt         string             `json:"salt"`
+	Email        string             `json:"email"`
+	DisplayName  string             `json:"displayName"`
+	Phone        string             `json:"phone"`
+	Attributes   api.UserAttributes `json:"attributes"`
+
+	EmailVerified     bool `json:"emailVerified"`
+	EmailNotification bool `json:"emailNotification"`
 }
 
 func loadUser(rail miso.Rail, tx *gorm.DB, username string) (User, error) {
@@ -98,40 +113,123 @@ func loadUser(rail miso.Rail, tx *gorm.DB, username string) (User, error) {
 	return user, nil
 }
 
+func loadUserByUserNo(rail miso.Rail, tx *gorm.DB, userNo string) (User, error) {
+	if userNo == "" {
+		return User{}, miso.NewErrf("UserNo is required")
+	}
+
+	var user User
+	t := tx.Raw(`
+		SELECT u.*, r.name AS role_name
+		FROM user u
+		LEFT JOIN role r using (role_no)
+		WHERE u.user_no = ? and u.is_del = 0
+	`, userNo).
+		Scan(&user)
+
+	if t.Error != nil {
+		rail.Errorf("Failed to find user, userNo: %v, %v", userNo, t.Error)
+		return User{}, t.Error
+	}
+
+	if t.RowsAffected < 1 {
+		return User{}, miso.NewErrf("User not found").WithInternalMsg("User %v is not found", userNo)
+	}
+
+	return user, nil
+}
+
+// Load user for login, the name can be username or verified email.
+//
+// Normalized username is tried first, then the username as is (for users registered before usernames are normalized),
+// and finally the verified email.
+func loadLoginUser(rail miso.Rail, tx *gorm.DB, name string) (User, error) {
+	name = strings.TrimSpace(name)
+	normalized := normalizeUsername(name)
+	user, err := loadUser(rail, tx, normalized)
+	if err == nil {
+		return user, nil
+	}
+
+	if normalized != name {
+		if user, err := loadUser(rail, tx, name); err == nil {
+			return user, nil
+		}
+	}
+
+	if strings.Contains(name, "@") {
+		var user User
+		t := tx.Raw(`
+			SELECT u.*, r.name AS role_name
+			FROM user u
+			LEFT JOIN role r using (role_no)
+			WHERE u.email = ? AND u.email_verified = 1 and u.is_del = 0
+			LIMIT 1
+		`, name).
+			Scan(&user)
+		if t.Error != nil {
+			rail.Errorf("Failed to find user by email, email: %v, %v", name, t.Error)
+			return User{}, t.Error
+		}
+		if t.RowsAffected > 0 {
+			return user, nil
+		}
+	}
+	return User{}, err
+}
+
 func UserLogin(rail miso.Rail, tx *gorm.DB, req PasswordLoginParam) (string, User, error) {
 	user, err := userLogin(rail, tx, req.Username, req.Password)
 	if err != nil {
 		return "", User{}, err
 	}
 
-	tu := TokenUser{
-		Id:       user.Id,
-		UserNo:   user.UserNo,
-		Username: user.Username,
-		RoleNo:   user.RoleNo,
-	}
-
-	rail.Debugf("buildToken %+v", tu)
-	tkn, err := buildToken(tu, 15*time.Minute)
+	tkn, err := buildLoginToken(rail, tx, user, LoginMethodPassword)
 	if err != nil {
 		return "", User{}, err
 	}
 	return tkn, user, nil
 }
 
+func buildLoginToken(rail miso.Rail, tx *gorm.DB, user User, loginMethod string) (string, error) {
+	roleNos, err := listUserRoleNos(rail, tx, user.UserNo)
+	if err != nil {
+		return "", fmt.Errorf("failed to list roles of user %v, %w", user.UserNo, err)
+	}
+	tu := TokenUser{
+		Id:          user.Id,
+		UserNo:      user.UserNo,
+		Username:    user.Username,
+		RoleNo:      user.RoleNo,
+		RoleNos:     roleNos,
+		LoginMethod: loginMethod,
+	}
+
+	rail.Debugf("buildToken %+v", tu)
+	return buildToken(tu, 15*time.Minute)
+}
+
 type TokenUser struct {
-	Id       int
-	UserNo   string
-	Username string
-	RoleNo   string
+	Id          int
+	UserNo      string
+	Username    string
+	RoleNo      string   // primary role, kept in claims for backward compatibility
+	RoleNos     []string // all roles of the user, including the primary one
+	LoginMethod string
+	IssuedAt    int64 // unix milliseconds
 }
 
 func buildToken(user TokenUser, exp time.Duration) (string, error) {
+	now := time.Now()
 	claims := map[string]any{
-		"id":       user.Id,
-		"username": user.Username,
-		"userno":   user.UserNo,
-		"roleno":   user.RoleNo,
+		"id":          user.Id,
+		"username":    user.Username,
+		"userno":      user.UserNo,
+		"roleno":      user.RoleNo,
+		"roles":       user.RoleNos,
+		"loginmethod": user.LoginMethod,
+		"iat":         now.Unix(),
+		"iatms":       now.UnixMilli(), // 'iat' is in seconds, which is not precise enough for token revocation
 	}
 
 	return jwt.JwtEncode(claims, exp)
@@ -146,21 +244,13 @@ func userLogin(rail miso.Rail, tx *gorm.DB, username string, password string) (U
 		return User{}, miso.NewErrf("Password is required")
 	}
 
-	user, err := loadUser(rail, tx, username)
+	user, err := loadLoginUser(rail, tx, username)
 	if err != nil {
 		return User{}, err
 	}
 
-	if user.ReviewStatus == api.ReviewPending {
-		return User{}, miso.NewErrf("Your registration is being reviewed, please wait for approval")
-	}
-
-	if user.ReviewStatus == api.ReviewRejected {
-		return User{}, miso.NewErrf("Your are not permitted to login, please contact administrator")
-	}
-
-	if user.IsDisabled == api.UserDisabled {
-		return User{}, miso.NewErrf("User is disabled")
+	if err := checkUserCanLogin(user); err != nil {
+		return User{}, err
 	}
 
 	if checkPassword(user.Password, user.Salt, password) {
@@ -179,6 +269,21 @@ func userLogin(rail miso.Rail, tx *gorm.DB, username string, password string) (U
 	return User{}, miso.NewErrf("Password incorrect").WithInternalMsg("User %v login failed, password incorrect", username)
 }
 
+func checkUserCanLogin(user User) error {
+	if user.ReviewStatus == api.ReviewPending {
+		return miso.NewErrf("Your registration is being reviewed, please wait for approval")
+	}
+
+	if user.ReviewStatus == api.ReviewRejected {
+		return miso.NewErrf("Your are not permitted to login, please contact administrator")
+	}
+
+	if user.IsDisabled == api.UserDisabled {
+		return miso.NewErrf("User is disabled")
+	}
+	return nil
+}
+
 func checkUserKey(rail miso.Rail, tx *gorm.DB, userNo string, password string) (bool, error) {
 	if password == "" {
 		return false, nil
@@ -262,6 +367,8 @@ type CreateUserParam struct {
 }
 
 func NewUser(rail miso.Rail, tx *gorm.DB, req CreateUserParam) error {
+	req.Username = normalizeUsername(req.Username)
+
 	if req.RoleNo != "" {
 		_, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.RoleNo})
 		if err != nil {
@@ -285,6 +392,10 @@ func NewUser(rail miso.Rail, tx *gorm.DB, req CreateUserParam) error {
 		return miso.NewErrf("User is already registered")
 	}
 
+	if err := checkUsernameAvailable(rail, tx, req.Username, ""); err != nil {
+		return err
+	}
+
 	user := prepUserCred(req.Password)
 	user.UserNo = util.GenIdP("UE")
 	user.Username = req.Username
@@ -333,22 +444,26 @@ func ListUsers(rail miso.Rail, tx *gorm.DB, req ListUserReq) (miso.PageRes[api.U
 			return tx.Select("u.*, r.name as role_name").Order("u.id DESC")
 		}).
 		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
-			tx = tx.Table("user u").Joins("LEFT JOIN role r USING(role_no)")
-
-			if req.RoleNo != nil && *req.RoleNo != "" {
-				tx = tx.Where("u.role_no = ?", *req.RoleNo)
-			}
-			if req.Username != nil && *req.Username != "" {
-				tx = tx.Where("u.username LIKE ?", "%"+*req.Username+"%")
-			}
-			if req.IsDisabled != nil {
-				tx = tx.Where("u.is_disabled = ?", *req.IsDisabled)
-			}
-			return tx.Where("u.is_del = 0")
+			return listUsersBaseQuery(tx, req)
 		}).
 		Exec(rail, tx)
 }
 
+func listUsersBaseQuery(tx *gorm.DB, req ListUserReq) *gorm.DB {
+	tx = tx.Table("user u").Joins("LEFT JOIN role r USING(role_no)")
+
+	if req.RoleNo != nil && *req.RoleNo != "" {
+		tx = tx.Where("u.role_no = ?", *req.RoleNo)
+	}
+	if req.Username != nil && *req.Username != "" {
+		tx = tx.Where("u.username LIKE ?", "%"+*req.Username+"%")
+	}
+	if req.IsDisabled != nil {
+		tx = tx.Where("u.is_disabled = ?", *req.IsDisabled)
+	}
+	return tx.Where("u.is_del = 0")
+}
+
 func AdminUpdateUser(rail miso.Rail, tx *gorm.DB, req AdminUpdateUserReq, operator common.User) error {
 	if operator.UserNo == req.UserNo {
 		return miso.NewErrf("You cannot update yourself")
@@ -360,11 +475,35 @@ func AdminUpdateUser(rail miso.Rail, tx *gorm.DB, req AdminUpdateUserReq, operat
 			return miso.NewErrf("Invalid role").WithInternalMsg("failed to get role info, roleNo may be invalid, %v", err)
 		}
 	}
+	if err := checkRoleAssignable(rail, tx, operator, req.RoleNo); err != nil {
+		return err
+	}
+
+	prev, err := loadUserByUserNo(rail, tx, req.UserNo)
+	if err != nil {
+		return err
+	}
 
-	return tx.Exec(
+	// only administrators can update other administrators, and the current roles must be within the scopes as well
+	if err := checkUserManageable(rail, tx, operator, prev.UserNo); err != nil {
+		return err
+	}
+	err = tx.Exec(
 		`UPDATE user SET is_disabled = ?, update_by = ?, role_no = ? WHERE user_no = ?`,
 		req.IsDisabled, operator.Username, req.RoleNo, req.UserNo,
 	).Error
+	if err != nil {
+		return err
+	}
+
+	// primary role is carried by the tokens, like the roles revoked from the user
+	if prev.RoleNo != req.RoleNo {
+		rail.Infof("Primary role of user %v changed from %v to %v by %v", req.UserNo, prev.RoleNo, req.RoleNo, operator.Username)
+		if err := RevokeUserTokens(rail, req.UserNo); err != nil {
+			rail.Errorf("Failed to revoke tokens of user %v, %v", req.UserNo, err)
+		}
+	}
+	return nil
 }
 
 func ReviewUserRegistration(rail miso.Rail, tx *gorm.DB, req AdminReviewUserReq) error {
@@ -373,7 +512,14 @@ func ReviewUserRegistration(rail miso.Rail, tx *gorm.DB, req AdminReviewUserReq)
 			WithInternalMsg("ReviewStatus was neither ReviewApproved nor ReviewRejected, it was %v", req.ReviewStatus)
 	}
 
-	return redis.RLockExec(rail, fmt.Sprintf("auth:user:registration:review:%v", req.UserId),
+	if req.ReviewStatus == api.ReviewApproved && req.RoleNo != "" {
+		if _, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.RoleNo}); err != nil {
+			return miso.NewErrf("Invalid role").WithInternalMsg("failed to get role info, roleNo may be invalid, %v", err)
+		}
+	}
+
+	var reviewed User
+	err := redis.RLockExec(rail, fmt.Sprintf("auth:user:registration:review:%v", req.UserId),
 		func() error {
 			var user User
 			t := tx.Raw(`SELECT * FROM user WHERE id = ?`, req.UserId).
@@ -396,22 +542,163 @@ func ReviewUserRegistration(rail miso.Rail, tx *gorm.DB, req AdminReviewUserReq)
 			}
 
 			isDisabled := api.UserDisabled
+			roleNo := user.RoleNo
+			rejectReason := ""
 			if req.ReviewStatus == api.ReviewApproved {
 				isDisabled = api.UserNormal
+				if req.RoleNo != "" {
+					roleNo = req.RoleNo
+				}
+			} else {
+				rejectReason = req.RejectReason
 			}
 
-			err := tx.Exec(`UPDATE user SET review_status = ?, is_disabled = ? WHERE id = ?`, req.ReviewStatus, isDisabled, req.UserId).
+			err := tx.Exec(`UPDATE user SET review_status = ?, is_disabled = ?, role_no = ?, reject_reason = ? WHERE id = ?`,
+				req.ReviewStatus, isDisabled, roleNo, rejectReason, req.UserId).
 				Error
 
 			if err != nil {
 				rail.Errorf("failed to update user for registration review, userId: %v, %v", req.UserId, err)
+				return err
 			}
-			return err
+			reviewed = user
+			return nil
 		},
 	)
+	if err != nil {
+		return err
+	}
+
+	if err := InvalidateUserInfoCache(rail, reviewed.Username); err != nil {
+		rail.Errorf("Failed to invalidate user info cache, username: %v, %v", reviewed.Username, err)
+	}
+	notifyRegistrationReviewed(rail, reviewed, req.ReviewStatus, req.RejectReason)
+	return nil
+}
+
+type BulkReviewUserRes struct {
+	Reviewed []int               `json:"reviewed" desc:"id of users reviewed"`
+	Failed   []BulkReviewFailure `json:"failed" desc:"users that failed to be reviewed"`
+}
+
+type BulkReviewFailure struct {
+	UserId int    `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+func BulkReviewUserRegistration(rail miso.Rail, tx *gorm.DB, req AdminBulkReviewUserReq) (BulkReviewUserRes, error) {
+	res := BulkReviewUserRes{Reviewed: []int{}, Failed: []BulkReviewFailure{}}
+	reviewed := util.NewSet[int]()
+	for _, userId := range req.UserIds {
+		if !reviewed.Add(userId) {
+			continue
+		}
+		err := ReviewUserRegistration(rail, tx, AdminReviewUserReq{
+			UserId:       userId,
+			ReviewStatus: req.ReviewStatus,
+			RoleNo:       req.RoleNo,
+			RejectReason: req.RejectReason,
+		})
+		if err != nil {
+			rail.Warnf("Failed to review user registration, userId: %v, %v", userId, err)
+			res.Failed = append(res.Failed, BulkReviewFailure{UserId: userId, Reason: err.Error()})
+			continue
+		}
+		res.Reviewed = append(res.Reviewed, userId)
+	}
+	return res, nil
+}
+
+func notifyRegistrationReviewed(rail miso.Rail, user User, reviewStatus string, rejectReason string) {
+	var title, msg string
+	if reviewStatus == api.ReviewApproved {
+		title = "Your registration has been approved"
+		msg = fmt.Sprintf("Welcome %v, your registration has been approved.", user.Username)
+	} else {
+		title = "Your registration has been rejected"
+		msg = fmt.Sprintf("Sorry %v, your registration has been rejected.", user.Username)
+		if rejectReason != "" {
+			msg += " Reason: " + rejectReason
+		}
+	}
+
+	err := api.CreateNotifiPipeline.Send(rail, api.CreateNotifiEvent{
+		Title:           title,
+		Message:         msg,
+		ReceiverUserNos: []string{user.UserNo},
+	})
+	if err != nil {
+		rail.Errorf("failed to create notification for registration review, userNo: %v, %v", user.UserNo, err)
+	}
+}
+
+type PendingRegistration struct {
+	Id          int        `json:"id"`
+	UserNo      string     `json:"userNo"`
+	Username    string     `json:"username"`
+	CreateTime  util.ETime `json:"createTime"`
+	PendingDays int        `json:"pendingDays" desc:"number of days since the registration was requested"`
+}
+
+func ListPendingRegistrations(rail miso.Rail, tx *gorm.DB, req ListPendingRegistrationReq) (miso.PageRes[PendingRegistration], error) {
+	now := util.Now()
+	return mysql.NewPageQuery[PendingRegistration]().
+		WithPage(req.Paging).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id, user_no, username, create_time").Order("id ASC")
+		}).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("user").
+				Where("review_status = ?", api.ReviewPending).
+				Where("is_del = 0")
+		}).
+		ForEach(func(p PendingRegistration) PendingRegistration {
+			p.PendingDays = int(now.Sub(p.CreateTime) / (24 * time.Hour))
+			return p
+		}).
+		Exec(rail, tx)
+}
+
+// Expire registrations that have been pending for too long.
+func ExpireStaleRegistrations(rail miso.Rail) error {
+	days := miso.GetPropInt(PropRegistrationExpireDays)
+	if days < 1 {
+		return nil
+	}
+
+	var users []User
+	deadline := util.Now().AddDate(0, 0, -days)
+	err := mysql.GetMySQL().
+		Raw(`SELECT id, user_no, username FROM user WHERE review_status = ? AND is_del = 0 AND create_time < ?`,
+			api.ReviewPending, deadline).
+		Scan(&users).Error
+	if err != nil {
+		return fmt.Errorf("failed to list stale registrations, %w", err)
+	}
+
+	reason := fmt.Sprintf("Registration was not reviewed within %v days", days)
+	for _, u := range users {
+		err := ReviewUserRegistration(rail, mysql.GetMySQL(), AdminReviewUserReq{
+			UserId:       u.Id,
+			ReviewStatus: api.ReviewRejected,
+			RejectReason: reason,
+		})
+		if err != nil {
+			rail.Errorf("Failed to expire registration, userId: %v, %v", u.Id, err)
+			continue
+		}
+		rail.Infof("Registration of user %v expired", u.Username)
+	}
+	return nil
 }
 
 func UserRegister(rail miso.Rail, db *gorm.DB, req RegisterReq) error {
+	if miso.GetPropBool(PropChallengeRegistrationEnabled) {
+		if err := VerifyChallenge(rail, req.ChallengeId, req.ChallengeSolution); err != nil {
+			return err
+		}
+	}
+
 	if err := NewUser(rail, db, CreateUserParam{
 		Username:     req.Username,
 		Password:     req.Password,
@@ -473,6 +760,13 @@ func LoadUserInfoBrief(rail miso.Rail, tx *gorm.DB, username string) (UserDetail
 		RegisterDate: u.CreateTime.FormatClassic(),
 		Salt:         u.Salt,
 		Password:     u.Password,
+		Email:        u.Email,
+		DisplayName:  u.DisplayName,
+		Phone:        u.Phone,
+		Attributes:   u.Attributes,
+
+		EmailVerified:     u.EmailVerified,
+		EmailNotification: u.EmailNotification,
 	}, nil
 }
 
@@ -533,6 +827,25 @@ func DecodeTokenUser(rail miso.Rail, token string) (TokenUser, error) {
 	tu.Username = decoded.Claims["username"].(string)
 	tu.UserNo = decoded.Claims["userno"].(string)
 	tu.RoleNo = decoded.Claims["roleno"].(string)
+	if roles, ok := decoded.Claims["roles"].([]any); ok {
+		for _, r := range roles {
+			if rs, ok := r.(string); ok {
+				tu.RoleNos = append(tu.RoleNos, rs)
+			}
+		}
+	} else if tu.RoleNo != "" {
+		tu.RoleNos = []string{tu.RoleNo} // tokens issued before multiple roles are introduced
+	}
+	if lm, ok := decoded.Claims["loginmethod"].(string); ok && lm != "" {
+		tu.LoginMethod = lm
+	} else {
+		tu.LoginMethod = LoginMethodPassword // tokens issued before login method is introduced
+	}
+	if iatms, ok := decoded.Claims["iatms"].(float64); ok {
+		tu.IssuedAt = int64(iatms)
+	} else if iat, ok := decoded.Claims["iat"].(float64); ok {
+		tu.IssuedAt = int64(iat) * 1000 // tokens issued before 'iatms' is introduced
+	}
 	return tu, nil
 }
 
@@ -554,12 +867,31 @@ func ExchangeToken(rail miso.Rail, tx *gorm.DB, req ExchangeTokenReq) (string, e
 	if err != nil {
 		return "", err
 	}
+	if err := checkTokenRevoked(rail, u.UserNo, u.IssuedAt); err != nil {
+		return "", err
+	}
+
+	// the user may be renamed, disabled or assigned another primary role since the token is issued
+	cur, err := loadUserByUserNo(rail, tx, u.UserNo)
+	if err != nil {
+		return "", err
+	}
+	if err := checkUserCanLogin(cur); err != nil {
+		return "", err
+	}
+
+	roleNos, err := listUserRoleNos(rail, tx, u.UserNo)
+	if err != nil {
+		return "", fmt.Errorf("failed to list roles of user %v, %w", u.UserNo, err)
+	}
 
 	tu := TokenUser{
-		Id:       u.Id,
-		UserNo:   u.UserNo,
-		Username: u.Username,
-		RoleNo:   u.RoleNo,
+		Id:          cur.Id,
+		UserNo:      cur.UserNo,
+		Username:    cur.Username,
+		RoleNo:      cur.RoleNo,
+		RoleNos:     roleNos,
+		LoginMethod: u.LoginMethod,
 	}
 
 	rail.Debugf("buildToken %+v", tu)
@@ -570,12 +902,15 @@ func GetTokenUser(rail miso.Rail, tx *gorm.DB, token string) (UserInfoBrief, err
 	if util.IsBlankStr(token) {
 		return UserInfoBrief{}, miso.NewErrf("Invalid token").WithInternalMsg("Token is blank")
 	}
-	username, err := DecodeTokenUsername(rail, token)
+	tu, err := DecodeTokenUser(rail, token)
 	if err != nil {
 		return UserInfoBrief{}, err
 	}
+	if err := checkTokenRevoked(rail, tu.UserNo, tu.IssuedAt); err != nil {
+		return UserInfoBrief{}, err
+	}
 
-	u, err := LoadUserBriefThrCache(rail, tx, username)
+	u, err := LoadUserBriefThrCache(rail, tx, tu.Username)
 
 	if err != nil {
 		return UserInfoBrief{}, err
@@ -655,7 +990,7 @@ func ItnFindNameOfUserNo(rail miso.Rail, tx *gorm.DB, req api.FetchNameByUserNoR
 func ItnFindUsersWithRole(rail miso.Rail, db *gorm.DB, req api.FetchUsersWithRoleReq) ([]api.UserInfo, error) {
 	var users []api.UserInfo
 	err := db.Table("user").
-		Where("role_no = ?", req.RoleNo).
+		Where("user_no IN (SELECT user_no FROM ("+userRoleUnionSql(userRoleByRole)+") t)", userRoleUnionArgs([]string{req.RoleNo})...).
 		Scan(&users).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to list users with roleNo: %v, %w", req.RoleNo, err)
@@ -664,13 +999,47 @@ func ItnFindUsersWithRole(rail miso.Rail, db *gorm.DB, req api.FetchUsersWithRol
 }
 
 func FindUserWithRes(rail miso.Rail, db *gorm.DB, req api.FetchUserWithResourceReq) ([]api.UserInfo, error) {
-	var users []api.UserInfo
-	err := db.Raw(`
-		select u.*, r.name role_name from user u
+	var direct []string
+	codes := append([]string{req.ResourceCode}, resCodePatterns(req.ResourceCode)...)
+	if err := db.Raw(`select distinct role_no from role_resource rr where res_code in ? and effect = ? and `+validGrantCond("rr"),
+		append([]any{codes, ResEffectAllow}, validGrantArgs()...)...).Scan(&direct).Error; err != nil {
+		return nil, err
+	}
+	var directDenies []string
+	if err := db.Raw(`select distinct role_no from role_resource rr where res_code in ? and effect = ? and `+validGrantCond("rr"),
+		append([]any{codes, ResEffectDeny}, validGrantArgs()...)...).Scan(&directDenies).Error; err != nil {
+		return nil, err
+	}
+
+	// roles that inherit the resource from ancestors also have access to it
+	parents, err := loadRoleParents(rail)
+	if err != nil {
+		return nil, err
+	}
+	roleNos := []string{DefaultAdminRoleNo}
+	for _, r := range direct {
+		roleNos = append(roleNos, parents.descendants(r)...)
+	}
+	roleNos = distinctRoleNos(roleNos)
+
+	sql := `select u.*, r.name role_name from user u
 		left join role r on u.role_no = r.role_no
-		left join role_resource rr on r.role_no = rr.role_no
-		where rr.res_code = ? or r.role_no = ?`, req.ResourceCode, DefaultAdminRoleNo).
-		Scan(&users).
-		Error
+		where u.user_no in (select user_no from (` + userRoleUnionSql(userRoleByRole) + `) t)`
+	args := userRoleUnionArgs(roleNos)
+
+	// deny rules override the allows, except for administrators
+	deniedRoleNos := []string{}
+	for _, r := range directDenies {
+		deniedRoleNos = append(deniedRoleNos, parents.descendants(r)...)
+	}
+	if deniedRoleNos = distinctRoleNos(deniedRoleNos); len(deniedRoleNos) > 0 {
+		sql += ` and (u.user_no in (select user_no from (` + userRoleUnionSql(userRoleByRole) + `) t)
+			or u.user_no not in (select user_no from (` + userRoleUnionSql(userRoleByRole) + `) t))`
+		args = append(args, userRoleUnionArgs([]string{DefaultAdminRoleNo})...)
+		args = append(args, userRoleUnionArgs(deniedRoleNos)...)
+	}
+
+	var users []api.UserInfo
+	err = db.Raw(sql, args...).Scan(&users).Error
 	return users, err
 }
@@ -156,3 +156,12 @@ func TestFindUserWithRes(t *testing.T) {
 	}
 	t.Logf("u: %+v", u)
 }
+
+func TestListPendingRegistrations(t *testing.T) {
+	rail := preUserTest(t)
+	res, err := ListPendingRegistrations(rail, mysql.GetMySQL(), ListPendingRegistrationReq{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("res: %+v", res)
+}
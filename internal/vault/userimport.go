@@ -0,0 +1,280 @@
+package vault
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+const (
+	ImportFormatCsv  = "CSV"
+	ImportFormatJson = "JSON"
+
+	importMaxRows = 1000
+
+	generatedPasswordLen = 16
+)
+
+type ImportUserRow struct {
+	Username string `json:"username"`
+	Role     string `json:"role" desc:"role no or role name, optional"`
+	Password string `json:"password" desc:"initial password, a random one is generated if empty"`
+}
+
+type ImportUsersReq struct {
+	Format string          `json:"format" valid:"member:CSV|JSON" desc:"CSV: rows are provided in csv, JSON: rows are provided in rows"`
+	Csv    string          `json:"csv" desc:"csv content with header: username,role,password"`
+	Rows   []ImportUserRow `json:"rows"`
+	DryRun bool            `json:"dryRun" desc:"only validate the rows without importing"`
+}
+
+type ImportedUserRow struct {
+	Line              int      `json:"line" desc:"line number (1-based), for CSV the header is not counted"`
+	Username          string   `json:"username" desc:"normalized username"`
+	RoleNo            string   `json:"roleNo"`
+	GeneratedPassword string   `json:"generatedPassword,omitempty" desc:"password generated, only returned once when the rows are imported"`
+	Errors            []string `json:"errors"`
+
+	password string
+}
+
+type ImportUsersRes struct {
+	DryRun   bool              `json:"dryRun"`
+	Total    int               `json:"total"`
+	Valid    bool              `json:"valid" desc:"whether all rows are valid, nothing is imported if any row is invalid"`
+	Imported int               `json:"imported"`
+	Rows     []ImportedUserRow `json:"rows"`
+}
+
+// Import users in bulk.
+//
+// All rows are validated first, nothing is imported if any row is invalid. Rows are then imported in a single
+// transaction, either all of them are imported or none of them.
+func ImportUsers(rail miso.Rail, db *gorm.DB, req ImportUsersReq, operator common.User) (ImportUsersRes, error) {
+	rows := req.Rows
+	if req.Format == ImportFormatCsv {
+		var err error
+		if rows, err = parseImportCsv(req.Csv); err != nil {
+			return ImportUsersRes{}, err
+		}
+	}
+	if len(rows) < 1 {
+		return ImportUsersRes{}, miso.NewErrf("No rows to import")
+	}
+	if len(rows) > importMaxRows {
+		return ImportUsersRes{}, miso.NewErrf("At most %v rows can be imported at a time", importMaxRows)
+	}
+
+	res := ImportUsersRes{DryRun: req.DryRun, Total: len(rows), Valid: true}
+	res.Rows = validateImportRows(rail, db, rows)
+	checked := util.NewSet[string]()
+	for _, r := range res.Rows {
+		if r.RoleNo == "" || !checked.Add(r.RoleNo) {
+			continue
+		}
+		if err := checkRoleAssignable(rail, db, operator, r.RoleNo); err != nil {
+			return ImportUsersRes{}, err
+		}
+	}
+	for _, r := range res.Rows {
+		if len(r.Errors) > 0 {
+			res.Valid = false
+		}
+	}
+	if !res.Valid || req.DryRun {
+		for i := range res.Rows {
+			res.Rows[i].GeneratedPassword = ""
+		}
+		return res, nil
+	}
+
+	// all rows are imported in one transaction, generated passwords would be lost if only part of them are imported
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, r := range res.Rows {
+			err := NewUser(rail, tx, CreateUserParam{
+				Username:     r.Username,
+				Password:     r.password,
+				RoleNo:       r.RoleNo,
+				ReviewStatus: api.ReviewApproved,
+				Operator:     operator.Username,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to import row %v (%v), %w", r.Line, r.Username, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		rail.Errorf("Failed to import users, nothing is imported, %v", err)
+		return ImportUsersRes{}, miso.NewErrf("Failed to import users, nothing is imported").WithInternalMsg("%v", err)
+	}
+	res.Imported = len(res.Rows)
+	rail.Infof("%v imported %d users", operator.Username, res.Imported)
+	return res, nil
+}
+
+func parseImportCsv(content string) ([]ImportUserRow, error) {
+	r := csv.NewReader(strings.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, miso.NewErrf("CSV is empty")
+		}
+		return nil, miso.NewErrf("Illegal CSV format, %v", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["username"]; !ok {
+		return nil, miso.NewErrf("CSV header must contain column 'username'")
+	}
+	col := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	rows := []ImportUserRow{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, miso.NewErrf("Illegal CSV format, %v", err)
+		}
+		rows = append(rows, ImportUserRow{
+			Username: col(rec, "username"),
+			Role:     col(rec, "role"),
+			Password: col(rec, "password"),
+		})
+	}
+	return rows, nil
+}
+
+func validateImportRows(rail miso.Rail, db *gorm.DB, rows []ImportUserRow) []ImportedUserRow {
+	type role struct {
+		RoleNo string
+		Name   string
+	}
+	var roles []role
+	if err := db.Raw(`SELECT role_no, name FROM role WHERE owner_user_no = ''`).Scan(&roles).Error; err != nil {
+		rail.Errorf("Failed to load roles, %v", err)
+	}
+	resolveRole := func(r string) (string, bool) {
+		for _, v := range roles {
+			if v.RoleNo == r || v.Name == r {
+				return v.RoleNo, true
+			}
+		}
+		return "", false
+	}
+
+	seen := util.NewSet[string]()
+	res := make([]ImportedUserRow, 0, len(rows))
+	for i, row := range rows {
+		ir := ImportedUserRow{Line: i + 1, Username: normalizeUsername(row.Username), Errors: []string{}}
+		addErr := func(err error) {
+			if me, ok := err.(*miso.MisoErr); ok {
+				ir.Errors = append(ir.Errors, me.Msg)
+			} else {
+				ir.Errors = append(ir.Errors, err.Error())
+			}
+		}
+
+		if err := checkNewUsername(ir.Username); err != nil {
+			addErr(err)
+		} else if !seen.Add(ir.Username) {
+			addErr(miso.NewErrf("Duplicate username in rows"))
+		} else if err := checkUsernameAvailable(rail, db, ir.Username, ""); err != nil {
+			addErr(err)
+		}
+
+		if row.Role != "" {
+			if roleNo, ok := resolveRole(row.Role); ok {
+				ir.RoleNo = roleNo
+			} else {
+				addErr(miso.NewErrf("Role '%v' not found", row.Role))
+			}
+		}
+
+		ir.password = row.Password
+		if ir.password == "" {
+			ir.password = util.ERand(generatedPasswordLen)
+			ir.GeneratedPassword = ir.password
+		} else if err := checkNewPassword(ir.password); err != nil {
+			addErr(err)
+		} else if ir.password == ir.Username {
+			addErr(miso.NewErrf("Username and password must be different"))
+		}
+		res = append(res, ir)
+	}
+	return res
+}
+
+// List all users matching the ListUsers filters.
+func ListUsersForExport(rail miso.Rail, tx *gorm.DB, req ListUserReq) ([]api.UserInfo, error) {
+	var users []api.UserInfo
+	err := listUsersBaseQuery(tx, req).
+		Select("u.*, r.name as role_name").
+		Order("u.id DESC").
+		Scan(&users).Error
+	return users, err
+}
+
+func WriteUsersCsv(w io.Writer, users []api.UserInfo) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"userNo", "username", "roleNo", "roleName", "reviewStatus", "isDisabled", "email",
+		"displayName", "phone", "createTime", "createBy"}); err != nil {
+		return err
+	}
+	for _, u := range users {
+		err := cw.Write(csvSafeRow(u.UserNo, u.Username, u.RoleNo, u.RoleName, u.ReviewStatus, strconv.Itoa(u.IsDisabled),
+			u.Email, u.DisplayName, u.Phone, u.CreateTime.FormatClassic(), u.CreateBy))
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Cells that start with '=', '+', '-', '@', tab or carriage return are prefixed with "'", so that they are not
+// interpreted as formulas by spreadsheet applications.
+func csvSafeRow(cells ...string) []string {
+	for i, c := range cells {
+		if c != "" && strings.ContainsRune("=+-@\t\r", rune(c[0])) {
+			cells[i] = "'" + c
+		}
+	}
+	return cells
+}
+
+func exportUsersReq(inb *miso.Inbound) ListUserReq {
+	req := ListUserReq{}
+	if v := inb.Query("username"); v != "" {
+		req.Username = &v
+	}
+	if v := inb.Query("roleNo"); v != "" {
+		req.RoleNo = &v
+	}
+	if v := inb.Query("isDisabled"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			req.IsDisabled = &n
+		}
+	}
+	return req
+}
@@ -0,0 +1,67 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/curtisnewbie/user-vault/api"
+)
+
+func TestParseImportCsv(t *testing.T) {
+	rows, err := parseImportCsv("Username,Role,Password\nalice.w, dev ,secret123\n\"bob,b\",,\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%+v", rows)
+	}
+	if rows[0] != (ImportUserRow{Username: "alice.w", Role: "dev", Password: "secret123"}) {
+		t.Fatalf("%+v", rows[0])
+	}
+	if rows[1] != (ImportUserRow{Username: "bob,b"}) {
+		t.Fatalf("%+v", rows[1])
+	}
+
+	if _, err := parseImportCsv("role,password\ndev,123"); err == nil {
+		t.Fatal("should require username column")
+	}
+	if _, err := parseImportCsv(""); err == nil {
+		t.Fatal("should reject empty csv")
+	}
+}
+
+func TestWriteUsersCsv(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteUsersCsv(&b, []api.UserInfo{{UserNo: "UE1", Username: "alice", DisplayName: "Alice, W"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "UE1,alice,") || !strings.Contains(lines[1], `"Alice, W"`) {
+		t.Fatal(b.String())
+	}
+}
+
+func TestWriteUsersCsvFormula(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteUsersCsv(&b, []api.UserInfo{{UserNo: "UE1", Username: "alice", DisplayName: "=HYPERLINK(\"x\")",
+		Email: "@a.com", Phone: "+86 123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"'=HYPERLINK(""x"")"`) || !strings.Contains(lines[1], ",'@a.com,") ||
+		!strings.Contains(lines[1], ",'+86 123,") {
+		t.Fatal(b.String())
+	}
+
+	for _, c := range []string{"-1", "\tabc", "\rabc"} {
+		if v := csvSafeRow(c)[0]; v != "'"+c {
+			t.Fatalf("%q should be escaped, %q", c, v)
+		}
+	}
+	if v := csvSafeRow("alice", "")[0]; v != "alice" {
+		t.Fatal(v)
+	}
+}
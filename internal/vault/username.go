@@ -0,0 +1,285 @@
+package vault
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+const (
+	// tokens are valid for 15 minutes, but they can be exchanged for new ones, the revocation is kept a bit longer
+	tokenRevocationTtl = 1 * time.Hour
+)
+
+// Normalize username using NFKC and case folding, e.g., 'Ａｄｍｉｎ' and 'Admin' are both normalized to 'admin'.
+func normalizeUsername(username string) string {
+	return cases.Fold().String(norm.NFKC.String(strings.TrimSpace(username)))
+}
+
+type UpdateUsernameReq struct {
+	NewUsername string `json:"newUsername" valid:"notEmpty"`
+	Password    string `json:"password" valid:"notEmpty" desc:"current password"`
+}
+
+type AdminUpdateUsernameReq struct {
+	UserNo      string `json:"userNo" valid:"notEmpty"`
+	NewUsername string `json:"newUsername" valid:"notEmpty"`
+}
+
+func UserUpdateUsername(rail miso.Rail, tx *gorm.DB, req UpdateUsernameReq, user common.User) error {
+	u, err := loadUserByUserNo(rail, tx, user.UserNo)
+	if err != nil {
+		return err
+	}
+	if !checkPassword(u.Password, u.Salt, req.Password) {
+		return miso.NewErrf("Password incorrect")
+	}
+	return renameUser(rail, u, req.NewUsername, user)
+}
+
+func AdminUpdateUsername(rail miso.Rail, tx *gorm.DB, req AdminUpdateUsernameReq, operator common.User) error {
+	u, err := loadUserByUserNo(rail, tx, req.UserNo)
+	if err != nil {
+		return err
+	}
+	if err := checkUserManageable(rail, tx, operator, u.UserNo); err != nil {
+		return err
+	}
+	return renameUser(rail, u, req.NewUsername, operator)
+}
+
+func renameUser(rail miso.Rail, u User, newUsername string, operator common.User) error {
+	newUsername = normalizeUsername(newUsername)
+	if newUsername == u.Username {
+		return miso.NewErrf("New username must be different")
+	}
+	if err := checkNewUsername(newUsername); err != nil {
+		return err
+	}
+
+	return redis.RLockExec(rail, "user-vault:username:"+newUsername, func() error {
+		db := mysql.GetMySQL()
+		if err := checkUsernameAvailable(rail, db, newUsername, u.UserNo); err != nil {
+			return err
+		}
+
+		reservedUntil := util.Now().Add(time.Duration(miso.GetPropInt(PropUsernameReserveDays)) * 24 * time.Hour)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			t := tx.Exec(`UPDATE user SET username = ?, update_by = ? WHERE user_no = ? AND username = ?`,
+				newUsername, operator.Username, u.UserNo, u.Username)
+			if t.Error != nil {
+				return t.Error
+			}
+			if t.RowsAffected < 1 {
+				return miso.NewErrf("User is updated concurrently, please try again")
+			}
+
+			err := tx.Exec(`INSERT INTO username_history (user_no, username, new_username, reserved_until, created_by) VALUES (?, ?, ?, ?, ?)`,
+				u.UserNo, u.Username, newUsername, reservedUntil, operator.Username).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Exec(`UPDATE access_log SET username = ? WHERE user_id = ? AND username = ?`, newUsername, u.Id, u.Username).Error
+		})
+		if err != nil {
+			return err
+		}
+		rail.Infof("User %v renamed to %v by %v, old username reserved until %v", u.Username, newUsername, operator.Username, reservedUntil)
+
+		for _, un := range []string{u.Username, newUsername} {
+			if err := InvalidateUserInfoCache(rail, un); err != nil {
+				rail.Errorf("Failed to invalidate user info cache, username: %v, %v", un, err)
+			}
+		}
+		if err := RevokeUserTokens(rail, u.UserNo); err != nil {
+			rail.Errorf("Failed to revoke tokens of user %v, %v", u.UserNo, err)
+		}
+		return nil
+	})
+}
+
+// Check whether the username is neither taken nor reserved by another user.
+//
+// userNo can be empty if the username is checked for a new user.
+func checkUsernameAvailable(rail miso.Rail, tx *gorm.DB, username string, userNo string) error {
+	var taken int
+	if err := tx.Raw(`SELECT count(*) FROM user WHERE LOWER(username) = LOWER(?) AND user_no != ?`, username, userNo).Scan(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return miso.NewErrf("Username is already used")
+	}
+
+	var reservedBy string
+	err := tx.Raw(`SELECT user_no FROM username_history WHERE LOWER(username) = LOWER(?) AND reserved_until > ? ORDER BY id DESC LIMIT 1`,
+		username, util.Now()).
+		Scan(&reservedBy).Error
+	if err != nil {
+		return err
+	}
+	if reservedBy != "" && reservedBy != userNo {
+		return miso.NewErrf("Username is already used").WithInternalMsg("Username %v is reserved by %v", username, reservedBy)
+	}
+	return nil
+}
+
+type ListedUsernameHistory struct {
+	Id            int        `json:"id"`
+	UserNo        string     `json:"userNo"`
+	Username      string     `json:"username" desc:"previous username"`
+	NewUsername   string     `json:"newUsername"`
+	ReservedUntil util.ETime `json:"reservedUntil" desc:"previous username is reserved until"`
+	CreatedBy     string     `json:"createdBy"`
+	CreateTime    util.ETime `json:"createTime"`
+}
+
+type ListUsernameHistoryReq struct {
+	UserNo string      `json:"userNo" valid:"notEmpty"`
+	Paging miso.Paging `json:"paging"`
+}
+
+func ListUsernameHistory(rail miso.Rail, tx *gorm.DB, req ListUsernameHistoryReq) (miso.PageRes[ListedUsernameHistory], error) {
+	return mysql.NewPageQuery[ListedUsernameHistory]().
+		WithPage(req.Paging).
+		WithSelectQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id, user_no, username, new_username, reserved_until, created_by, create_time").
+				Order("id DESC")
+		}).
+		WithBaseQuery(func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("username_history").Where("user_no = ?", req.UserNo)
+		}).
+		Exec(rail, tx)
+}
+
+func tokenRevokedKey(userNo string) string {
+	return "user-vault:token:revoked:" + userNo
+}
+
+// Revoke tokens issued to the user so far.
+func RevokeUserTokens(rail miso.Rail, userNo string) error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := redis.GetRedis().Set(tokenRevokedKey(userNo), now, tokenRevocationTtl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke tokens, %w", err)
+	}
+	rail.Infof("Revoked tokens of user %v issued before %v", userNo, now)
+	return nil
+}
+
+// Check whether the token issued at iat (unix milliseconds) is revoked.
+func checkTokenRevoked(rail miso.Rail, userNo string, iat int64) error {
+	revoked, err := IsTokenRevoked(rail, userNo, iat)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return miso.NewErrf("Token is revoked, please login again").WithInternalMsg("Token of user %v issued at %v is revoked", userNo, iat)
+	}
+	return nil
+}
+
+// Whether the token issued at iat (unix milliseconds) is revoked.
+func IsTokenRevoked(rail miso.Rail, userNo string, iat int64) (bool, error) {
+	revokedAt, err := redis.GetRedis().Get(tokenRevokedKey(userNo)).Int64()
+	if err != nil {
+		if redis.IsNil(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check token revocation, %w", err)
+	}
+	return issuedBeforeRevocation(iat, revokedAt), nil
+}
+
+// Both iat and revokedAt are in unix milliseconds, revocations recorded in seconds (before milliseconds are used)
+// cover the whole second.
+func issuedBeforeRevocation(iat int64, revokedAt int64) bool {
+	if revokedAt < 1e12 {
+		revokedAt = revokedAt*1000 + 999
+	}
+	return iat <= revokedAt
+}
+
+type CollidedUser struct {
+	UserNo   string `json:"userNo"`
+	Username string `json:"username"`
+}
+
+type UsernameCollision struct {
+	Normalized string         `json:"normalized" desc:"normalized username"`
+	Users      []CollidedUser `json:"users" desc:"users with the same normalized username"`
+}
+
+type UsernameCollisionReport struct {
+	Collisions    []UsernameCollision `json:"collisions" desc:"usernames that collide after normalization, these must be renamed manually"`
+	NotNormalized []CollidedUser      `json:"notNormalized" desc:"usernames that are not normalized but don't collide with others"`
+}
+
+// Report usernames registered before normalization is introduced.
+func ReportUsernameCollisions(rail miso.Rail, tx *gorm.DB) (UsernameCollisionReport, error) {
+	var users []CollidedUser
+	if err := tx.Raw(`SELECT user_no, username FROM user WHERE is_del = 0 ORDER BY id`).Scan(&users).Error; err != nil {
+		return UsernameCollisionReport{}, err
+	}
+	return buildUsernameCollisionReport(users), nil
+}
+
+func buildUsernameCollisionReport(users []CollidedUser) UsernameCollisionReport {
+	r := UsernameCollisionReport{Collisions: []UsernameCollision{}, NotNormalized: []CollidedUser{}}
+	grouped := map[string][]CollidedUser{}
+	keys := []string{}
+	for _, u := range users {
+		n := normalizeUsername(u.Username)
+		if _, ok := grouped[n]; !ok {
+			keys = append(keys, n)
+		}
+		grouped[n] = append(grouped[n], u)
+	}
+
+	for _, k := range keys {
+		g := grouped[k]
+		if len(g) > 1 {
+			r.Collisions = append(r.Collisions, UsernameCollision{Normalized: k, Users: g})
+		} else if g[0].Username != k {
+			r.NotNormalized = append(r.NotNormalized, g[0])
+		}
+	}
+	return r
+}
+
+type NormalizeUsernamesRes struct {
+	Renamed []CollidedUser `json:"renamed" desc:"users renamed, username is the previous one"`
+	Failed  []CollidedUser `json:"failed" desc:"users that failed to be renamed, username is the previous one"`
+}
+
+// Rename users whose usernames are not normalized, collided usernames are skipped.
+func NormalizeUsernames(rail miso.Rail, tx *gorm.DB, operator common.User) (NormalizeUsernamesRes, error) {
+	res := NormalizeUsernamesRes{Renamed: []CollidedUser{}, Failed: []CollidedUser{}}
+	report, err := ReportUsernameCollisions(rail, tx)
+	if err != nil {
+		return res, err
+	}
+
+	for _, c := range report.NotNormalized {
+		u, err := loadUserByUserNo(rail, tx, c.UserNo)
+		if err == nil {
+			err = renameUser(rail, u, c.Username, operator)
+		}
+		if err != nil {
+			rail.Errorf("Failed to normalize username %v, %v", c.Username, err)
+			res.Failed = append(res.Failed, c)
+			continue
+		}
+		res.Renamed = append(res.Renamed, c)
+	}
+	return res, nil
+}
@@ -0,0 +1,51 @@
+package vault
+
+import "testing"
+
+func TestNormalizeUsername(t *testing.T) {
+	cases := map[string]string{
+		"admin":                  "admin",
+		"Admin":                  "admin",
+		" ADMIN ":                "admin",
+		"Ａｄｍｉｎ":                  "admin",
+		"yongj.zhuang@Gmail.com": "yongj.zhuang@gmail.com",
+	}
+	for in, expected := range cases {
+		if v := normalizeUsername(in); v != expected {
+			t.Fatalf("%q -> %q, expected %q", in, v, expected)
+		}
+	}
+}
+
+func TestBuildUsernameCollisionReport(t *testing.T) {
+	r := buildUsernameCollisionReport([]CollidedUser{
+		{UserNo: "1", Username: "admin"},
+		{UserNo: "2", Username: "Admin"},
+		{UserNo: "3", Username: "Banana"},
+		{UserNo: "4", Username: "carrot"},
+	})
+	if len(r.Collisions) != 1 || r.Collisions[0].Normalized != "admin" || len(r.Collisions[0].Users) != 2 {
+		t.Fatalf("%+v", r.Collisions)
+	}
+	if len(r.NotNormalized) != 1 || r.NotNormalized[0].UserNo != "3" {
+		t.Fatalf("%+v", r.NotNormalized)
+	}
+}
+
+func TestIssuedBeforeRevocation(t *testing.T) {
+	revokedAt := int64(1700000000200)
+	if !issuedBeforeRevocation(revokedAt-1000, revokedAt) {
+		t.Fatal("token issued before revocation should be revoked")
+	}
+	if !issuedBeforeRevocation(1700000000100, revokedAt) {
+		t.Fatal("token issued in the same second but before revocation should be revoked")
+	}
+	if issuedBeforeRevocation(1700000000300, revokedAt) {
+		t.Fatal("token issued in the same second but after revocation should not be revoked")
+	}
+
+	// revocation recorded in seconds covers the whole second
+	if !issuedBeforeRevocation(1700000000900, 1700000000) || issuedBeforeRevocation(1700000001000, 1700000000) {
+		t.Fatal("revocation in seconds should cover the whole second")
+	}
+}
@@ -0,0 +1,205 @@
+package vault
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/user-vault/common"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/curtisnewbie/user-vault/api"
+	"gorm.io/gorm"
+)
+
+type GrantUserRoleReq struct {
+	UserNo     string      `json:"userNo" valid:"notEmpty"`
+	RoleNo     string      `json:"roleNo" valid:"notEmpty"`
+	ValidFrom  *util.ETime `json:"validFrom" desc:"optional, when the grant becomes valid"`
+	ValidUntil *util.ETime `json:"validUntil" desc:"optional, when the grant expires"`
+}
+
+type RevokeUserRoleReq struct {
+	UserNo string `json:"userNo" valid:"notEmpty"`
+	RoleNo string `json:"roleNo" valid:"notEmpty"`
+}
+
+type ListUserRolesReq struct {
+	UserNo string `json:"userNo" valid:"notEmpty"`
+}
+
+type UserRole struct {
+	RoleNo     string      `json:"roleNo"`
+	RoleName   string      `json:"roleName"`
+	Primary    bool        `json:"primary" desc:"whether the role is the user's primary role, i.e., user.role_no"`
+	GroupNo    string      `json:"groupNo" desc:"group that grants the role to the user, empty if the role is granted to the user directly"`
+	GroupName  string      `json:"groupName"`
+	ValidFrom  *util.ETime `json:"validFrom"`
+	ValidUntil *util.ETime `json:"validUntil"`
+	CreateTime util.ETime  `json:"createTime"`
+	CreateBy   string      `json:"createBy"`
+}
+
+// Grant additional role to user, the primary role (user.role_no) is still managed by AdminUpdateUser.
+//
+// If the role is granted already, the validity period of the grant is updated.
+func GrantUserRole(rail miso.Rail, tx *gorm.DB, req GrantUserRoleReq, operator common.User) error {
+	if operator.UserNo == req.UserNo {
+		return miso.NewErrf("You cannot grant role to yourself")
+	}
+	if err := checkGrantValidity(req.ValidFrom, req.ValidUntil, util.Now()); err != nil {
+		return err
+	}
+	if _, err := GetRoleInfo(rail, api.RoleInfoReq{RoleNo: req.RoleNo}); err != nil {
+		return err
+	}
+	if err := checkRoleAssignable(rail, tx, operator, req.RoleNo); err != nil {
+		return err
+	}
+
+	return lockUserRole(rail, req.UserNo, func() error {
+		u, err := loadUserByUserNo(rail, tx, req.UserNo)
+		if err != nil {
+			return err
+		}
+		if u.RoleNo == req.RoleNo {
+			return miso.NewErrf("User already has the role")
+		}
+
+		var id int
+		if err := tx.Raw(`SELECT id FROM user_role WHERE user_no = ? AND role_no = ?`, req.UserNo, req.RoleNo).Scan(&id).Error; err != nil {
+			return err
+		}
+		if id > 0 {
+			err = tx.Exec(`UPDATE user_role SET valid_from = ?, valid_until = ?, expiry_notified = 0 WHERE id = ?`,
+				req.ValidFrom, req.ValidUntil, id).Error
+		} else {
+			err = tx.Exec(`INSERT INTO user_role (user_no, role_no, valid_from, valid_until, create_by) VALUES (?, ?, ?, ?, ?)`,
+				req.UserNo, req.RoleNo, req.ValidFrom, req.ValidUntil, operator.Username).Error
+		}
+		if err != nil {
+			return err
+		}
+		rail.Infof("Role %v granted to user %v by %v, valid from %v until %v", req.RoleNo, u.Username, operator.Username,
+			req.ValidFrom, req.ValidUntil)
+		return nil
+	})
+}
+
+// Revoke additional role from user, tokens issued before are revoked as well since they carry the role list.
+func RevokeUserRole(rail miso.Rail, tx *gorm.DB, req RevokeUserRoleReq, operator common.User) error {
+	if err := checkRoleAssignable(rail, tx, operator, req.RoleNo); err != nil {
+		return err
+	}
+	return lockUserRole(rail, req.UserNo, func() error {
+		t := tx.Exec(`DELETE FROM user_role WHERE user_no = ? AND role_no = ?`, req.UserNo, req.RoleNo)
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected < 1 {
+			return miso.NewErrf("User doesn't have the role")
+		}
+		rail.Infof("Role %v revoked from user %v by %v", req.RoleNo, req.UserNo, operator.Username)
+
+		if err := RevokeUserTokens(rail, req.UserNo); err != nil {
+			rail.Errorf("Failed to revoke tokens of user %v, %v", req.UserNo, err)
+		}
+		return nil
+	})
+}
+
+// List roles of user, including the primary one and the ones granted to the user's groups.
+func ListUserRoles(rail miso.Rail, tx *gorm.DB, req ListUserRolesReq) ([]UserRole, error) {
+	var roles []UserRole
+	err := tx.Raw(`
+		SELECT u.role_no, r.name role_name, 1 AS 'primary', u.create_time, u.create_by, '' group_no, '' group_name,
+			NULL valid_from, NULL valid_until FROM user u
+		LEFT JOIN role r ON u.role_no = r.role_no
+		WHERE u.user_no = ? AND u.role_no != ''
+		UNION ALL
+		SELECT ur.role_no, r.name role_name, 0 AS 'primary', ur.create_time, ur.create_by, '' group_no, '' group_name,
+			ur.valid_from, ur.valid_until FROM user_role ur
+		LEFT JOIN role r ON ur.role_no = r.role_no
+		WHERE ur.user_no = ?
+		UNION ALL
+		SELECT gr.role_no, r.name role_name, 0 AS 'primary', gr.create_time, gr.create_by, g.group_no, g.name group_name,
+			NULL valid_from, NULL valid_until FROM user_group_member m
+		JOIN user_group_role gr ON m.group_no = gr.group_no
+		LEFT JOIN user_group g ON m.group_no = g.group_no
+		LEFT JOIN role r ON gr.role_no = r.role_no
+		WHERE m.user_no = ?`, req.UserNo, req.UserNo, req.UserNo).
+		Scan(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []UserRole{}
+	}
+	return roles, nil
+}
+
+const (
+	userRoleByUser = "user_no"
+	userRoleByRole = "role_no"
+)
+
+// Union of (user_no, role_no) pairs, including the primary roles, granted roles and roles granted to groups.
+//
+// Pairs are filtered by user_no or role_no (by) in each branch of the union, so that the indexes can be used.
+// Only grants that are currently valid are included, the query takes userRoleUnionArgs() as args.
+func userRoleUnionSql(by string) string {
+	groupCol := "m.user_no"
+	if by == userRoleByRole {
+		groupCol = "gr.role_no"
+	}
+	return `
+	SELECT user_no, role_no, 0 seq FROM user WHERE ` + by + ` IN ? AND role_no != ''
+	UNION
+	SELECT user_no, role_no, 1 seq FROM user_role ur WHERE ur.` + by + ` IN ? AND ` + validGrantCond("ur") + `
+	UNION
+	SELECT m.user_no, gr.role_no, 2 seq FROM user_group_member m JOIN user_group_role gr ON m.group_no = gr.group_no
+	WHERE ` + groupCol + ` IN ?`
+}
+
+// Args of userRoleUnionSql, values are the user nos or role nos.
+func userRoleUnionArgs(values []string) []any {
+	return append(append([]any{values, values}, validGrantArgs()...), values)
+}
+
+// List role nos of user, including the ones granted to the user's groups, the primary role always comes first.
+func listUserRoleNos(rail miso.Rail, tx *gorm.DB, userNo string) ([]string, error) {
+	var roleNos []string
+	err := tx.Raw(`SELECT role_no FROM (`+userRoleUnionSql(userRoleByUser)+`) t ORDER BY seq`, userRoleUnionArgs([]string{userNo})...).
+		Scan(&roleNos).Error
+	if err != nil {
+		return nil, err
+	}
+	return distinctRoleNos(roleNos), nil
+}
+
+// Remove blank and duplicate role nos while preserving the order.
+func distinctRoleNos(roleNos []string) []string {
+	seen := util.NewSet[string]()
+	distinct := make([]string, 0, len(roleNos))
+	for _, r := range roleNos {
+		r = strings.TrimSpace(r)
+		if r == "" || !seen.Add(r) {
+			continue
+		}
+		distinct = append(distinct, r)
+	}
+	return distinct
+}
+
+// Check whether the user holds the role, either as the primary one or granted.
+func userHasRole(rail miso.Rail, tx *gorm.DB, userNo string, roleNo string) (bool, error) {
+	roleNos, err := listUserRoleNos(rail, tx, userNo)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(roleNos, roleNo), nil
+}
+
+func lockUserRole(rail miso.Rail, userNo string, runnable redis.Runnable) error {
+	return redis.RLockExec(rail, "user-vault:user:role:"+userNo, runnable)
+}
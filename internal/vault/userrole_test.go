@@ -0,0 +1,37 @@
+package vault
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDistinctRoleNos(t *testing.T) {
+	v := distinctRoleNos([]string{"role_b", "", "role_a", " role_b", "role_c", "role_a"})
+	if !slices.Equal(v, []string{"role_b", "role_a", "role_c"}) {
+		t.Fatal(v)
+	}
+	if v := distinctRoleNos(nil); len(v) != 0 {
+		t.Fatal(v)
+	}
+}
+
+func TestUserRoleUnionSql(t *testing.T) {
+	args := userRoleUnionArgs([]string{"role_a"})
+	for _, by := range []string{userRoleByUser, userRoleByRole} {
+		sql := userRoleUnionSql(by)
+		if strings.Count(sql, "?") != len(args) {
+			t.Fatalf("%v: %v", by, sql)
+		}
+		// each branch of the union is filtered
+		for _, b := range strings.Split(sql, "UNION") {
+			if !strings.Contains(b, by+" IN ?") {
+				t.Fatalf("%v: branch is not filtered, %v", by, b)
+			}
+		}
+	}
+	if !strings.Contains(userRoleUnionSql(userRoleByRole), "gr.role_no IN ?") ||
+		!strings.Contains(userRoleUnionSql(userRoleByUser), "m.user_no IN ?") {
+		t.Fatal("group branch should be filtered by the group tables")
+	}
+}
@@ -1,6 +1,8 @@
 package vault
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/curtisnewbie/miso/middleware/mysql"
@@ -24,13 +26,16 @@ var (
 	fetchUserInfoHisto       = miso.NewPromHisto("user_vault_fetch_user_info_duration")
 	tokenExchangeHisto       = miso.NewPromHisto("user_vault_token_exchange_duration")
 	resourceAccessCheckHisto = miso.NewPromHisto("user_vault_resource_access_check_duration")
+	batchAccessCheckHisto    = miso.NewPromHisto("user_vault_batch_access_check_duration")
 )
 
 type LoginReq struct {
-	Username      string `json:"username" valid:"notEmpty"`
-	Password      string `json:"password" valid:"notEmpty"`
-	XForwardedFor string `header:"x-forwarded-for"`
-	UserAgent     string `header:"user-agent"`
+	Username          string `json:"username" valid:"notEmpty"`
+	Password          string `json:"password" valid:"notEmpty"`
+	ChallengeId       string `json:"challengeId" desc:"challenge id, required after multiple login failures"`
+	ChallengeSolution string `json:"challengeSolution" desc:"solution of the challenge"`
+	XForwardedFor     string `header:"x-forwarded-for"`
+	UserAgent         string `header:"user-agent"`
 }
 
 type AdminAddUserReq struct {
@@ -55,11 +60,26 @@ type AdminUpdateUserReq struct {
 type AdminReviewUserReq struct {
 	UserId       int    `json:"userId" valid:"positive"`
 	ReviewStatus string `json:"reviewStatus"`
+	RoleNo       string `json:"roleNo" desc:"role assigned to the user when the registration is approved"`
+	RejectReason string `json:"rejectReason" valid:"maxLen:255" desc:"reason of the rejection"`
+}
+
+type AdminBulkReviewUserReq struct {
+	UserIds      []int  `json:"userIds" valid:"notEmpty"`
+	ReviewStatus string `json:"reviewStatus"`
+	RoleNo       string `json:"roleNo" desc:"role assigned to the users when the registrations are approved"`
+	RejectReason string `json:"rejectReason" valid:"maxLen:255" desc:"reason of the rejection"`
+}
+
+type ListPendingRegistrationReq struct {
+	Paging miso.Paging `json:"paging"`
 }
 
 type RegisterReq struct {
-	Username string `json:"username" valid:"notEmpty"`
-	Password string `json:"password" valid:"notEmpty"`
+	Username          string `json:"username" valid:"notEmpty"`
+	Password          string `json:"password" valid:"notEmpty"`
+	ChallengeId       string `json:"challengeId" desc:"challenge id"`
+	ChallengeSolution string `json:"challengeSolution" desc:"solution of the challenge"`
 }
 
 type UserInfoRes struct {
@@ -69,6 +89,8 @@ type UserInfoRes struct {
 	RoleNo       string
 	UserNo       string
 	RegisterDate string
+	DisplayName  string
+	Email        string
 }
 
 type GetTokenUserReq struct {
@@ -135,31 +157,84 @@ func RegisterInternalPathResourcesOnBootstrapped(res []auth.Resource) {
 // misoapi-scope: PUBLIC
 func UserLoginEp(inb *miso.Inbound, req LoginReq) (string, error) {
 	rail := inb.Rail()
-	token, user, err := UserLogin(rail, mysql.GetMySQL(),
-		PasswordLoginParam{Username: req.Username, Password: req.Password})
 	remoteAddr := RemoteAddr(req.XForwardedFor)
 	userAgent := req.UserAgent
 
+	// failures are counted by the normalized username
+	loginName := normalizeUsername(req.Username)
+	if err := checkLoginChallenge(rail, loginName, remoteAddr, req.ChallengeId, req.ChallengeSolution); err != nil {
+		return "", err
+	}
+
+	token, user, err := UserLogin(rail, mysql.GetMySQL(),
+		PasswordLoginParam{Username: req.Username, Password: req.Password})
+
 	if er := AccessLogPipeline.Send(rail, AccessLogEvent{
-		IpAddress:  remoteAddr,
-		UserAgent:  userAgent,
-		UserId:     user.Id,
-		Username:   req.Username,
-		Url:        passwordLoginUrl,
-		Success:    err == nil,
-		AccessTime: util.Now(),
+		IpAddress:   remoteAddr,
+		UserAgent:   userAgent,
+		UserId:      user.Id,
+		Username:    req.Username,
+		Url:         passwordLoginUrl,
+		Success:     err == nil,
+		LoginMethod: LoginMethodPassword,
+		AccessTime:  util.Now(),
 	}); er != nil {
 		rail.Errorf("Failed to sendAccessLogEvent, username: %v, remoteAddr: %v, userAgent: %v, %v",
 			req.Username, remoteAddr, userAgent, er)
 	}
 
 	if err != nil {
+		recordLoginFailure(rail, loginName, remoteAddr)
 		return "", err
 	}
+	clearLoginFailure(rail, loginName)
 
 	return token, err
 }
 
+// misoapi-http: POST /open/api/user/login/email/send
+// misoapi-desc: Send single-use login code or login link to user's verified email
+// misoapi-scope: PUBLIC
+func SendEmailLoginEp(inb *miso.Inbound, req SendEmailLoginReq) (any, error) {
+	rail := inb.Rail()
+	return nil, SendEmailLogin(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/user/login/email
+// misoapi-desc: User Login using the code or link sent to the verified email, a JWT token is generated and returned
+// misoapi-scope: PUBLIC
+func EmailLoginEp(inb *miso.Inbound, req EmailLoginReq) (string, error) {
+	rail := inb.Rail()
+	remoteAddr := RemoteAddr(req.XForwardedFor)
+	userAgent := req.UserAgent
+
+	token, user, loginMethod, err := EmailLogin(rail, mysql.GetMySQL(), req)
+
+	username := user.Username
+	if username == "" {
+		username = req.Username
+	}
+	if er := AccessLogPipeline.Send(rail, AccessLogEvent{
+		IpAddress:   remoteAddr,
+		UserAgent:   userAgent,
+		UserId:      user.Id,
+		Username:    username,
+		Url:         emailLoginUrl,
+		Success:     err == nil,
+		LoginMethod: loginMethod,
+		AccessTime:  util.Now(),
+	}); er != nil {
+		rail.Errorf("Failed to sendAccessLogEvent, username: %v, remoteAddr: %v, userAgent: %v, %v",
+			username, remoteAddr, userAgent, er)
+	}
+
+	if err != nil {
+		recordLoginFailure(rail, username, remoteAddr)
+		return "", err
+	}
+	return token, nil
+}
+
 func RemoteAddr(forwardedFor string) string {
 	addr := "unknown"
 
@@ -172,6 +247,13 @@ func RemoteAddr(forwardedFor string) string {
 	return addr
 }
 
+// misoapi-http: GET /open/api/user/challenge
+// misoapi-desc: Issue a proof of work challenge, the challenge is required for registration and for login after multiple failures
+// misoapi-scope: PUBLIC
+func IssueChallengeEp(inb *miso.Inbound) (Challenge, error) {
+	return IssueChallenge(inb.Rail())
+}
+
 // misoapi-http: POST /open/api/user/register/request
 // misoapi-desc: User request registration, approval needed
 // misoapi-scope: PUBLIC
@@ -183,7 +265,11 @@ func UserRegisterEp(inb *miso.Inbound, req RegisterReq) (any, error) {
 // misoapi-desc: Admin create new user
 // misoapi-resource: ref(ResourceManagerUser)
 func AdminAddUserEp(inb *miso.Inbound, req AddUserParam) (any, error) {
-	return nil, NewUser(inb.Rail(), mysql.GetMySQL(), CreateUserParam{
+	rail := inb.Rail()
+	if err := checkRoleAssignable(rail, mysql.GetMySQL(), common.GetUser(rail), req.RoleNo); err != nil {
+		return nil, err
+	}
+	return nil, NewUser(rail, mysql.GetMySQL(), CreateUserParam{
 		Username:     req.Username,
 		Password:     req.Password,
 		RoleNo:       req.RoleNo,
@@ -198,6 +284,33 @@ func AdminListUsersEp(inb *miso.Inbound, req ListUserReq) (miso.PageRes[api.User
 	return ListUsers(inb.Rail(), mysql.GetMySQL(), req)
 }
 
+// misoapi-http: POST /open/api/user/import
+// misoapi-desc: Admin import users in bulk using CSV or JSON rows, rows are validated first, nothing is imported if any row is invalid
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminImportUsersEp(inb *miso.Inbound, req ImportUsersReq) (ImportUsersRes, error) {
+	rail := inb.Rail()
+	return ImportUsers(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: GET /open/api/user/export
+// misoapi-desc: Admin export users as CSV, supports the same filters as /open/api/user/list in query parameters: username, roleNo and isDisabled
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminExportUsersEp(inb *miso.Inbound) {
+	rail := inb.Rail()
+	users, err := ListUsersForExport(rail, mysql.GetMySQL(), exportUsersReq(inb))
+	if err != nil {
+		inb.HandleResult(nil, err)
+		return
+	}
+
+	w, _ := inb.Unwrap()
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+	if err := WriteUsersCsv(w, users); err != nil {
+		rail.Errorf("Failed to write users csv, %v", err)
+	}
+}
+
 // misoapi-http: POST /open/api/user/info/update
 // misoapi-desc: Admin update user info
 // misoapi-resource: ref(ResourceManagerUser)
@@ -211,9 +324,31 @@ func AdminUpdateUserEp(inb *miso.Inbound, req AdminUpdateUserReq) (any, error) {
 // misoapi-resource: ref(ResourceManagerUser)
 func AdminReviewUserEp(inb *miso.Inbound, req AdminReviewUserReq) (any, error) {
 	rail := inb.Rail()
+	if err := checkRoleAssignable(rail, mysql.GetMySQL(), common.GetUser(rail), req.RoleNo); err != nil {
+		return nil, err
+	}
 	return nil, ReviewUserRegistration(rail, mysql.GetMySQL(), req)
 }
 
+// misoapi-http: POST /open/api/user/registration/review/bulk
+// misoapi-desc: Admin review user registrations in bulk
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminBulkReviewUserEp(inb *miso.Inbound, req AdminBulkReviewUserReq) (BulkReviewUserRes, error) {
+	rail := inb.Rail()
+	if err := checkRoleAssignable(rail, mysql.GetMySQL(), common.GetUser(rail), req.RoleNo); err != nil {
+		return BulkReviewUserRes{}, err
+	}
+	return BulkReviewUserRegistration(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/user/registration/pending/list
+// misoapi-desc: Admin list pending user registrations
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListPendingRegistrationsEp(inb *miso.Inbound, req ListPendingRegistrationReq) (miso.PageRes[PendingRegistration], error) {
+	rail := inb.Rail()
+	return ListPendingRegistrations(rail, mysql.GetMySQL(), req)
+}
+
 // misoapi-http: GET /open/api/user/info
 // misoapi-desc: User get user info
 // misoapi-scope: PUBLIC
@@ -239,6 +374,8 @@ func UserGetUserInfoEp(inb *miso.Inbound) (UserInfoRes, error) {
 		RoleNo:       res.RoleNo,
 		UserNo:       res.UserNo,
 		RegisterDate: res.RegisterDate,
+		DisplayName:  res.DisplayName,
+		Email:        res.Email,
 	}, nil
 }
 
@@ -251,6 +388,339 @@ func UserUpdatePasswordEp(inb *miso.Inbound, req UpdatePasswordReq) (any, error)
 	return nil, UpdatePassword(rail, mysql.GetMySQL(), u.Username, req)
 }
 
+// misoapi-http: GET /open/api/user/profile
+// misoapi-desc: User get profile
+// misoapi-resource: ref(ResourceBasicUser)
+func UserGetProfileEp(inb *miso.Inbound) (UserProfile, error) {
+	rail := inb.Rail()
+	return GetUserProfile(rail, mysql.GetMySQL(), common.GetUser(rail).Username)
+}
+
+// misoapi-http: POST /open/api/user/profile/update
+// misoapi-desc: User update profile
+// misoapi-resource: ref(ResourceBasicUser)
+func UserUpdateProfileEp(inb *miso.Inbound, req UpdateProfileReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UserUpdateProfile(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: GET /open/api/user/profile/attributes
+// misoapi-desc: List custom profile attributes defined
+// misoapi-resource: ref(ResourceBasicUser)
+func ListProfileAttributeDefsEp(inb *miso.Inbound) ([]ProfileAttributeDef, error) {
+	return LoadProfileAttributeDefs(), nil
+}
+
+// misoapi-http: POST /open/api/user/profile/admin/update
+// misoapi-desc: Admin update user profile
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminUpdateProfileEp(inb *miso.Inbound, req AdminUpdateProfileReq) (any, error) {
+	rail := inb.Rail()
+	return nil, AdminUpdateProfile(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/email/verification/send
+// misoapi-desc: User request email verification, a verification link is sent to the email address
+// misoapi-resource: ref(ResourceBasicUser)
+func UserSendEmailVerificationEp(inb *miso.Inbound) (any, error) {
+	rail := inb.Rail()
+	return nil, SendEmailVerification(rail, mysql.GetMySQL(), common.GetUser(rail))
+}
+
+// misoapi-http: GET /open/api/user/email/verify
+// misoapi-desc: Verify email address using the token in verification link
+// misoapi-scope: PUBLIC
+func VerifyEmailEp(inb *miso.Inbound, req VerifyEmailReq) (any, error) {
+	rail := inb.Rail()
+	return nil, VerifyEmail(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/user/email/notification/update
+// misoapi-desc: User update preference of receiving notifications by email
+// misoapi-resource: ref(ResourceBasicUser)
+func UserUpdateEmailNotificationEp(inb *miso.Inbound, req UpdateEmailNotificationReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UpdateEmailNotification(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/password/reset/request
+// misoapi-desc: Request password reset, a password reset link is sent to user's verified email
+// misoapi-scope: PUBLIC
+func RequestPasswordResetEp(inb *miso.Inbound, req RequestPasswordResetReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RequestPasswordReset(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/user/password/reset
+// misoapi-desc: Reset password using the token in password reset link
+// misoapi-scope: PUBLIC
+func ResetPasswordEp(inb *miso.Inbound, req ResetPasswordReq) (any, error) {
+	rail := inb.Rail()
+	return nil, ResetPassword(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/user/username/update
+// misoapi-desc: User update username, tokens issued previously are revoked
+// misoapi-resource: ref(ResourceBasicUser)
+func UserUpdateUsernameEp(inb *miso.Inbound, req UpdateUsernameReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UserUpdateUsername(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/username/admin/update
+// misoapi-desc: Admin update username of user, tokens issued previously are revoked
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminUpdateUsernameEp(inb *miso.Inbound, req AdminUpdateUsernameReq) (any, error) {
+	rail := inb.Rail()
+	return nil, AdminUpdateUsername(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/username/history
+// misoapi-desc: Admin list username history of user
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListUsernameHistoryEp(inb *miso.Inbound, req ListUsernameHistoryReq) (miso.PageRes[ListedUsernameHistory], error) {
+	rail := inb.Rail()
+	return ListUsernameHistory(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: GET /open/api/user/username/collisions
+// misoapi-desc: Admin report usernames that are not normalized or collide with each other after normalization
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminReportUsernameCollisionsEp(inb *miso.Inbound) (UsernameCollisionReport, error) {
+	rail := inb.Rail()
+	return ReportUsernameCollisions(rail, mysql.GetMySQL())
+}
+
+// misoapi-http: POST /open/api/user/username/normalize
+// misoapi-desc: Admin rename users whose usernames are not normalized, collided usernames are skipped
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminNormalizeUsernamesEp(inb *miso.Inbound) (NormalizeUsernamesRes, error) {
+	rail := inb.Rail()
+	return NormalizeUsernames(rail, mysql.GetMySQL(), common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/delete
+// misoapi-desc: Admin delete user, the user can be restored within the restore window
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminDeleteUserEp(inb *miso.Inbound, req AdminDeleteUserReq) (any, error) {
+	rail := inb.Rail()
+	return nil, AdminDeleteUser(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/restore
+// misoapi-desc: Admin restore deleted user
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminRestoreUserEp(inb *miso.Inbound, req AdminRestoreUserReq) (any, error) {
+	rail := inb.Rail()
+	return nil, AdminRestoreUser(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/deleted/list
+// misoapi-desc: Admin list deleted users
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListDeletedUsersEp(inb *miso.Inbound, req ListDeletedUsersReq) (miso.PageRes[DeletedUser], error) {
+	rail := inb.Rail()
+	return ListDeletedUsers(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: GET /open/api/user/data/export
+// misoapi-desc: User export personal data as a JSON archive
+// misoapi-resource: ref(ResourceBasicUser)
+func UserExportDataEp(inb *miso.Inbound) {
+	rail := inb.Rail()
+	user := common.GetUser(rail)
+	archive, err := ExportUserData(rail, mysql.GetMySQL(), user)
+	if err != nil {
+		inb.HandleResult(nil, err)
+		return
+	}
+
+	w, _ := inb.Unwrap()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-data-%v.json"`, user.UserNo))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(archive); err != nil {
+		rail.Errorf("Failed to write user data archive, %v", err)
+	}
+}
+
+// misoapi-http: POST /open/api/user/role/grant
+// misoapi-desc: Admin grant additional role to user
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminGrantUserRoleEp(inb *miso.Inbound, req GrantUserRoleReq) (any, error) {
+	rail := inb.Rail()
+	return nil, GrantUserRole(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/role/revoke
+// misoapi-desc: Admin revoke additional role from user
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminRevokeUserRoleEp(inb *miso.Inbound, req RevokeUserRoleReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RevokeUserRole(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/user/role/list
+// misoapi-desc: Admin list roles of user
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListUserRolesEp(inb *miso.Inbound, req ListUserRolesReq) ([]UserRole, error) {
+	rail := inb.Rail()
+	return ListUserRoles(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/group/add
+// misoapi-desc: Admin add user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminAddGroupEp(inb *miso.Inbound, req AddGroupReq) (AddGroupRes, error) {
+	rail := inb.Rail()
+	return AddGroup(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/update
+// misoapi-desc: Admin update user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminUpdateGroupEp(inb *miso.Inbound, req UpdateGroupReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UpdateGroup(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/delete
+// misoapi-desc: Admin delete user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminDeleteGroupEp(inb *miso.Inbound, req DeleteGroupReq) (any, error) {
+	rail := inb.Rail()
+	return nil, DeleteGroup(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/list
+// misoapi-desc: Admin list user groups
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListGroupsEp(inb *miso.Inbound, req ListGroupsReq) (miso.PageRes[ListedGroup], error) {
+	rail := inb.Rail()
+	return ListGroups(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/group/member/add
+// misoapi-desc: Admin add users to user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminAddGroupMembersEp(inb *miso.Inbound, req AddGroupMembersReq) (any, error) {
+	rail := inb.Rail()
+	return nil, AddGroupMembers(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/member/remove
+// misoapi-desc: Admin remove user from user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminRemoveGroupMemberEp(inb *miso.Inbound, req RemoveGroupMemberReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RemoveGroupMember(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/member/list
+// misoapi-desc: Admin list members of user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListGroupMembersEp(inb *miso.Inbound, req ListGroupMembersReq) (miso.PageRes[ListedGroupMember], error) {
+	rail := inb.Rail()
+	return ListGroupMembers(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/group/role/grant
+// misoapi-desc: Admin grant role to user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminGrantGroupRoleEp(inb *miso.Inbound, req GrantGroupRoleReq) (any, error) {
+	rail := inb.Rail()
+	return nil, GrantGroupRole(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/role/revoke
+// misoapi-desc: Admin revoke role from user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminRevokeGroupRoleEp(inb *miso.Inbound, req RevokeGroupRoleReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RevokeGroupRole(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/group/role/list
+// misoapi-desc: Admin list roles of user group
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListGroupRolesEp(inb *miso.Inbound, req ListGroupRolesReq) ([]ListedGroupRole, error) {
+	rail := inb.Rail()
+	return ListGroupRoles(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/access-request/create
+// misoapi-desc: User request access to resource
+// misoapi-resource: ref(ResourceBasicUser)
+func CreateAccessRequestEp(inb *miso.Inbound, req CreateAccessRequestReq) (CreateAccessRequestRes, error) {
+	rail := inb.Rail()
+	return CreateAccessRequest(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/access-request/cancel
+// misoapi-desc: User cancel pending access request
+// misoapi-resource: ref(ResourceBasicUser)
+func CancelAccessRequestEp(inb *miso.Inbound, req CancelAccessRequestReq) (any, error) {
+	rail := inb.Rail()
+	return nil, CancelAccessRequest(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/access-request/list/mine
+// misoapi-desc: User list own access requests
+// misoapi-resource: ref(ResourceBasicUser)
+func ListMyAccessRequestsEp(inb *miso.Inbound, req ListMyAccessRequestsReq) (miso.PageRes[ListedAccessRequest], error) {
+	rail := inb.Rail()
+	return ListMyAccessRequests(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/access-request/list
+// misoapi-desc: Approver list access requests of all users
+// misoapi-resource: ref(ResourceBasicUser)
+func ListAccessRequestsEp(inb *miso.Inbound, req ListAccessRequestsReq) (miso.PageRes[ListedAccessRequest], error) {
+	rail := inb.Rail()
+	return ListAccessRequests(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/access-request/approve
+// misoapi-desc: Approver approve access request, the resource is granted to the requester
+// misoapi-resource: ref(ResourceBasicUser)
+func ApproveAccessRequestEp(inb *miso.Inbound, req ReviewAccessRequestReq) (any, error) {
+	rail := inb.Rail()
+	return nil, ApproveAccessRequest(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/access-request/deny
+// misoapi-desc: Approver deny access request
+// misoapi-resource: ref(ResourceBasicUser)
+func DenyAccessRequestEp(inb *miso.Inbound, req ReviewAccessRequestReq) (any, error) {
+	rail := inb.Rail()
+	return nil, DenyAccessRequest(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/admin-scope/add
+// misoapi-desc: Administrator add scope to delegated admin
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminAddAdminScopeEp(inb *miso.Inbound, req AddAdminScopeReq) (any, error) {
+	rail := inb.Rail()
+	return nil, AddAdminScope(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/admin-scope/remove
+// misoapi-desc: Administrator remove scope from delegated admin
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminRemoveAdminScopeEp(inb *miso.Inbound, req RemoveAdminScopeReq) (any, error) {
+	rail := inb.Rail()
+	return nil, RemoveAdminScope(rail, mysql.GetMySQL(), req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/admin-scope/list
+// misoapi-desc: Admin list scopes of delegated admins
+// misoapi-resource: ref(ResourceManagerUser)
+func AdminListAdminScopesEp(inb *miso.Inbound, req ListAdminScopesReq) ([]AdminScope, error) {
+	rail := inb.Rail()
+	return ListAdminScopes(rail, mysql.GetMySQL(), req)
+}
+
 // misoapi-http: POST /open/api/token/exchange
 // misoapi-desc: Exchange token
 // misoapi-scope: PUBLIC
@@ -343,7 +813,11 @@ func ListUserAccessibleResEp(inb *miso.Inbound) ([]ResBrief, error) {
 	if u.IsNil {
 		return []ResBrief{}, nil
 	}
-	return ListAllResBriefsOfRole(rail, u.RoleNo)
+	roleNos, err := listUserRoleNos(rail, mysql.GetMySQL(), u.UserNo)
+	if err != nil {
+		return nil, err
+	}
+	return ListAllResBriefsOfRoles(rail, roleNos)
 }
 
 // misoapi-http: GET /open/api/resource/brief/all
@@ -354,8 +828,56 @@ func ListAllResBriefEp(inb *miso.Inbound) ([]ResBrief, error) {
 	return ListAllResBriefs(rail)
 }
 
+// misoapi-http: GET /open/api/resource/tree
+// misoapi-desc: List all resources as a tree based on the colon-separated namespaces of resource codes
+// misoapi-resource: ref(ResourceManageResources)
+func ListResTreeEp(inb *miso.Inbound) ([]ResTreeNode, error) {
+	rail := inb.Rail()
+	return ListResTree(rail)
+}
+
+// misoapi-http: POST /open/api/path/access/explain
+// misoapi-desc: Admin explain access decision of a role or user to a path
+// misoapi-resource: ref(ResourceManageResources)
+func AdminExplainAccessEp(inb *miso.Inbound, req ExplainAccessReq) (ExplainAccessResp, error) {
+	rail := inb.Rail()
+	return ExplainAccess(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: POST /open/api/permission/simulate
+// misoapi-desc: Admin simulate permission change without applying it, returns the roles and users affected
+// misoapi-resource: ref(ResourceManageResources)
+func AdminSimulateChangeEp(inb *miso.Inbound, req SimulateChangeReq) (SimulateChangeResp, error) {
+	rail := inb.Rail()
+	return SimulateChange(rail, mysql.GetMySQL(), req)
+}
+
+// misoapi-http: GET /open/api/permission/matrix
+// misoapi-desc: Admin export effective permission matrix (role -> resources -> paths, user -> roles), query parameters: format (json or csv), view (roles, users or unreachable, for csv only), group and resCode
+// misoapi-resource: ref(ResourceManageResources)
+func AdminExportPermMatrixEp(inb *miso.Inbound) {
+	rail := inb.Rail()
+	format, view := inb.Query("format"), inb.Query("view")
+	if err := checkPermMatrixView(view); err != nil {
+		inb.HandleResult(nil, err)
+		return
+	}
+	m, err := BuildPermMatrix(rail, mysql.GetMySQL(), PermMatrixReq{Group: inb.Query("group"), ResCode: inb.Query("resCode")})
+	if err != nil || format != "csv" {
+		inb.HandleResult(m, err)
+		return
+	}
+
+	w, _ := inb.Unwrap()
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="permission-matrix.csv"`)
+	if err := WritePermMatrixCsv(w, m, view); err != nil {
+		rail.Errorf("Failed to write permission matrix csv, %v", err)
+	}
+}
+
 // misoapi-http: POST /open/api/role/resource/add
-// misoapi-desc: Admin add resource to role
+// misoapi-desc: Admin add resource to role, wildcard pattern such as 'postbox:*' matches all resources under the namespace
 // misoapi-resource: ref(ResourceManageResources)
 func AdminBindRoleResEp(inb *miso.Inbound, req AddRoleResReq) (any, error) {
 	rail := inb.Rail()
@@ -368,7 +890,7 @@ func AdminBindRoleResEp(inb *miso.Inbound, req AddRoleResReq) (any, error) {
 // misoapi-resource: ref(ResourceManageResources)
 func AdminUnbindRoleResEp(inb *miso.Inbound, req RemoveRoleResReq) (any, error) {
 	rail := inb.Rail()
-	return nil, RemoveResFromRole(rail, req)
+	return nil, RemoveResFromRole(rail, req, common.GetUser(rail))
 }
 
 // misoapi-http: POST /open/api/role/add
@@ -380,6 +902,38 @@ func AdminAddRoleEp(inb *miso.Inbound, req AddRoleReq) (any, error) {
 	return nil, AddRole(rail, req, user)
 }
 
+// misoapi-http: POST /open/api/role/update
+// misoapi-desc: Admin update role
+// misoapi-resource: ref(ResourceManageResources)
+func AdminUpdateRoleEp(inb *miso.Inbound, req UpdateRoleReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UpdateRole(rail, req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/role/clone
+// misoapi-desc: Admin clone role, including its resources
+// misoapi-resource: ref(ResourceManageResources)
+func AdminCloneRoleEp(inb *miso.Inbound, req CloneRoleReq) (CloneRoleRes, error) {
+	rail := inb.Rail()
+	return CloneRole(rail, req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/role/delete
+// misoapi-desc: Admin delete role, users that still hold the role are moved to the replacement role
+// misoapi-resource: ref(ResourceManageResources)
+func AdminDeleteRoleEp(inb *miso.Inbound, req DeleteRoleReq) (any, error) {
+	rail := inb.Rail()
+	return nil, DeleteRole(rail, req, common.GetUser(rail))
+}
+
+// misoapi-http: POST /open/api/role/parent/update
+// misoapi-desc: Admin update parent of role, resources of the parent role are inherited
+// misoapi-resource: ref(ResourceManageResources)
+func AdminUpdateRoleParentEp(inb *miso.Inbound, req UpdateRoleParentReq) (any, error) {
+	rail := inb.Rail()
+	return nil, UpdateRoleParent(rail, req, common.GetUser(rail))
+}
+
 // misoapi-http: POST /open/api/role/list
 // misoapi-desc: Admin list roles
 // misoapi-resource: ref(ResourceManageResources)
@@ -505,6 +1059,23 @@ func ItnCheckResourceAccessEp(inb *miso.Inbound, req TestResAccessReq) (TestResA
 	return TestResourceAccess(rail, req)
 }
 
+// misoapi-http: POST /remote/path/resource/access-test/batch
+// misoapi-desc: Validate resource access of multiple paths
+func ItnBatchCheckResourceAccessEp(inb *miso.Inbound, req BatchTestResAccessReq) (BatchTestResAccessResp, error) {
+	rail := inb.Rail()
+	timer := miso.NewHistTimer(batchAccessCheckHisto)
+	defer timer.ObserveDuration()
+	return BatchTestResourceAccess(rail, req)
+}
+
+// misoapi-http: POST /remote/token/revoked
+// misoapi-desc: Check whether the token is revoked, gateways that verify tokens locally should check it as well
+func ItnCheckTokenRevokedEp(inb *miso.Inbound, req api.CheckTokenRevokedReq) (api.CheckTokenRevokedRes, error) {
+	rail := inb.Rail()
+	revoked, err := IsTokenRevoked(rail, req.UserNo, req.IssuedAt)
+	return api.CheckTokenRevokedRes{Revoked: revoked}, err
+}
+
 // misoapi-http: POST /remote/path/add
 // misoapi-desc: Report endpoint info
 func ItnReportPathEp(inb *miso.Inbound, req CreatePathReq) (any, error) {
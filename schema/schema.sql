@@ -14,9 +14,18 @@ CREATE TABLE IF NOT EXISTS user_vault.user (
   `is_del` tinyint NOT NULL DEFAULT '0' COMMENT '0-normal, 1-deleted',
   `user_no` varchar(32) NOT NULL COMMENT 'user no',
   `role_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'role no',
+  `reject_reason` varchar(255) NOT NULL DEFAULT '' COMMENT 'reason of registration rejection',
+  `email` varchar(255) NOT NULL DEFAULT '' COMMENT 'email',
+  `display_name` varchar(64) NOT NULL DEFAULT '' COMMENT 'display name',
+  `phone` varchar(32) NOT NULL DEFAULT '' COMMENT 'phone number',
+  `attributes` varchar(2000) NOT NULL DEFAULT '' COMMENT 'custom attributes in json',
+  `email_verified` tinyint NOT NULL DEFAULT '0' COMMENT 'whether email is verified',
+  `email_notification` tinyint NOT NULL DEFAULT '0' COMMENT 'whether notifications are also delivered by email',
+  `del_time` datetime DEFAULT NULL COMMENT 'when the user is deleted',
   PRIMARY KEY (`id`),
   UNIQUE KEY `username` (`username`),
-  UNIQUE KEY `user_no` (`user_no`)
+  UNIQUE KEY `user_no` (`user_no`),
+  KEY `role_no_idx` (`role_no`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='User';
 
 CREATE TABLE IF NOT EXISTS user_vault.user_key (
@@ -46,6 +55,7 @@ CREATE TABLE IF NOT EXISTS user_vault.access_log (
   `url` varchar(255) DEFAULT '' COMMENT 'request url',
   `user_agent` varchar(512) NOT NULL DEFAULT '' COMMENT 'User Agent',
   `success` tinyint(1) DEFAULT '1' COMMENT 'login was successful',
+  `login_method` varchar(32) NOT NULL DEFAULT 'PASSWORD' COMMENT 'login method: PASSWORD, EMAIL_CODE, EMAIL_LINK',
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='access log';
 
@@ -57,6 +67,7 @@ CREATE TABLE IF NOT EXISTS user_vault.path (
   `method` varchar(10) NOT NULL DEFAULT ''  COMMENT 'http method',
   `url` varchar(128) NOT NULL DEFAULT '' COMMENT 'path url',
   `ptype` varchar(10) NOT NULL DEFAULT '' COMMENT 'path type: PROTECTED, PUBLIC',
+  `res_mode` varchar(3) NOT NULL DEFAULT 'ANY' COMMENT 'ANY: any of the resources is required, ALL: all of the resources are required',
   `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
   `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
   `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
@@ -96,6 +107,11 @@ CREATE TABLE IF NOT EXISTS user_vault.role_resource (
   `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
   `role_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'role no',
   `res_code` varchar(32) NOT NULL DEFAULT '' COMMENT 'resource code',
+  `effect` varchar(5) NOT NULL DEFAULT 'ALLOW' COMMENT 'ALLOW, DENY',
+  `res_condition` varchar(255) NOT NULL DEFAULT '' COMMENT 'condition on request attributes, the grant only applies when the condition is satisfied',
+  `valid_from` datetime DEFAULT NULL COMMENT 'when the grant becomes valid, NULL means immediately',
+  `valid_until` datetime DEFAULT NULL COMMENT 'when the grant expires, NULL means never',
+  `expiry_notified` tinyint NOT NULL DEFAULT '0' COMMENT 'whether grantee is notified before expiry',
   `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
   `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
   `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
@@ -109,6 +125,8 @@ CREATE TABLE IF NOT EXISTS user_vault.role (
   `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
   `role_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'role no',
   `name` varchar(32) NOT NULL DEFAULT '' COMMENT 'name of role',
+  `parent_role_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'parent role no, resources of parent role are inherited',
+  `owner_user_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'owner of personal role, resources of approved access requests are bound to it',
   `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
   `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
   `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
@@ -154,5 +172,100 @@ CREATE TABLE IF NOT EXISTS user_vault.site_password (
   KEY `user_username_idx` (`user_no`, `username`)
 ) ENGINE=InnoDB COMMENT='Personal passwords for different sites';
 
+CREATE TABLE IF NOT EXISTS user_vault.username_history (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no',
+  `username` varchar(50) NOT NULL COMMENT 'previous username',
+  `new_username` varchar(50) NOT NULL COMMENT 'new username',
+  `reserved_until` datetime NOT NULL COMMENT 'previous username is reserved for the user until',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `created_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  KEY `user_no_idx` (`user_no`),
+  KEY `username_idx` (`username`)
+) ENGINE=InnoDB COMMENT='Username history';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_role (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no',
+  `role_no` varchar(32) NOT NULL COMMENT 'role no',
+  `valid_from` datetime DEFAULT NULL COMMENT 'when the grant becomes valid, NULL means immediately',
+  `valid_until` datetime DEFAULT NULL COMMENT 'when the grant expires, NULL means never',
+  `expiry_notified` tinyint NOT NULL DEFAULT '0' COMMENT 'whether grantee is notified before expiry',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_role_uk` (`user_no`, `role_no`),
+  KEY `role_no_idx` (`role_no`)
+) ENGINE=InnoDB COMMENT='Additional roles granted to user, the primary role is user.role_no';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_group (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `group_no` varchar(32) NOT NULL COMMENT 'group no',
+  `name` varchar(64) NOT NULL COMMENT 'group name',
+  `description` varchar(255) NOT NULL DEFAULT '' COMMENT 'description',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
+  `update_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who updated this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_no_uk` (`group_no`),
+  UNIQUE KEY `name_uk` (`name`)
+) ENGINE=InnoDB COMMENT='User groups';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_group_member (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `group_no` varchar(32) NOT NULL COMMENT 'group no',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_user_uk` (`group_no`, `user_no`),
+  KEY `user_no_idx` (`user_no`)
+) ENGINE=InnoDB COMMENT='User group members';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_group_role (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `group_no` varchar(32) NOT NULL COMMENT 'group no',
+  `role_no` varchar(32) NOT NULL COMMENT 'role no',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_role_uk` (`group_no`, `role_no`),
+  KEY `role_no_idx` (`role_no`)
+) ENGINE=InnoDB COMMENT='Roles granted to user groups';
+
+CREATE TABLE IF NOT EXISTS user_vault.access_request (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `request_no` varchar(32) NOT NULL COMMENT 'request no',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no of requester',
+  `username` varchar(255) NOT NULL DEFAULT '' COMMENT 'username of requester',
+  `res_code` varchar(32) NOT NULL COMMENT 'requested resource code',
+  `justification` varchar(255) NOT NULL DEFAULT '' COMMENT 'why the access is needed',
+  `duration_hours` int NOT NULL DEFAULT '0' COMMENT 'how long the access is needed in hours, 0 for permanent access',
+  `status` varchar(10) NOT NULL DEFAULT 'PENDING' COMMENT 'PENDING, APPROVED, DENIED, CANCELLED',
+  `reviewer` varchar(255) NOT NULL DEFAULT '' COMMENT 'who reviewed the request',
+  `review_remark` varchar(255) NOT NULL DEFAULT '' COMMENT 'remark of reviewer',
+  `review_time` datetime DEFAULT NULL COMMENT 'when the request is reviewed',
+  `valid_until` datetime DEFAULT NULL COMMENT 'when the granted access expires',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `request_no_uk` (`request_no`),
+  KEY `user_no_idx` (`user_no`),
+  KEY `status_idx` (`status`)
+) ENGINE=InnoDB COMMENT='Access requests';
+
+CREATE TABLE IF NOT EXISTS user_vault.admin_scope (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no of delegated admin',
+  `scope_type` varchar(10) NOT NULL COMMENT 'ROLE, RES_PREFIX',
+  `scope_value` varchar(32) NOT NULL COMMENT 'role no or resource code prefix',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_scope_uk` (`user_no`, `scope_type`, `scope_value`)
+) ENGINE=InnoDB COMMENT='Scopes of delegated admins, admins without any scope are not restricted';
+
 -- default one for administrator, with this role, all paths can be accessed
 INSERT INTO user_vault.role(role_no, name) VALUES ('role_554107924873216177918', 'Super Administrator');
\ No newline at end of file
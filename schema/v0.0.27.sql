@@ -0,0 +1,126 @@
+use user_vault;
+
+alter table user add column `reject_reason` varchar(255) NOT NULL DEFAULT '' COMMENT 'reason of registration rejection';
+alter table user add column `email` varchar(255) NOT NULL DEFAULT '' COMMENT 'email',
+  add column `display_name` varchar(64) NOT NULL DEFAULT '' COMMENT 'display name',
+  add column `phone` varchar(32) NOT NULL DEFAULT '' COMMENT 'phone number',
+  add column `attributes` varchar(2000) NOT NULL DEFAULT '' COMMENT 'custom attributes in json';
+alter table user add column `email_verified` tinyint NOT NULL DEFAULT '0' COMMENT 'whether email is verified',
+  add column `email_notification` tinyint NOT NULL DEFAULT '0' COMMENT 'whether notifications are also delivered by email';
+alter table user add column `del_time` datetime DEFAULT NULL COMMENT 'when the user is deleted';
+alter table access_log add column `login_method` varchar(32) NOT NULL DEFAULT 'PASSWORD' COMMENT 'login method: PASSWORD, EMAIL_CODE, EMAIL_LINK';
+
+CREATE TABLE IF NOT EXISTS user_vault.username_history (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no',
+  `username` varchar(50) NOT NULL COMMENT 'previous username',
+  `new_username` varchar(50) NOT NULL COMMENT 'new username',
+  `reserved_until` datetime NOT NULL COMMENT 'previous username is reserved for the user until',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `created_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  KEY `user_no_idx` (`user_no`),
+  KEY `username_idx` (`username`)
+) ENGINE=InnoDB COMMENT='Username history';
+
+-- usernames are normalized (NFKC and case folding) since v0.0.27, the following reports usernames that collide
+-- ignoring case, see also GET /open/api/user/username/collisions, which also covers unicode normalization
+--
+-- SELECT LOWER(username) normalized, GROUP_CONCAT(username) usernames, count(*) cnt
+-- FROM user WHERE is_del = 0
+-- GROUP BY LOWER(username) HAVING cnt > 1;
+
+CREATE TABLE IF NOT EXISTS user_vault.user_role (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no',
+  `role_no` varchar(32) NOT NULL COMMENT 'role no',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_role_uk` (`user_no`, `role_no`),
+  KEY `role_no_idx` (`role_no`)
+) ENGINE=InnoDB COMMENT='Additional roles granted to user, the primary role is user.role_no';
+
+alter table role add column `parent_role_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'parent role no, resources of parent role are inherited';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_group (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `group_no` varchar(32) NOT NULL COMMENT 'group no',
+  `name` varchar(64) NOT NULL COMMENT 'group name',
+  `description` varchar(255) NOT NULL DEFAULT '' COMMENT 'description',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
+  `update_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who updated this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_no_uk` (`group_no`),
+  UNIQUE KEY `name_uk` (`name`)
+) ENGINE=InnoDB COMMENT='User groups';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_group_member (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `group_no` varchar(32) NOT NULL COMMENT 'group no',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_user_uk` (`group_no`, `user_no`),
+  KEY `user_no_idx` (`user_no`)
+) ENGINE=InnoDB COMMENT='User group members';
+
+CREATE TABLE IF NOT EXISTS user_vault.user_group_role (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `group_no` varchar(32) NOT NULL COMMENT 'group no',
+  `role_no` varchar(32) NOT NULL COMMENT 'role no',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_role_uk` (`group_no`, `role_no`),
+  KEY `role_no_idx` (`role_no`)
+) ENGINE=InnoDB COMMENT='Roles granted to user groups';
+
+alter table role_resource add column `valid_from` datetime DEFAULT NULL COMMENT 'when the grant becomes valid, NULL means immediately',
+  add column `valid_until` datetime DEFAULT NULL COMMENT 'when the grant expires, NULL means never',
+  add column `expiry_notified` tinyint NOT NULL DEFAULT '0' COMMENT 'whether grantee is notified before expiry';
+alter table user_role add column `valid_from` datetime DEFAULT NULL COMMENT 'when the grant becomes valid, NULL means immediately',
+  add column `valid_until` datetime DEFAULT NULL COMMENT 'when the grant expires, NULL means never',
+  add column `expiry_notified` tinyint NOT NULL DEFAULT '0' COMMENT 'whether grantee is notified before expiry';
+
+alter table role add column `owner_user_no` varchar(32) NOT NULL DEFAULT '' COMMENT 'owner of personal role, resources of approved access requests are bound to it';
+
+CREATE TABLE IF NOT EXISTS user_vault.access_request (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `request_no` varchar(32) NOT NULL COMMENT 'request no',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no of requester',
+  `username` varchar(255) NOT NULL DEFAULT '' COMMENT 'username of requester',
+  `res_code` varchar(32) NOT NULL COMMENT 'requested resource code',
+  `justification` varchar(255) NOT NULL DEFAULT '' COMMENT 'why the access is needed',
+  `duration_hours` int NOT NULL DEFAULT '0' COMMENT 'how long the access is needed in hours, 0 for permanent access',
+  `status` varchar(10) NOT NULL DEFAULT 'PENDING' COMMENT 'PENDING, APPROVED, DENIED, CANCELLED',
+  `reviewer` varchar(255) NOT NULL DEFAULT '' COMMENT 'who reviewed the request',
+  `review_remark` varchar(255) NOT NULL DEFAULT '' COMMENT 'remark of reviewer',
+  `review_time` datetime DEFAULT NULL COMMENT 'when the request is reviewed',
+  `valid_until` datetime DEFAULT NULL COMMENT 'when the granted access expires',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `update_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'when the record is updated',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `request_no_uk` (`request_no`),
+  KEY `user_no_idx` (`user_no`),
+  KEY `status_idx` (`status`)
+) ENGINE=InnoDB COMMENT='Access requests';
+
+CREATE TABLE IF NOT EXISTS user_vault.admin_scope (
+  `id` int unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `user_no` varchar(32) NOT NULL COMMENT 'user no of delegated admin',
+  `scope_type` varchar(10) NOT NULL COMMENT 'ROLE, RES_PREFIX',
+  `scope_value` varchar(32) NOT NULL COMMENT 'role no or resource code prefix',
+  `create_time` timestamp NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'when the record is created',
+  `create_by` varchar(255) NOT NULL DEFAULT '' COMMENT 'who created this record',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_scope_uk` (`user_no`, `scope_type`, `scope_value`)
+) ENGINE=InnoDB COMMENT='Scopes of delegated admins, admins without any scope are not restricted';
+
+alter table role_resource add column `effect` varchar(5) NOT NULL DEFAULT 'ALLOW' COMMENT 'ALLOW, DENY' after `res_code`;
+alter table role_resource add column `res_condition` varchar(255) NOT NULL DEFAULT '' COMMENT 'condition on request attributes, the grant only applies when the condition is satisfied' after `effect`;
+alter table path add column `res_mode` varchar(3) NOT NULL DEFAULT 'ANY' COMMENT 'ANY: any of the resources is required, ALL: all of the resources are required' after `ptype`;
+alter table user add index `role_no_idx` (`role_no`);